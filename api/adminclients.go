@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+)
+
+const adminClientsURL = "/admin/clients"
+
+// ClientResponse is a client as returned by the admin endpoints.
+// The secret is only returned when it is generated.
+type ClientResponse struct {
+	legitima.Client
+	IsPublic bool   `json:"public"`
+	TokenTTL int64  `json:"token_ttl,omitempty"`
+	Secret   string `json:"client_secret,omitempty"`
+}
+
+// NewClientResponse returns the response of the given client.
+func NewClientResponse(client legitima.Client, secret string) ClientResponse {
+	return ClientResponse{
+		Client:   client,
+		IsPublic: client.Public(),
+		TokenTTL: int64(client.TokenTTL.Seconds()),
+		Secret:   secret,
+	}
+}
+
+// SetupAdminClients sets up the admin endpoints managing the clients of the authorization server.
+func SetupAdminClients(mux *http.ServeMux, verifier Verifier, tokenCfg TokenConfig, storage Storage) {
+	handler := AdminClientsHandler(verifier, tokenCfg, storage)
+	mux.Handle(adminClientsURL, handler)
+	mux.Handle(adminClientsURL+"/", handler)
+}
+
+// AdminClientsHandler handles the admin endpoints managing the clients:
+//
+//	GET    /admin/clients             lists the clients
+//	POST   /admin/clients             creates a client from a JSON ClientConfig
+//	GET    /admin/clients/{id}        returns a client
+//	DELETE /admin/clients/{id}        deletes a client
+//	POST   /admin/clients/{id}/secret rotates the secret of a client
+func AdminClientsHandler(verifier Verifier, tokenCfg TokenConfig, storage Storage) http.Handler {
+	return RequireAuth(verifier, tokenCfg, storage, RequireOwnLogin())(RequireRole(legitima.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, adminClientsURL), "/")
+		id, action, _ := strings.Cut(path, "/")
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			listClients(w, r, storage)
+		case id == "" && r.Method == http.MethodPost:
+			createClient(w, r, storage)
+		case id != "" && action == "" && r.Method == http.MethodGet:
+			getClient(w, r, id, storage)
+		case id != "" && action == "" && r.Method == http.MethodDelete:
+			deleteClient(w, r, id, storage)
+		case id != "" && action == "secret" && r.Method == http.MethodPost:
+			rotateClientSecret(w, r, id, storage)
+		case id != "" && action != "" && action != "secret":
+			sendErr(ctx, w, errors.New("not found"), http.StatusNotFound)
+		default:
+			sendErr(ctx, w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})))
+}
+
+func listClients(w http.ResponseWriter, r *http.Request, registry ClientRegistry) {
+	ctx := r.Context()
+
+	clients, err := registry.Clients()
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	res := []ClientResponse{}
+	for _, client := range clients {
+		res = append(res, NewClientResponse(client, ""))
+	}
+	sendJSON(ctx, w, http.StatusOK, res)
+}
+
+func createClient(w http.ResponseWriter, r *http.Request, registry ClientRegistry) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	var cfg ClientConfig
+	// Unknown fields are rejected so a secret chosen by the admin is never silently ignored.
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&cfg)
+	if err != nil {
+		sendErr(ctx, w, fmt.Errorf("%w: %v", ErrInvalidClientConfig, err), http.StatusBadRequest)
+		return
+	}
+	client, secret, err := CreateClient(registry, cfg)
+	switch {
+	case errors.Is(err, ErrInvalidClientConfig):
+		sendErr(ctx, w, err, http.StatusBadRequest)
+		return
+	case errors.Is(err, legitima.ErrAlreadyExists):
+		sendErr(ctx, w, err, http.StatusConflict)
+		return
+	case err != nil:
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	usr, _ := UserFromContext(ctx)
+	log.Info("client created", "client_id", client.ID, "admin", usr.Email)
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusCreated, NewClientResponse(*client, secret))
+}
+
+func getClient(w http.ResponseWriter, r *http.Request, id string, registry ClientRegistry) {
+	ctx := r.Context()
+
+	client, err := registry.ClientByID(id)
+	if errors.Is(err, legitima.ErrNotFound) {
+		sendErr(ctx, w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	sendJSON(ctx, w, http.StatusOK, NewClientResponse(*client, ""))
+}
+
+func deleteClient(w http.ResponseWriter, r *http.Request, id string, registry ClientRegistry) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	err := registry.DeleteClient(id)
+	if errors.Is(err, legitima.ErrNotFound) {
+		sendErr(ctx, w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	usr, _ := UserFromContext(ctx)
+	log.Info("client deleted", "client_id", id, "admin", usr.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func rotateClientSecret(w http.ResponseWriter, r *http.Request, id string, registry ClientRegistry) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	secret, err := RotateClientSecret(registry, id)
+	switch {
+	case errors.Is(err, legitima.ErrNotFound):
+		sendErr(ctx, w, err, http.StatusNotFound)
+		return
+	case errors.Is(err, ErrInvalidClientConfig):
+		sendErr(ctx, w, err, http.StatusBadRequest)
+		return
+	case err != nil:
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	usr, _ := UserFromContext(ctx)
+	log.Info("client secret rotated", "client_id", id, "admin", usr.Email)
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}{id, secret})
+}
@@ -0,0 +1,216 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+type adminClientsServer struct {
+	*usersServer
+}
+
+func newAdminClientsServer(t *testing.T) *adminClientsServer {
+	t.Helper()
+	s := newUsersServer(t)
+	s.handler = api.AdminClientsHandler(s.key, api.DefaultTokenConfig(), s.storage)
+	return &adminClientsServer{s}
+}
+
+func (s *adminClientsServer) create(t *testing.T, cfg string) api.ClientResponse {
+	t.Helper()
+	var res api.ClientResponse
+	s.post(t, "/admin/clients", "admin@gmail.com", cfg, &res)
+	return res
+}
+
+// authenticate tells if the client credentials are accepted by the token endpoint.
+func (s *adminClientsServer) authenticate(t *testing.T, clientID, secret string) bool {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(url.Values{"grant_type": {"unknown"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(clientID, secret)
+	w := httptest.NewRecorder()
+	api.TokenHandler(newKey(t), api.DefaultTokenConfig(), s.storage).ServeHTTP(w, r)
+	return w.Code != http.StatusUnauthorized
+}
+
+func TestAdminClients(t *testing.T) {
+	s := newAdminClientsServer(t)
+
+	client := s.create(t, `{"id": "app", "name": "The App", "redirect_uris": ["https://app.example.com/callback"], "token_ttl": 600}`)
+	if client.ID != "app" || client.IsPublic || client.Secret == "" || client.TokenTTL != 600 {
+		t.Fatalf("expected a confidential client with a secret, got %+v", client)
+	}
+	if len(client.GrantTypes) != 1 || client.GrantTypes[0] != "authorization_code" || len(client.Scopes) != 3 {
+		t.Fatalf("expected the default grant types and scopes, got %+v", client)
+	}
+	if !s.authenticate(t, "app", client.Secret) {
+		t.Fatal("expected the client secret to authenticate")
+	}
+
+	spa := s.create(t, `{"name": "SPA", "public": true, "redirect_uris": ["http://localhost:3000/callback", "com.example.app:/callback"]}`)
+	if spa.ID == "" || !spa.IsPublic || spa.Secret != "" {
+		t.Fatalf("expected a public client with a generated id, got %+v", spa)
+	}
+
+	w := s.do(http.MethodGet, "/admin/clients", "admin@gmail.com", nil)
+	var clients []api.ClientResponse
+	err := json.NewDecoder(w.Body).Decode(&clients)
+	if err != nil {
+		t.Fatalf("failed to decode clients: %v", err)
+	}
+	if len(clients) != 2 {
+		t.Fatalf("expected 2 clients, got %+v", clients)
+	}
+	for _, c := range clients {
+		if c.Secret != "" {
+			t.Fatalf("expected no secrets in the list, got %+v", c)
+		}
+	}
+
+	w = s.do(http.MethodPost, "/admin/clients/app/secret", "admin@gmail.com", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var rotated struct {
+		ClientSecret string `json:"client_secret"`
+	}
+	err = json.NewDecoder(w.Body).Decode(&rotated)
+	if err != nil {
+		t.Fatalf("failed to decode secret: %v", err)
+	}
+	if s.authenticate(t, "app", client.Secret) || !s.authenticate(t, "app", rotated.ClientSecret) {
+		t.Fatal("expected only the rotated secret to authenticate")
+	}
+	if w := s.do(http.MethodPost, "/admin/clients/"+spa.ID+"/secret", "admin@gmail.com", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 rotating a public client, got %d", w.Code)
+	}
+
+	if w := s.do(http.MethodDelete, "/admin/clients/app", "admin@gmail.com", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if w := s.do(http.MethodGet, "/admin/clients/app", "admin@gmail.com", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after deleting, got %d", w.Code)
+	}
+	if w := s.do(http.MethodDelete, "/admin/clients/app", "admin@gmail.com", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 deleting twice, got %d", w.Code)
+	}
+}
+
+func TestAdminClients_Forbidden(t *testing.T) {
+	s := newAdminClientsServer(t)
+	if w := s.do(http.MethodGet, "/admin/clients", "jj@gmail.com", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a non admin, got %d", w.Code)
+	}
+}
+
+func TestAdminClients_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+	}{
+		{name: "malformed", cfg: `{`},
+		{name: "no redirect uris", cfg: `{"id": "app"}`},
+		{name: "relative redirect uri", cfg: `{"redirect_uris": ["/callback"]}`},
+		{name: "plain http", cfg: `{"redirect_uris": ["http://app.example.com/callback"]}`},
+		{name: "fragment", cfg: `{"redirect_uris": ["https://app.example.com/callback#x"]}`},
+		{name: "javascript redirect uri", cfg: `{"redirect_uris": ["javascript:alert(document.cookie)"]}`},
+		{name: "data redirect uri", cfg: `{"redirect_uris": ["data:text/html,<script>alert(1)</script>"]}`},
+		{name: "custom scheme without domain", cfg: `{"redirect_uris": ["myapp:/callback"]}`},
+		{name: "unsupported grant type", cfg: `{"redirect_uris": ["https://app.example.com/callback"], "grant_types": ["password"]}`},
+		{name: "public service", cfg: `{"public": true, "grant_types": ["client_credentials"]}`},
+		{name: "invalid id", cfg: `{"id": "a/b", "redirect_uris": ["https://app.example.com/callback"]}`},
+		{name: "chosen secret", cfg: `{"redirect_uris": ["https://app.example.com/callback"], "secret": "password"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAdminClientsServer(t)
+			w := s.do(http.MethodPost, "/admin/clients", "admin@gmail.com", strings.NewReader(tt.cfg))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", w.Code)
+			}
+		})
+	}
+
+	s := newAdminClientsServer(t)
+	s.create(t, `{"id": "app", "redirect_uris": ["https://app.example.com/callback"]}`)
+	w := s.do(http.MethodPost, "/admin/clients", "admin@gmail.com", strings.NewReader(`{"id": "app", "redirect_uris": ["https://app.example.com/callback"]}`))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a taken id, got %d", w.Code)
+	}
+}
+
+// fakeClients is an in memory api.ClientRegistry.
+type fakeClients struct {
+	mu      sync.Mutex
+	clients map[string]legitima.Client
+}
+
+func newFakeClients() *fakeClients {
+	return &fakeClients{
+		clients: map[string]legitima.Client{},
+	}
+}
+
+func (s *fakeClients) SaveClient(client legitima.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[client.ID]; ok {
+		return fmt.Errorf("save client: %w", legitima.ErrAlreadyExists)
+	}
+	s.clients[client.ID] = client
+	return nil
+}
+
+func (s *fakeClients) ClientByID(id string) (*legitima.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[id]
+	if !ok {
+		return nil, fmt.Errorf("client by id: %w", legitima.ErrNotFound)
+	}
+	return &client, nil
+}
+
+func (s *fakeClients) Clients() ([]legitima.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var clients []legitima.Client
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients, nil
+}
+
+func (s *fakeClients) UpdateClientSecret(id, secretHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[id]
+	if !ok {
+		return fmt.Errorf("update client secret: %w", legitima.ErrNotFound)
+	}
+	client.SecretHash = secretHash
+	s.clients[id] = client
+	return nil
+}
+
+func (s *fakeClients) DeleteClient(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[id]; !ok {
+		return fmt.Errorf("delete client: %w", legitima.ErrNotFound)
+	}
+	delete(s.clients, id)
+	return nil
+}
@@ -0,0 +1,402 @@
+package api
+
+import (
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+	"golang.org/x/oauth2"
+)
+
+const (
+	authorizeURL = "/oauth/authorize"
+	tokenURL     = "/oauth/token"
+	// consentCookie binds the consent form to the browser it was shown to.
+	consentCookie = "oauth_consent"
+	// authorizationCodeTTL is how long a client can wait to exchange an authorization code.
+	authorizationCodeTTL = time.Minute
+)
+
+// AuthorizationCodeStorage persists the authorization codes until they are exchanged.
+type AuthorizationCodeStorage interface {
+	SaveAuthorizationCode(code legitima.AuthorizationCode) error
+	ConsumeAuthorizationCode(hash string) (*legitima.AuthorizationCode, error)
+}
+
+// authorizationRequest holds the parameters of an authorization request, as defined in RFC 6749 4.1.1.
+type authorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	// defaultRedirectURI tells RedirectURI is the single registered one, the request had none.
+	defaultRedirectURI bool
+}
+
+func newAuthorizationRequest(values url.Values) authorizationRequest {
+	return authorizationRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Nonce:               values.Get("nonce"),
+	}
+}
+
+// values returns the parameters of the request, to be carried by the consent form.
+func (a authorizationRequest) values() url.Values {
+	values := url.Values{}
+	redirectURI := a.RedirectURI
+	if a.defaultRedirectURI {
+		redirectURI = ""
+	}
+	for k, v := range map[string]string{
+		"response_type":         a.ResponseType,
+		"client_id":             a.ClientID,
+		"redirect_uri":          redirectURI,
+		"scope":                 a.Scope,
+		"state":                 a.State,
+		"code_challenge":        a.CodeChallenge,
+		"code_challenge_method": a.CodeChallengeMethod,
+		"nonce":                 a.Nonce,
+	} {
+		if v != "" {
+			values.Set(k, v)
+		}
+	}
+	return values
+}
+
+// authorizationError is an error sent back to the client on its redirect URI.
+type authorizationError struct {
+	code string
+	err  error
+}
+
+func (e *authorizationError) Error() string {
+	return e.err.Error()
+}
+
+// validate checks the client and the redirect URI, which must be valid before redirecting any error to the client.
+// The other errors are returned as authorizationError.
+func (a *authorizationRequest) validate(clients ClientStorage) (*legitima.Client, error) {
+	client, err := clients.ClientByID(a.ClientID)
+	if errors.Is(err, legitima.ErrNotFound) {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		a.RedirectURI = client.RedirectURIs[0]
+		a.defaultRedirectURI = true
+	}
+	if !client.HasRedirectURI(a.RedirectURI) {
+		return nil, errors.New("invalid redirect uri")
+	}
+
+	switch {
+	case a.ResponseType != "code":
+		return client, &authorizationError{"unsupported_response_type", fmt.Errorf("unsupported response type %q", a.ResponseType)}
+	case a.CodeChallenge == "" && client.Public():
+		return client, &authorizationError{"invalid_request", errors.New("public clients must send a code challenge")}
+	case a.CodeChallenge != "" && a.CodeChallengeMethod != "S256":
+		return client, &authorizationError{"invalid_request", errors.New("code challenge method must be S256")}
+	case !client.AllowsGrantType(grantAuthorizationCode):
+		return client, &authorizationError{"unauthorized_client", errors.New("authorization code grant not allowed")}
+	case !client.AllowsScope(a.Scope):
+		return client, &authorizationError{"invalid_scope", fmt.Errorf("scope %q not allowed", a.Scope)}
+	}
+	return client, nil
+}
+
+// redirect sends the user back to the client with the given parameters and the state of the request.
+func (a authorizationRequest) redirect(w http.ResponseWriter, r *http.Request, params url.Values) {
+	if a.State != "" {
+		params.Set("state", a.State)
+	}
+	u, err := url.Parse(a.RedirectURI)
+	if err != nil {
+		sendErr(r.Context(), w, err, http.StatusInternalServerError)
+		return
+	}
+	query := u.Query()
+	for k := range params {
+		query.Set(k, params.Get(k))
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// SetupAuthorizationServer sets up the OAuth authorization server endpoints.
+func SetupAuthorizationServer(mux *http.ServeMux, key Key, tokenCfg TokenConfig, storage Storage) {
+	mux.Handle(authorizeURL, AuthorizeHandler(key, tokenCfg, storage))
+	mux.Handle(tokenURL, TokenHandler(key, tokenCfg, storage))
+}
+
+// AuthorizeHandler handles the authorization endpoint.
+// Users that are not logged in are sent to the login first, then they are asked to consent.
+func AuthorizeHandler(verifier Verifier, tokenCfg TokenConfig, storage Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authorize(w, r, verifier, tokenCfg, storage)
+		case http.MethodPost:
+			consent(w, r, verifier, tokenCfg, storage)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+//go:embed templates/consent.html
+var consentTemplateFS embed.FS
+
+func authorize(w http.ResponseWriter, r *http.Request, verifier Verifier, tokenCfg TokenConfig, clients ClientStorage) {
+	ctx := r.Context()
+
+	req := newAuthorizationRequest(r.URL.Query())
+	client, err := req.validate(clients)
+	var authErr *authorizationError
+	if errors.As(err, &authErr) {
+		req.redirect(w, r, url.Values{"error": {authErr.code}, "error_description": {authErr.Error()}})
+		return
+	}
+	if err != nil {
+		sendLoginErr(w, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := tokenFromRequest(r, verifier, tokenCfg)
+	if err == nil && !token.IsOwnLogin() {
+		err = ErrClientToken
+	}
+	if err != nil {
+		// Google, or any other identity provider, is the upstream authentication step.
+		http.Redirect(w, r, loginURL+"?return_to="+url.QueryEscape(authorizeURL+"?"+req.values().Encode()), http.StatusFound)
+		return
+	}
+
+	csrf, err := randomString(32)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     consentCookie,
+		Value:    csrf,
+		Path:     authorizeURL,
+		MaxAge:   int(stateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	tmpl, err := template.ParseFS(consentTemplateFS, "templates/consent.html")
+	if err != nil {
+		slog.Error("failed to parse template", "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, struct {
+		Client legitima.Client
+		Email  string
+		Scopes []string
+		Params url.Values
+		CSRF   string
+	}{*client, token.Email, strings.Fields(req.Scope), req.values(), csrf})
+	if err != nil {
+		slog.Error("failed to execute template", "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func consent(w http.ResponseWriter, r *http.Request, verifier Verifier, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	err := r.ParseForm()
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusBadRequest)
+		return
+	}
+	req := newAuthorizationRequest(r.PostForm)
+	_, err = req.validate(storage)
+	if err != nil {
+		sendLoginErr(w, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := tokenFromRequest(r, verifier, tokenCfg)
+	if err == nil && !token.IsOwnLogin() {
+		err = ErrClientToken
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusUnauthorized)
+		return
+	}
+	cookie, err := r.Cookie(consentCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue("csrf"))) != 1 {
+		sendErr(ctx, w, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: consentCookie, Path: authorizeURL, MaxAge: -1, HttpOnly: true, Secure: true})
+
+	if r.PostFormValue("approve") != "true" {
+		req.redirect(w, r, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	code, err := randomString(32)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	err = storage.SaveAuthorizationCode(legitima.AuthorizationCode{
+		Hash:                hashToken(code),
+		ClientID:            req.ClientID,
+		Email:               token.Email,
+		RedirectURI:         req.RedirectURI,
+		RedirectURIProvided: !req.defaultRedirectURI,
+		Scope:               req.Scope,
+		CodeChallenge:       req.CodeChallenge,
+		Nonce:               req.Nonce,
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("client authorized", "client_id", req.ClientID, "email", token.Email)
+	req.redirect(w, r, url.Values{"code": {code}})
+}
+
+// TokenHandler handles the token endpoint of the authorization server.
+func TokenHandler(key Key, tokenCfg TokenConfig, storage Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			oauthToken(w, r, key, tokenCfg, storage)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func oauthToken(w http.ResponseWriter, r *http.Request, key Key, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+
+	client, err := authenticateOAuthClient(r, storage)
+	if errors.Is(err, ErrInvalidClient) {
+		sendOAuthErr(ctx, w, "invalid_client", err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+
+	grantType := r.PostFormValue("grant_type")
+	// Refresh tokens are only issued by the other grants to their client, which is checked instead.
+	if contains(supportedGrantTypes, grantType) && grantType != grantRefreshToken && !client.AllowsGrantType(grantType) {
+		sendOAuthErr(ctx, w, "unauthorized_client", fmt.Errorf("grant type %q not allowed", grantType), http.StatusBadRequest)
+		return
+	}
+	// Clients can only shorten the TTL: the revocations and the retired keys are kept for the service TTL.
+	if client.TokenTTL > 0 && client.TokenTTL < tokenCfg.TTL {
+		tokenCfg.TTL = client.TokenTTL
+	}
+
+	switch grantType {
+	case grantAuthorizationCode:
+		authorizationCodeGrant(w, r, client, key, tokenCfg, storage)
+	case grantClientCredentials:
+		clientCredentialsGrant(w, r, client, key, tokenCfg)
+	case grantDeviceCode:
+		deviceCodeGrant(w, r, client, key, tokenCfg, storage)
+	case grantTokenExchange:
+		tokenExchangeGrant(w, r, client, key, tokenCfg, storage)
+	case grantRefreshToken:
+		refreshTokenGrant(w, r, client, key, tokenCfg, storage)
+	default:
+		sendOAuthErr(ctx, w, "unsupported_grant_type", fmt.Errorf("unsupported grant type %q", grantType), http.StatusBadRequest)
+	}
+}
+
+// authorizationCodeGrant exchanges an authorization code for tokens, as defined in RFC 6749 4.1.3.
+func authorizationCodeGrant(w http.ResponseWriter, r *http.Request, client *legitima.Client, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	code := r.PostFormValue("code")
+	if code == "" {
+		sendOAuthErr(ctx, w, "invalid_request", errors.New("missing code"), http.StatusBadRequest)
+		return
+	}
+	stored, err := storage.ConsumeAuthorizationCode(hashToken(code))
+	if errors.Is(err, legitima.ErrNotFound) {
+		sendOAuthErr(ctx, w, "invalid_grant", errors.New("invalid code"), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+
+	verifier := r.PostFormValue("code_verifier")
+	switch {
+	case stored.ClientID != client.ID:
+		err = errors.New("code issued to another client")
+	case stored.RedirectURIProvided && stored.RedirectURI != r.PostFormValue("redirect_uri"):
+		err = errors.New("redirect uri mismatch")
+	case time.Now().After(stored.ExpiresAt):
+		err = errors.New("code expired")
+	case stored.CodeChallenge != "" && subtle.ConstantTimeCompare([]byte(oauth2.S256ChallengeFromVerifier(verifier)), []byte(stored.CodeChallenge)) != 1:
+		err = errors.New("invalid code verifier")
+	case stored.CodeChallenge == "" && verifier != "":
+		err = errors.New("code verifier without challenge")
+	}
+	if err != nil {
+		sendOAuthErr(ctx, w, "invalid_grant", err, http.StatusBadRequest)
+		return
+	}
+
+	res, refreshToken, err := issueTokens(signer, tokenCfg, storage, legitima.RefreshToken{Email: stored.Email, ClientID: client.ID, Scope: stored.Scope})
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+	err = storage.SaveRefreshToken(refreshToken)
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+	if hasScope(stored.Scope, "openid") {
+		res.IDToken, err = generateIDToken(signer, tokenCfg, client.ID, stored.Nonce, storage, stored.Email)
+		if err != nil {
+			sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Info("authorization code exchanged", "client_id", client.ID, "email", stored.Email)
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, res)
+}
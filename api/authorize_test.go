@@ -0,0 +1,532 @@
+package api_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+const (
+	appRedirectURI = "https://app.example.com/callback"
+	spaRedirectURI = "https://spa.example.com/callback"
+)
+
+// saveClients registers the confidential app client, with the app-secret secret, the public spa and cli clients
+// and the worker service, with the worker-secret secret.
+func saveClients(t *testing.T, storage *mockStorage) {
+	t.Helper()
+	secretHash := sha256.Sum256([]byte("app-secret"))
+	workerSecretHash := sha256.Sum256([]byte("worker-secret"))
+	gatewaySecretHash := sha256.Sum256([]byte("gateway-secret"))
+	clients := []legitima.Client{
+		{
+			ID:           "app",
+			Name:         "The App",
+			SecretHash:   hex.EncodeToString(secretHash[:]),
+			RedirectURIs: []string{appRedirectURI},
+			GrantTypes:   []string{"authorization_code"},
+			Scopes:       []string{"openid", "email", "profile"},
+		},
+		{
+			ID:           "spa",
+			Name:         "spa",
+			RedirectURIs: []string{spaRedirectURI, "http://localhost:3000/callback"},
+			GrantTypes:   []string{"authorization_code"},
+			Scopes:       []string{"openid", "email", "profile"},
+		},
+		{
+			ID:         "cli",
+			Name:       "The CLI",
+			GrantTypes: []string{"urn:ietf:params:oauth:grant-type:device_code"},
+			Scopes:     []string{"openid", "email", "profile"},
+		},
+		{
+			ID:         "worker",
+			Name:       "Worker",
+			SecretHash: hex.EncodeToString(workerSecretHash[:]),
+			GrantTypes: []string{"client_credentials"},
+			Scopes:     []string{"jobs:read", "jobs:write"},
+			TokenTTL:   5 * time.Minute,
+		},
+		{
+			ID:         "gateway",
+			Name:       "Gateway",
+			SecretHash: hex.EncodeToString(gatewaySecretHash[:]),
+			GrantTypes: []string{"urn:ietf:params:oauth:grant-type:token-exchange"},
+			Scopes:     []string{"jobs:read", "jobs:write"},
+		},
+	}
+	for _, client := range clients {
+		err := storage.SaveClient(client)
+		if err != nil {
+			t.Fatalf("failed to save client: %v", err)
+		}
+	}
+}
+
+// authorizeServer runs the authorization server endpoints for the user with the given access token.
+type authorizeServer struct {
+	key         api.Key
+	storage     *mockStorage
+	accessToken string
+}
+
+func newAuthorizeServer(t *testing.T) *authorizeServer {
+	t.Helper()
+	key := newKey(t)
+	accessToken, err := api.GenerateToken(key, api.DefaultTokenConfig(), "jj@example.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	storage := newMockStorage()
+	saveClients(t, storage)
+	return &authorizeServer{key: key, storage: storage, accessToken: accessToken}
+}
+
+func (s *authorizeServer) authorize(query url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil)
+	if s.accessToken != "" {
+		req.AddCookie(&http.Cookie{Name: "Authorization", Value: "Bearer " + s.accessToken})
+	}
+	w := httptest.NewRecorder()
+	api.AuthorizeHandler(s.key, api.DefaultTokenConfig(), s.storage).ServeHTTP(w, req)
+	return w
+}
+
+func (s *authorizeServer) consent(form url.Values, csrf string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "Authorization", Value: "Bearer " + s.accessToken})
+	if csrf != "" {
+		req.AddCookie(&http.Cookie{Name: "oauth_consent", Value: csrf})
+	}
+	w := httptest.NewRecorder()
+	api.AuthorizeHandler(s.key, api.DefaultTokenConfig(), s.storage).ServeHTTP(w, req)
+	return w
+}
+
+func (s *authorizeServer) token(form url.Values, basicUser, basicPassword string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicUser != "" {
+		req.SetBasicAuth(basicUser, basicPassword)
+	}
+	w := httptest.NewRecorder()
+	api.TokenHandler(s.key, api.DefaultTokenConfig(), s.storage).ServeHTTP(w, req)
+	return w
+}
+
+// approve runs the authorization request and the consent, returning the authorization code.
+func (s *authorizeServer) approve(t *testing.T, query url.Values) string {
+	t.Helper()
+	w := s.authorize(query)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected consent page, got %d: %s", w.Code, w.Body.String())
+	}
+	var csrf string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "oauth_consent" {
+			csrf = c.Value
+		}
+	}
+
+	form := url.Values{}
+	for k := range query {
+		form.Set(k, query.Get(k))
+	}
+	form.Set("csrf", csrf)
+	form.Set("approve", "true")
+	w = s.consent(form, csrf)
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d: %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse location: %v", err)
+	}
+	if location.Query().Get("state") != query.Get("state") {
+		t.Fatalf("expected state %q, got %q", query.Get("state"), location.Query().Get("state"))
+	}
+	return location.Query().Get("code")
+}
+
+func oauthErrCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var res api.OAuthError
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode oauth error: %v", err)
+	}
+	return res.Error
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func spaQuery(verifier string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"spa"},
+		"redirect_uri":          {spaRedirectURI},
+		"scope":                 {"profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func TestAuthorize_InvalidClient(t *testing.T) {
+	s := newAuthorizeServer(t)
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{name: "unknown client", query: url.Values{"response_type": {"code"}, "client_id": {"other"}}},
+		{name: "unregistered redirect uri", query: url.Values{"response_type": {"code"}, "client_id": {"app"}, "redirect_uri": {"https://evil.com/callback"}}},
+		{name: "ambiguous redirect uri", query: url.Values{"response_type": {"code"}, "client_id": {"spa"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.authorize(tt.query)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400 without redirect, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthorize_ErrorRedirect(t *testing.T) {
+	s := newAuthorizeServer(t)
+	tests := []struct {
+		name  string
+		query url.Values
+		code  string
+	}{
+		{name: "unsupported response type", query: url.Values{"response_type": {"token"}, "client_id": {"app"}}, code: "unsupported_response_type"},
+		{name: "public client without pkce", query: url.Values{"response_type": {"code"}, "client_id": {"spa"}, "redirect_uri": {spaRedirectURI}}, code: "invalid_request"},
+		{name: "plain pkce", query: url.Values{"response_type": {"code"}, "client_id": {"app"}, "code_challenge": {"plain"}}, code: "invalid_request"},
+		{name: "unregistered scope", query: url.Values{"response_type": {"code"}, "client_id": {"app"}, "scope": {"openid admin"}}, code: "invalid_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Set("state", "xyz")
+			w := s.authorize(tt.query)
+			if w.Code != http.StatusFound {
+				t.Fatalf("expected 302, got %d", w.Code)
+			}
+			location, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("failed to parse location: %v", err)
+			}
+			if got := location.Query().Get("error"); got != tt.code {
+				t.Fatalf("expected error %q, got %q", tt.code, got)
+			}
+			if location.Query().Get("state") != "xyz" {
+				t.Fatal("expected state in the error redirect")
+			}
+		})
+	}
+}
+
+func TestAuthorize_Login(t *testing.T) {
+	s := newAuthorizeServer(t)
+	s.accessToken = ""
+
+	w := s.authorize(spaQuery("verifier"))
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse location: %v", err)
+	}
+	returnTo := location.Query().Get("return_to")
+	if location.Path != "/login" || !strings.HasPrefix(returnTo, "/oauth/authorize?") {
+		t.Fatalf("expected login returning to the authorization, got %s", location)
+	}
+	if _, err := (*api.ReturnURLs)(nil).Validate(returnTo); err != nil {
+		t.Fatalf("expected a valid return url, got %v", err)
+	}
+}
+
+func TestAuthorize_CodeGrant(t *testing.T) {
+	s := newAuthorizeServer(t)
+	code := s.approve(t, spaQuery("verifier"))
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {spaRedirectURI},
+		"client_id":     {"spa"},
+		"code_verifier": {"verifier"},
+	}
+	w := s.token(form, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	if res.RefreshToken == "" || res.Scope != "profile" {
+		t.Fatalf("unexpected token response: %+v", res)
+	}
+	token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), s.key, api.DefaultTokenConfig())
+	if err != nil {
+		t.Fatalf("failed to verify access token: %v", err)
+	}
+	if token.Email != "jj@example.com" {
+		t.Fatalf("expected token of jj@example.com, got %s", token.Email)
+	}
+	if !token.HasScope("profile") || token.HasScope("email") {
+		t.Fatalf("expected the token to be granted the profile scope only, got %q", token.Claims.Scope)
+	}
+
+	// The refresh token is only redeemed by its client, keeping the granted scopes.
+	rec := httptest.NewRecorder()
+	api.RefreshHandler(s.key, api.DefaultTokenConfig(), s.storage).ServeHTTP(rec, refreshRequest(res.RefreshToken))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the refresh endpoint to reject the token of a client, got %d", rec.Code)
+	}
+	refresh := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {res.RefreshToken}}
+	if w := s.token(refresh, "app", "app-secret"); oauthErrCode(t, w) != "invalid_grant" {
+		t.Fatalf("expected invalid_grant redeeming the token of another client, got %d", w.Code)
+	}
+	refresh.Set("client_id", "spa")
+	rec = s.token(refresh, "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var refreshed api.TokenResponse
+	err = json.NewDecoder(rec.Body).Decode(&refreshed)
+	if err != nil {
+		t.Fatalf("failed to decode refresh response: %v", err)
+	}
+	token, err = api.TokenFromHeader(requestWithToken(refreshed.AccessToken), s.key, api.DefaultTokenConfig())
+	if err != nil {
+		t.Fatalf("failed to verify refreshed token: %v", err)
+	}
+	if refreshed.Scope != "profile" || !token.HasScope("profile") {
+		t.Fatalf("expected the refreshed token to keep the profile scope, got %+v", refreshed)
+	}
+
+	w = s.token(form, "", "")
+	if w.Code != http.StatusBadRequest || oauthErrCode(t, w) != "invalid_grant" {
+		t.Fatalf("expected replayed code to fail with invalid_grant, got %d", w.Code)
+	}
+}
+
+func TestAuthorize_CodeGrant_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		form     url.Values
+		user     string
+		password string
+		status   int
+		code     string
+	}{
+		{
+			name:   "wrong verifier",
+			form:   url.Values{"client_id": {"spa"}, "redirect_uri": {spaRedirectURI}, "code_verifier": {"other"}},
+			status: http.StatusBadRequest, code: "invalid_grant",
+		},
+		{
+			name:   "missing verifier",
+			form:   url.Values{"client_id": {"spa"}, "redirect_uri": {spaRedirectURI}},
+			status: http.StatusBadRequest, code: "invalid_grant",
+		},
+		{
+			name:   "wrong redirect uri",
+			form:   url.Values{"client_id": {"spa"}, "redirect_uri": {"http://localhost:3000/callback"}, "code_verifier": {"verifier"}},
+			status: http.StatusBadRequest, code: "invalid_grant",
+		},
+		{
+			name:   "missing redirect uri",
+			form:   url.Values{"client_id": {"spa"}, "code_verifier": {"verifier"}},
+			status: http.StatusBadRequest, code: "invalid_grant",
+		},
+		{
+			name: "another client",
+			form: url.Values{"redirect_uri": {spaRedirectURI}, "code_verifier": {"verifier"}},
+			user: "app", password: "app-secret",
+			status: http.StatusBadRequest, code: "invalid_grant",
+		},
+		{
+			name: "wrong secret",
+			form: url.Values{"redirect_uri": {spaRedirectURI}, "code_verifier": {"verifier"}},
+			user: "app", password: "other",
+			status: http.StatusUnauthorized, code: "invalid_client",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAuthorizeServer(t)
+			code := s.approve(t, spaQuery("verifier"))
+
+			tt.form.Set("grant_type", "authorization_code")
+			tt.form.Set("code", code)
+			w := s.token(tt.form, tt.user, tt.password)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if got := oauthErrCode(t, w); got != tt.code {
+				t.Fatalf("expected %q, got %q", tt.code, got)
+			}
+		})
+	}
+}
+
+func TestAuthorize_ConfidentialClient(t *testing.T) {
+	s := newAuthorizeServer(t)
+	code := s.approve(t, url.Values{"response_type": {"code"}, "client_id": {"app"}, "state": {"abc"}})
+
+	w := s.token(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {appRedirectURI},
+	}, "app", "app-secret")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuthorize_DefaultRedirectURI(t *testing.T) {
+	// Without redirect_uri in the authorization request, the token request needs none.
+	s := newAuthorizeServer(t)
+	code := s.approve(t, url.Values{"response_type": {"code"}, "client_id": {"app"}, "state": {"abc"}})
+
+	w := s.token(url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {code},
+	}, "app", "app-secret")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuthorize_ClientTokenOnOwnRoutes(t *testing.T) {
+	s := newAuthorizeServer(t)
+	err := s.storage.SaveUser(legitima.Identity{Provider: "google", Subject: "1", Email: "jj@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	code := s.approve(t, url.Values{"response_type": {"code"}, "client_id": {"app"}, "state": {"abc"}})
+	w := s.token(url.Values{"grant_type": {"authorization_code"}, "code": {code}}, "app", "app-secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.TokenResponse
+	err = json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	w = httptest.NewRecorder()
+	api.RequireAuth(s.key, api.DefaultTokenConfig(), s.storage)(ok).ServeHTTP(w, requestWithToken(res.AccessToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the client token to be accepted by other routes, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	api.RequireAuth(s.key, api.DefaultTokenConfig(), s.storage, api.RequireOwnLogin())(ok).ServeHTTP(w, requestWithToken(res.AccessToken))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected the client token to be rejected by the own routes, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	api.RequireAuth(s.key, api.DefaultTokenConfig(), s.storage, api.RequireOwnLogin())(ok).ServeHTTP(w, requestWithToken(s.accessToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the own login token to be accepted, got %d", w.Code)
+	}
+
+	// The refreshed tokens are still issued to the client.
+	w = s.token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {res.RefreshToken}}, "app", "app-secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	err = json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), s.key, api.DefaultTokenConfig())
+	if err != nil || token.ClientID != "app" || token.IsOwnLogin() {
+		t.Fatalf("expected a refreshed token of the app client, got %+v, %v", token, err)
+	}
+}
+
+func TestAuthorize_Consent(t *testing.T) {
+	s := newAuthorizeServer(t)
+	query := spaQuery("verifier")
+	w := s.authorize(query)
+	if !strings.Contains(w.Body.String(), "Authorize spa") {
+		t.Fatalf("expected consent page, got %s", w.Body.String())
+	}
+
+	form := url.Values{}
+	for k := range query {
+		form.Set(k, query.Get(k))
+	}
+	form.Set("approve", "true")
+	form.Set("csrf", "forged")
+	if w := s.consent(form, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected consent without csrf cookie to fail with 403, got %d", w.Code)
+	}
+
+	form.Set("approve", "false")
+	w = s.consent(form, "forged")
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse location: %v", err)
+	}
+	if location.Query().Get("error") != "access_denied" || location.Query().Get("code") != "" {
+		t.Fatalf("expected access_denied, got %s", location)
+	}
+}
+
+// fakeAuthorizationCodes is an in memory api.AuthorizationCodeStorage.
+type fakeAuthorizationCodes struct {
+	mu    sync.Mutex
+	codes map[string]legitima.AuthorizationCode
+}
+
+func newFakeAuthorizationCodes() *fakeAuthorizationCodes {
+	return &fakeAuthorizationCodes{
+		codes: map[string]legitima.AuthorizationCode{},
+	}
+}
+
+func (s *fakeAuthorizationCodes) SaveAuthorizationCode(code legitima.AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[code.Hash] = code
+	return nil
+}
+
+func (s *fakeAuthorizationCodes) ConsumeAuthorizationCode(hash string) (*legitima.AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[hash]
+	if !ok {
+		return nil, fmt.Errorf("consume authorization code: %w", legitima.ErrNotFound)
+	}
+	delete(s.codes, hash)
+	return &code, nil
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+)
+
+// grantClientCredentials is the grant type of service-to-service tokens.
+const grantClientCredentials = "client_credentials"
+
+// clientCredentialsGrant issues a service token to the client itself, as defined in RFC 6749 4.4.
+// No refresh token is issued, the client can just request a new token.
+func clientCredentialsGrant(w http.ResponseWriter, r *http.Request, client *legitima.Client, signer Signer, tokenCfg TokenConfig) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	if client.Public() {
+		sendOAuthErr(ctx, w, "unauthorized_client", errors.New("public clients can't use the client credentials grant"), http.StatusBadRequest)
+		return
+	}
+	scope := strings.Join(strings.Fields(r.PostFormValue("scope")), " ")
+	if !client.AllowsScope(scope) {
+		sendOAuthErr(ctx, w, "invalid_scope", fmt.Errorf("scope %q not allowed", scope), http.StatusBadRequest)
+		return
+	}
+
+	accessToken, err := generateServiceToken(signer, tokenCfg, client.ID, scope)
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", fmt.Errorf("generating access token: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("service token issued", "client_id", client.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(tokenCfg.TTL.Seconds()),
+		Scope:       scope,
+	})
+}
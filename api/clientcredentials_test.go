@@ -0,0 +1,152 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/birdie-ai/legitima/api"
+)
+
+func TestClientCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		form     url.Values
+		user     string
+		password string
+	}{
+		{
+			name: "client secret basic",
+			form: url.Values{"grant_type": {"client_credentials"}, "scope": {"jobs:read"}},
+			user: "worker", password: "worker-secret",
+		},
+		{
+			name: "client secret post",
+			form: url.Values{"grant_type": {"client_credentials"}, "scope": {"jobs:read"}, "client_id": {"worker"}, "client_secret": {"worker-secret"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAuthorizeServer(t)
+			w := s.token(tt.form, tt.user, tt.password)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+			var res api.TokenResponse
+			err := json.NewDecoder(w.Body).Decode(&res)
+			if err != nil {
+				t.Fatalf("failed to decode token response: %v", err)
+			}
+			if res.RefreshToken != "" || res.Scope != "jobs:read" || res.ExpiresIn != 300 {
+				t.Fatalf("unexpected token response: %+v", res)
+			}
+
+			token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), s.key, api.DefaultTokenConfig())
+			if err != nil {
+				t.Fatalf("failed to verify service token: %v", err)
+			}
+			if !token.IsService() || token.ClientID != "worker" || token.Claims.Subject != "worker" || token.Email != "" {
+				t.Fatalf("expected a service token of the worker, got %+v", token)
+			}
+			if !token.HasScope("jobs:read") || token.HasScope("jobs:write") {
+				t.Fatalf("expected the token to be granted jobs:read only, got %q", token.Claims.Scope)
+			}
+		})
+	}
+}
+
+func TestClientCredentials_TokenTTLCapped(t *testing.T) {
+	s := newAuthorizeServer(t)
+	worker, err := s.storage.ClientByID("worker")
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+	batch := *worker
+	batch.ID = "batch"
+	batch.TokenTTL = 2 * api.DefaultTokenConfig().TTL
+	err = s.storage.SaveClient(batch)
+	if err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	w := s.token(url.Values{"grant_type": {"client_credentials"}}, "batch", "worker-secret")
+	var res api.TokenResponse
+	err = json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	if res.ExpiresIn != int64(api.DefaultTokenConfig().TTL.Seconds()) {
+		t.Fatalf("expected the token ttl of the service, got %d", res.ExpiresIn)
+	}
+}
+
+func TestClientCredentials_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		form     url.Values
+		user     string
+		password string
+		status   int
+		code     string
+	}{
+		{
+			name: "wrong secret",
+			user: "worker", password: "other",
+			status: http.StatusUnauthorized, code: "invalid_client",
+		},
+		{
+			name:   "public client",
+			form:   url.Values{"client_id": {"spa"}},
+			status: http.StatusBadRequest, code: "unauthorized_client",
+		},
+		{
+			name: "grant not allowed",
+			user: "app", password: "app-secret",
+			status: http.StatusBadRequest, code: "unauthorized_client",
+		},
+		{
+			name: "scope not allowed",
+			form: url.Values{"scope": {"jobs:read admin"}},
+			user: "worker", password: "worker-secret",
+			status: http.StatusBadRequest, code: "invalid_scope",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAuthorizeServer(t)
+			if tt.form == nil {
+				tt.form = url.Values{}
+			}
+			tt.form.Set("grant_type", "client_credentials")
+			w := s.token(tt.form, tt.user, tt.password)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if got := oauthErrCode(t, w); got != tt.code {
+				t.Fatalf("expected %q, got %q", tt.code, got)
+			}
+		})
+	}
+}
+
+func TestClientCredentials_NoUser(t *testing.T) {
+	s := newAuthorizeServer(t)
+	w := s.token(url.Values{"grant_type": {"client_credentials"}}, "worker", "worker-secret")
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+
+	h := api.RequireAuth(s.key, api.DefaultTokenConfig(), s.storage)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("expected service tokens to be rejected by user endpoints")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, requestWithToken(res.AccessToken))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/google/uuid"
+)
+
+// grantAuthorizationCode is the grant type of the authorization code flow.
+const grantAuthorizationCode = "authorization_code"
+
+// ErrInvalidClientConfig is returned when registering a client with an invalid configuration.
+var ErrInvalidClientConfig = errors.New("invalid client config")
+
+// supportedGrantTypes are the grant types clients can be registered with.
+var supportedGrantTypes = []string{grantAuthorizationCode, grantClientCredentials, grantDeviceCode, grantTokenExchange, grantRefreshToken}
+
+// defaultClientScopes are the scopes of clients registered without any.
+var defaultClientScopes = []string{"openid", "email", "profile"}
+
+// ClientStorage returns the clients registered with the authorization server.
+// Unknown clients are reported with legitima.ErrNotFound.
+type ClientStorage interface {
+	ClientByID(id string) (*legitima.Client, error)
+}
+
+// ClientRegistry persists the clients registered with the authorization server.
+type ClientRegistry interface {
+	ClientStorage
+	SaveClient(client legitima.Client) error
+	Clients() ([]legitima.Client, error)
+	UpdateClientSecret(id, secretHash string) error
+	DeleteClient(id string) error
+}
+
+// ClientConfig configures a new client of the authorization server.
+// It has no secret on purpose: secrets are always generated, see authenticateOAuthClient.
+type ClientConfig struct {
+	// ID is generated when empty.
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Public clients, like browser and mobile apps, have no secret and must use PKCE.
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	// GrantTypes default to authorization_code.
+	GrantTypes []string `json:"grant_types"`
+	// Scopes default to openid, email and profile.
+	Scopes []string `json:"scopes"`
+	// TokenTTL shortens how long the access tokens issued to the client are valid, in seconds.
+	// It can't go beyond the TTL of the service.
+	TokenTTL int64 `json:"token_ttl"`
+}
+
+// client validates the config and returns the client it describes, without secret.
+func (cfg ClientConfig) client() (legitima.Client, error) {
+	client := legitima.Client{
+		ID:           cfg.ID,
+		Name:         cfg.Name,
+		RedirectURIs: cfg.RedirectURIs,
+		GrantTypes:   cfg.GrantTypes,
+		Scopes:       cfg.Scopes,
+		TokenTTL:     time.Duration(cfg.TokenTTL) * time.Second,
+		CreatedAt:    time.Now(),
+	}
+	if client.ID == "" {
+		client.ID = uuid.New().String()
+	}
+	if client.Name == "" {
+		client.Name = client.ID
+	}
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{grantAuthorizationCode}
+	}
+	if len(client.Scopes) == 0 {
+		client.Scopes = defaultClientScopes
+	}
+
+	if strings.ContainsAny(client.ID, " /") {
+		return legitima.Client{}, fmt.Errorf("%w: id can't have spaces or slashes", ErrInvalidClientConfig)
+	}
+	if cfg.TokenTTL < 0 {
+		return legitima.Client{}, fmt.Errorf("%w: negative token ttl", ErrInvalidClientConfig)
+	}
+	for _, grantType := range client.GrantTypes {
+		if !contains(supportedGrantTypes, grantType) {
+			return legitima.Client{}, fmt.Errorf("%w: unsupported grant type %q", ErrInvalidClientConfig, grantType)
+		}
+	}
+	for _, scope := range client.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \"\\") {
+			return legitima.Client{}, fmt.Errorf("%w: invalid scope %q", ErrInvalidClientConfig, scope)
+		}
+	}
+	if cfg.Public && (client.AllowsGrantType(grantClientCredentials) || client.AllowsGrantType(grantTokenExchange)) {
+		return legitima.Client{}, fmt.Errorf("%w: public clients can't use client_credentials nor token exchange", ErrInvalidClientConfig)
+	}
+	if client.AllowsGrantType(grantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		return legitima.Client{}, fmt.Errorf("%w: authorization_code requires redirect uris", ErrInvalidClientConfig)
+	}
+	for _, uri := range client.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return legitima.Client{}, fmt.Errorf("%w: invalid redirect uri %q", ErrInvalidClientConfig, uri)
+		}
+	}
+	return client, nil
+}
+
+// validRedirectURI tells if the URI can be registered as a redirect URI.
+// Only https, plain http on the loopback interface of native apps and the reverse domain
+// name schemes of app links, like com.example.app, are allowed: never javascript nor data.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(uri, " #") {
+		return false
+	}
+	switch {
+	case u.Scheme == "https":
+		return u.Host != ""
+	case u.Scheme == "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+// CreateClient registers a new client, returning it along with its secret.
+// Public clients have no secret.
+func CreateClient(registry ClientRegistry, cfg ClientConfig) (*legitima.Client, string, error) {
+	client, err := cfg.client()
+	if err != nil {
+		return nil, "", err
+	}
+	var secret string
+	if !cfg.Public {
+		secret, err = randomString(32)
+		if err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = hashToken(secret)
+	}
+	err = registry.SaveClient(client)
+	if err != nil {
+		return nil, "", err
+	}
+	return &client, secret, nil
+}
+
+// RotateClientSecret replaces the secret of a confidential client, returning the new secret.
+// The previous secret stops working right away.
+func RotateClientSecret(registry ClientRegistry, id string) (string, error) {
+	client, err := registry.ClientByID(id)
+	if err != nil {
+		return "", err
+	}
+	if client.Public() {
+		return "", fmt.Errorf("%w: public clients have no secret", ErrInvalidClientConfig)
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	err = registry.UpdateClientSecret(id, hashToken(secret))
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// authenticateOAuthClient authenticates the client of a token request.
+// Confidential clients authenticate with their secret, public ones just name themselves with the client_id form value.
+func authenticateOAuthClient(r *http.Request, clients ClientStorage) (*legitima.Client, error) {
+	clientID, clientSecret, ok := clientCredentials(r)
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+	}
+	if clientID == "" {
+		return nil, fmt.Errorf("%w: missing client credentials", ErrInvalidClient)
+	}
+
+	client, err := clients.ClientByID(clientID)
+	if errors.Is(err, legitima.ErrNotFound) {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public() {
+		if clientSecret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+	// An unsalted SHA-256 is enough only because secrets are random 32 byte values generated
+	// by CreateClient and RotateClientSecret. Chosen secrets would need a password hash.
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashToken(clientSecret))) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
@@ -0,0 +1,414 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+)
+
+const (
+	deviceAuthorizationURL = "/oauth/device_authorization"
+	deviceURL              = "/device"
+	// grantDeviceCode is the grant type of the device authorization flow.
+	grantDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+	// deviceCookie binds the device form to the browser it was shown to.
+	deviceCookie = "device_consent"
+	// deviceCodeTTL is how long the user has to enter the user code.
+	deviceCodeTTL = 10 * time.Minute
+	// devicePollInterval is the minimum time between two polls of a device.
+	devicePollInterval = 5 * time.Second
+	// userCodeAlphabet has no vowels, so no words are spelled, and no easily confused characters.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// ErrInvalidUserCode is returned for unknown, expired or already used user codes.
+var ErrInvalidUserCode = errors.New("invalid or expired code")
+
+// DeviceCodeStorage persists the device authorizations until the device gets its tokens.
+type DeviceCodeStorage interface {
+	SaveDeviceCode(code legitima.DeviceCode) error
+	DeviceCodeByUserCode(userCode string) (*legitima.DeviceCode, error)
+	DecideDeviceCode(userCode string, status legitima.DeviceCodeStatus, email string) error
+	PollDeviceCode(hash, clientID string, now time.Time) (*legitima.DeviceCode, error)
+	DeleteExpiredDeviceCodes(now time.Time) (int64, error)
+}
+
+// DeviceAuthorizationResponse is the response of the device authorization endpoint, as defined in RFC 8628 3.2.
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// SetupDevice sets up the device authorization endpoint and the page where users enter the user codes.
+// The baseURL is the public URL of legitima, where the users are sent to.
+func SetupDevice(mux *http.ServeMux, baseURL string, key Key, tokenCfg TokenConfig, storage Storage) {
+	mux.Handle(deviceAuthorizationURL, DeviceAuthorizationHandler(baseURL, storage))
+	mux.Handle(deviceURL, DeviceHandler(key, tokenCfg, storage))
+}
+
+// DeviceAuthorizationHandler handles the endpoint where devices start the authorization.
+func DeviceAuthorizationHandler(baseURL string, storage Storage) http.Handler {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			deviceAuthorization(w, r, baseURL, storage)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func deviceAuthorization(w http.ResponseWriter, r *http.Request, baseURL string, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	client, err := authenticateOAuthClient(r, storage)
+	if errors.Is(err, ErrInvalidClient) {
+		sendOAuthErr(ctx, w, "invalid_client", err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+	if !client.AllowsGrantType(grantDeviceCode) {
+		sendOAuthErr(ctx, w, "unauthorized_client", errors.New("device code grant not allowed"), http.StatusBadRequest)
+		return
+	}
+	scope := strings.Join(strings.Fields(r.PostFormValue("scope")), " ")
+	if !client.AllowsScope(scope) {
+		sendOAuthErr(ctx, w, "invalid_scope", fmt.Errorf("scope %q not allowed", scope), http.StatusBadRequest)
+		return
+	}
+
+	deviceCode, err := randomString(32)
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+	code := legitima.DeviceCode{
+		Hash:      hashToken(deviceCode),
+		ClientID:  client.ID,
+		Scope:     scope,
+		Status:    legitima.DeviceCodePending,
+		ExpiresAt: time.Now().Add(deviceCodeTTL),
+	}
+	// User codes are short, so a taken one is just generated again.
+	for attempt := 0; ; attempt++ {
+		code.UserCode, err = newUserCode()
+		if err == nil {
+			err = storage.SaveDeviceCode(code)
+		}
+		if !errors.Is(err, legitima.ErrAlreadyExists) || attempt == 2 {
+			break
+		}
+	}
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("device authorization started", "client_id", client.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                code.UserCode,
+		VerificationURI:         baseURL + deviceURL,
+		VerificationURIComplete: baseURL + deviceURL + "?user_code=" + url.QueryEscape(code.UserCode),
+		ExpiresIn:               int64(deviceCodeTTL.Seconds()),
+		Interval:                int64(devicePollInterval.Seconds()),
+	})
+}
+
+// newUserCode generates a user code like BCDF-GHJK.
+func newUserCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("generating user code: %w", err)
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// normalizeUserCode formats the code typed by the user like the generated ones,
+// ignoring case, spaces and dashes.
+func normalizeUserCode(code string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		if c == '-' || c == ' ' {
+			continue
+		}
+		if b.Len() == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// pendingDeviceCode returns the device authorization waiting for the given user code.
+func pendingDeviceCode(storage Storage, userCode string) (*legitima.DeviceCode, error) {
+	code, err := storage.DeviceCodeByUserCode(normalizeUserCode(userCode))
+	if errors.Is(err, legitima.ErrNotFound) {
+		return nil, ErrInvalidUserCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code.Status != legitima.DeviceCodePending || time.Now().After(code.ExpiresAt) {
+		return nil, ErrInvalidUserCode
+	}
+	return code, nil
+}
+
+// DeviceHandler handles the page where logged in users enter the user code shown by the device,
+// then approve or deny its authorization.
+func DeviceHandler(verifier Verifier, tokenCfg TokenConfig, storage Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			device(w, r, verifier, tokenCfg, storage)
+		case http.MethodPost:
+			deviceDecision(w, r, verifier, tokenCfg, storage)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+//go:embed templates/device.html
+var deviceTemplateFS embed.FS
+
+// devicePage is the data of the device page.
+type devicePage struct {
+	Email    string
+	UserCode string
+	// Client is set when the user code was found, asking the user to decide.
+	Client  *legitima.Client
+	Scopes  []string
+	CSRF    string
+	Error   string
+	Message string
+}
+
+func renderDevice(w http.ResponseWriter, status int, page devicePage) {
+	tmpl, err := template.ParseFS(deviceTemplateFS, "templates/device.html")
+	if err != nil {
+		slog.Error("failed to parse template", "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err = tmpl.Execute(w, page)
+	if err != nil {
+		slog.Error("failed to execute template", "error", err.Error())
+	}
+}
+
+func device(w http.ResponseWriter, r *http.Request, verifier Verifier, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+
+	token, err := tokenFromRequest(r, verifier, tokenCfg)
+	if err == nil && !token.IsOwnLogin() {
+		err = ErrClientToken
+	}
+	if err != nil {
+		http.Redirect(w, r, loginURL+"?return_to="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+
+	page := devicePage{Email: token.Email, UserCode: r.URL.Query().Get("user_code")}
+	if page.UserCode == "" {
+		renderDevice(w, http.StatusOK, page)
+		return
+	}
+	code, err := pendingDeviceCode(storage, page.UserCode)
+	if errors.Is(err, ErrInvalidUserCode) {
+		page.Error = err.Error()
+		renderDevice(w, http.StatusBadRequest, page)
+		return
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	page.Client, err = storage.ClientByID(code.ClientID)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	page.UserCode = code.UserCode
+	page.Scopes = strings.Fields(code.Scope)
+
+	page.CSRF, err = randomString(32)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookie,
+		Value:    page.CSRF,
+		Path:     deviceURL,
+		MaxAge:   int(stateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	renderDevice(w, http.StatusOK, page)
+}
+
+func deviceDecision(w http.ResponseWriter, r *http.Request, verifier Verifier, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	token, err := tokenFromRequest(r, verifier, tokenCfg)
+	if err == nil && !token.IsOwnLogin() {
+		err = ErrClientToken
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusUnauthorized)
+		return
+	}
+	cookie, err := r.Cookie(deviceCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue("csrf"))) != 1 {
+		sendErr(ctx, w, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: deviceCookie, Path: deviceURL, MaxAge: -1, HttpOnly: true, Secure: true})
+
+	page := devicePage{Email: token.Email}
+	code, err := pendingDeviceCode(storage, r.PostFormValue("user_code"))
+	if errors.Is(err, ErrInvalidUserCode) {
+		page.Error = err.Error()
+		renderDevice(w, http.StatusBadRequest, page)
+		return
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	status := legitima.DeviceCodeDenied
+	page.Message = "The device was denied access. You can close this window."
+	if r.PostFormValue("approve") == "true" {
+		status, page.Message = legitima.DeviceCodeApproved, "The device is connected. You can close this window and go back to it."
+	}
+	err = storage.DecideDeviceCode(code.UserCode, status, token.Email)
+	if errors.Is(err, legitima.ErrNotFound) {
+		page.Error, page.Message = ErrInvalidUserCode.Error(), ""
+		renderDevice(w, http.StatusBadRequest, page)
+		return
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("device authorization decided", "client_id", code.ClientID, "email", token.Email, "status", status)
+	renderDevice(w, http.StatusOK, page)
+}
+
+// deviceCodeGrant exchanges a device code for tokens once the user approved it, as defined in RFC 8628 3.4.
+func deviceCodeGrant(w http.ResponseWriter, r *http.Request, client *legitima.Client, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	deviceCode := r.PostFormValue("device_code")
+	if deviceCode == "" {
+		sendOAuthErr(ctx, w, "invalid_request", errors.New("missing device code"), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	code, err := storage.PollDeviceCode(hashToken(deviceCode), client.ID, now)
+	if errors.Is(err, legitima.ErrNotFound) {
+		sendOAuthErr(ctx, w, "invalid_grant", errors.New("invalid device code"), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case code.ClientID != client.ID:
+		sendOAuthErr(ctx, w, "invalid_grant", errors.New("device code issued to another client"), http.StatusBadRequest)
+		return
+	case now.After(code.ExpiresAt):
+		sendOAuthErr(ctx, w, "expired_token", errors.New("device code expired"), http.StatusBadRequest)
+		return
+	case code.Status == legitima.DeviceCodeDenied:
+		sendOAuthErr(ctx, w, "access_denied", errors.New("device authorization denied"), http.StatusBadRequest)
+		return
+	case code.Status == legitima.DeviceCodePending && now.Sub(code.LastPolledAt) < devicePollInterval:
+		sendOAuthErr(ctx, w, "slow_down", errors.New("polling too fast"), http.StatusBadRequest)
+		return
+	case code.Status == legitima.DeviceCodePending:
+		sendOAuthErr(ctx, w, "authorization_pending", errors.New("waiting for the user"), http.StatusBadRequest)
+		return
+	}
+
+	res, refreshToken, err := issueTokens(signer, tokenCfg, storage, legitima.RefreshToken{Email: code.Email, ClientID: client.ID, Scope: code.Scope})
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+	err = storage.SaveRefreshToken(refreshToken)
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("device code exchanged", "client_id", client.ID, "email", code.Email)
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, res)
+}
+
+// RunDeviceCodeCleanup deletes the expired device authorizations periodically,
+// the ones the device stopped polling for are never consumed otherwise.
+// It blocks until the context is cancelled.
+func RunDeviceCodeCleanup(ctx context.Context, storage DeviceCodeStorage, interval time.Duration) {
+	log := slog.FromCtx(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := storage.DeleteExpiredDeviceCodes(time.Now())
+		if err != nil {
+			log.Error("failed to delete expired device codes", "error", err.Error())
+			continue
+		}
+		log.Debug("deleted expired device codes", "count", n)
+	}
+}
@@ -0,0 +1,310 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+const deviceGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+func (s *authorizeServer) deviceAuthorization(t *testing.T, form url.Values) api.DeviceAuthorizationResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/oauth/device_authorization", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	api.DeviceAuthorizationHandler("https://legitima.example.com", s.storage).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.DeviceAuthorizationResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode device authorization: %v", err)
+	}
+	return res
+}
+
+func (s *authorizeServer) device(req *http.Request) *httptest.ResponseRecorder {
+	if s.accessToken != "" {
+		req.AddCookie(&http.Cookie{Name: "Authorization", Value: "Bearer " + s.accessToken})
+	}
+	w := httptest.NewRecorder()
+	api.DeviceHandler(s.key, api.DefaultTokenConfig(), s.storage).ServeHTTP(w, req)
+	return w
+}
+
+// decide enters the user code on the device page and approves or denies the device.
+func (s *authorizeServer) decide(t *testing.T, userCode string, approve bool) *httptest.ResponseRecorder {
+	t.Helper()
+	w := s.device(httptest.NewRequest(http.MethodGet, "/device?user_code="+url.QueryEscape(userCode), nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "The CLI") {
+		t.Fatalf("expected the device confirmation, got %d: %s", w.Code, w.Body.String())
+	}
+	var csrf string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "device_consent" {
+			csrf = c.Value
+		}
+	}
+
+	form := url.Values{"user_code": {userCode}, "csrf": {csrf}, "approve": {"false"}}
+	if approve {
+		form.Set("approve", "true")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "device_consent", Value: csrf})
+	return s.device(req)
+}
+
+func (s *authorizeServer) pollDevice(deviceCode string) *httptest.ResponseRecorder {
+	return s.token(url.Values{"grant_type": {deviceGrant}, "device_code": {deviceCode}, "client_id": {"cli"}}, "", "")
+}
+
+func TestDevice(t *testing.T) {
+	s := newAuthorizeServer(t)
+	auth := s.deviceAuthorization(t, url.Values{"client_id": {"cli"}, "scope": {"openid"}})
+	if auth.VerificationURI != "https://legitima.example.com/device" || len(auth.UserCode) != 9 || auth.Interval != 5 {
+		t.Fatalf("unexpected device authorization: %+v", auth)
+	}
+	if auth.VerificationURIComplete != auth.VerificationURI+"?user_code="+auth.UserCode {
+		t.Fatalf("unexpected complete verification uri %s", auth.VerificationURIComplete)
+	}
+
+	if w := s.pollDevice(auth.DeviceCode); oauthErrCode(t, w) != "authorization_pending" {
+		t.Fatalf("expected authorization_pending, got %d", w.Code)
+	}
+	if w := s.pollDevice(auth.DeviceCode); oauthErrCode(t, w) != "slow_down" {
+		t.Fatalf("expected slow_down, got %d", w.Code)
+	}
+
+	// Users can type the code without the dash and in lower case.
+	w := s.decide(t, strings.ToLower(strings.ReplaceAll(auth.UserCode, "-", "")), true)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "connected") {
+		t.Fatalf("expected the device to be approved, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = s.pollDevice(auth.DeviceCode)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), s.key, api.DefaultTokenConfig())
+	if err != nil {
+		t.Fatalf("failed to verify access token: %v", err)
+	}
+	if token.Email != "jj@example.com" || res.RefreshToken == "" || res.Scope != "openid" {
+		t.Fatalf("unexpected tokens: %+v", res)
+	}
+
+	if w := s.pollDevice(auth.DeviceCode); oauthErrCode(t, w) != "invalid_grant" {
+		t.Fatalf("expected the device code to be used once, got %d", w.Code)
+	}
+}
+
+func TestDevice_Denied(t *testing.T) {
+	s := newAuthorizeServer(t)
+	auth := s.deviceAuthorization(t, url.Values{"client_id": {"cli"}})
+
+	w := s.decide(t, auth.UserCode, false)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "denied") {
+		t.Fatalf("expected the device to be denied, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := s.pollDevice(auth.DeviceCode); oauthErrCode(t, w) != "access_denied" {
+		t.Fatalf("expected access_denied, got %d", w.Code)
+	}
+}
+
+func TestDevice_Expired(t *testing.T) {
+	s := newAuthorizeServer(t)
+	auth := s.deviceAuthorization(t, url.Values{"client_id": {"cli"}})
+	for hash, code := range s.storage.deviceCodes {
+		code.ExpiresAt = time.Now().Add(-time.Second)
+		s.storage.deviceCodes[hash] = code
+	}
+
+	w := s.device(httptest.NewRequest(http.MethodGet, "/device?user_code="+auth.UserCode, nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid or expired code") {
+		t.Fatalf("expected the code to be rejected, got %d", w.Code)
+	}
+	if w := s.pollDevice(auth.DeviceCode); oauthErrCode(t, w) != "expired_token" {
+		t.Fatalf("expected expired_token, got %d", w.Code)
+	}
+}
+
+func TestDevice_AnotherClient(t *testing.T) {
+	s := newAuthorizeServer(t)
+	err := s.storage.SaveClient(legitima.Client{ID: "tv", GrantTypes: []string{deviceGrant}, Scopes: []string{"openid"}})
+	if err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+	auth := s.deviceAuthorization(t, url.Values{"client_id": {"cli"}})
+	if w := s.decide(t, auth.UserCode, true); w.Code != http.StatusOK {
+		t.Fatalf("expected the device to be approved, got %d", w.Code)
+	}
+
+	w := s.token(url.Values{"grant_type": {deviceGrant}, "device_code": {auth.DeviceCode}, "client_id": {"tv"}}, "", "")
+	if oauthErrCode(t, w) != "invalid_grant" {
+		t.Fatalf("expected invalid_grant for another client, got %d", w.Code)
+	}
+	if w := s.pollDevice(auth.DeviceCode); w.Code != http.StatusOK {
+		t.Fatalf("expected the device code to be left for its client, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDevice_Cleanup(t *testing.T) {
+	s := newAuthorizeServer(t)
+	expired := s.deviceAuthorization(t, url.Values{"client_id": {"cli"}})
+	for hash, code := range s.storage.deviceCodes {
+		code.ExpiresAt = time.Now().Add(-time.Second)
+		s.storage.deviceCodes[hash] = code
+	}
+	pending := s.deviceAuthorization(t, url.Values{"client_id": {"cli"}})
+
+	n, err := s.storage.DeleteExpiredDeviceCodes(time.Now())
+	if err != nil || n != 1 {
+		t.Fatalf("expected one code deleted, got %d, %v", n, err)
+	}
+	if w := s.pollDevice(expired.DeviceCode); oauthErrCode(t, w) != "invalid_grant" {
+		t.Fatalf("expected the expired code to be deleted, got %d", w.Code)
+	}
+	if w := s.pollDevice(pending.DeviceCode); oauthErrCode(t, w) != "authorization_pending" {
+		t.Fatalf("expected the pending code to be kept, got %d", w.Code)
+	}
+}
+
+func TestDevice_Login(t *testing.T) {
+	s := newAuthorizeServer(t)
+	s.accessToken = ""
+
+	w := s.device(httptest.NewRequest(http.MethodGet, "/device?user_code=BCDF-GHJK", nil))
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse location: %v", err)
+	}
+	if location.Path != "/login" || location.Query().Get("return_to") != "/device?user_code=BCDF-GHJK" {
+		t.Fatalf("expected login returning to the device page, got %s", location)
+	}
+}
+
+func TestDevice_Invalid(t *testing.T) {
+	s := newAuthorizeServer(t)
+
+	w := s.device(httptest.NewRequest(http.MethodGet, "/device?user_code=BCDF-GHJK", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown user code to fail with 400, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(url.Values{"user_code": {"BCDF-GHJK"}, "approve": {"true"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := s.device(req); w.Code != http.StatusForbidden {
+		t.Fatalf("expected decision without csrf to fail with 403, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/oauth/device_authorization", strings.NewReader(url.Values{"client_id": {"spa"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	api.DeviceAuthorizationHandler("https://legitima.example.com", s.storage).ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || oauthErrCode(t, w) != "unauthorized_client" {
+		t.Fatalf("expected unauthorized_client for a client without the grant, got %d", w.Code)
+	}
+}
+
+// fakeDeviceCodes is an in memory api.DeviceCodeStorage.
+type fakeDeviceCodes struct {
+	mu          sync.Mutex
+	deviceCodes map[string]legitima.DeviceCode
+}
+
+func newFakeDeviceCodes() *fakeDeviceCodes {
+	return &fakeDeviceCodes{
+		deviceCodes: map[string]legitima.DeviceCode{},
+	}
+}
+
+func (s *fakeDeviceCodes) SaveDeviceCode(code legitima.DeviceCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.deviceCodes {
+		if c.UserCode == code.UserCode {
+			return fmt.Errorf("save device code: %w", legitima.ErrAlreadyExists)
+		}
+	}
+	s.deviceCodes[code.Hash] = code
+	return nil
+}
+
+func (s *fakeDeviceCodes) DeviceCodeByUserCode(userCode string) (*legitima.DeviceCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.deviceCodes {
+		if c.UserCode == userCode {
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("device code by user code: %w", legitima.ErrNotFound)
+}
+
+func (s *fakeDeviceCodes) DecideDeviceCode(userCode string, status legitima.DeviceCodeStatus, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, c := range s.deviceCodes {
+		if c.UserCode == userCode && c.Status == legitima.DeviceCodePending {
+			c.Status, c.Email = status, email
+			s.deviceCodes[hash] = c
+			return nil
+		}
+	}
+	return fmt.Errorf("decide device code: %w", legitima.ErrNotFound)
+}
+
+func (s *fakeDeviceCodes) PollDeviceCode(hash, clientID string, now time.Time) (*legitima.DeviceCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.deviceCodes[hash]
+	if !ok {
+		return nil, fmt.Errorf("poll device code: %w", legitima.ErrNotFound)
+	}
+	if code.ClientID != clientID {
+		return &code, nil
+	}
+	if code.Status == legitima.DeviceCodePending && now.Before(code.ExpiresAt) {
+		polled := code
+		polled.LastPolledAt = now
+		s.deviceCodes[hash] = polled
+	} else {
+		delete(s.deviceCodes, hash)
+	}
+	return &code, nil
+}
+
+func (s *fakeDeviceCodes) DeleteExpiredDeviceCodes(now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for hash, code := range s.deviceCodes {
+		if code.ExpiresAt.Before(now) {
+			delete(s.deviceCodes, hash)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/birdie-ai/legitima"
+	"golang.org/x/oauth2"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+// ErrNoVerifiedEmail is returned when the user has no verified email at the identity provider.
+var ErrNoVerifiedEmail = errors.New("no verified email")
+
+// githubUser is the profile returned by the GitHub /user endpoint.
+type githubUser struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// githubEmail is an email returned by the GitHub /user/emails endpoint.
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// GitHubProvider authenticates users with GitHub.
+// It needs the read:user and user:email scopes.
+type GitHubProvider struct {
+	oauth2Provider
+	// APIURL is the base URL of the GitHub REST API.
+	APIURL string
+}
+
+// NewGitHubProvider returns a new GitHubProvider instance.
+func NewGitHubProvider(githubOAuthConfig *oauth2.Config) *GitHubProvider {
+	return &GitHubProvider{
+		oauth2Provider: oauth2Provider{name: "github", config: githubOAuthConfig},
+		APIURL:         githubAPIURL,
+	}
+}
+
+// Identity fetches the profile of the user and its primary verified email from the GitHub API.
+func (p *GitHubProvider) Identity(ctx context.Context, token *oauth2.Token, _ LoginState) (legitima.Identity, error) {
+	client := p.config.Client(ctx, token)
+
+	var usr githubUser
+	err := getJSON(client, p.APIURL+"/user", &usr)
+	if err != nil {
+		return legitima.Identity{}, fmt.Errorf("getting github user: %w", err)
+	}
+
+	var emails []githubEmail
+	err = getJSON(client, p.APIURL+"/user/emails", &emails)
+	if err != nil {
+		return legitima.Identity{}, fmt.Errorf("getting github user emails: %w", err)
+	}
+
+	email := ""
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			email = e.Email
+			break
+		}
+	}
+	if email == "" {
+		return legitima.Identity{}, ErrNoVerifiedEmail
+	}
+
+	name := usr.Name
+	if name == "" {
+		name = usr.Login
+	}
+	return legitima.Identity{
+		Provider:      p.name,
+		Subject:       strconv.FormatInt(usr.ID, 10),
+		Email:         email,
+		EmailVerified: true,
+		Name:          name,
+		Picture:       usr.AvatarURL,
+	}, nil
+}
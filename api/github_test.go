@@ -0,0 +1,104 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima/api"
+	"golang.org/x/oauth2"
+)
+
+// newGitHubServer returns a stand-in for the GitHub token endpoint and API returning the given emails.
+func newGitHubServer(emails string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/login/oauth/access_token" {
+			_, _ = w.Write([]byte(`{"access_token":"github-token","token_type":"bearer"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer github-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/user":
+			_, _ = w.Write([]byte(`{"id":7,"login":"jj","name":"","avatar_url":"https://avatars.example.com/7"}`))
+		case "/user/emails":
+			_, _ = w.Write([]byte(emails))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func githubCallback(t *testing.T, server *httptest.Server, storage api.Storage) *httptest.ResponseRecorder {
+	t.Helper()
+	provider := api.NewGitHubProvider(&oauth2.Config{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Endpoint:     oauth2.Endpoint{TokenURL: server.URL + "/login/oauth/access_token"},
+	})
+	provider.APIURL = server.URL
+	providers, err := api.NewProviders(provider)
+	if err != nil {
+		t.Fatalf("failed to create providers: %v", err)
+	}
+
+	states := api.NewMemoryStateStore()
+	err = states.Save("state", api.LoginState{Provider: "github", CodeVerifier: "verifier", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/callback/github?state=state&code=code", nil)
+	req.AddCookie(&http.Cookie{Name: "oauth_state", Value: "state"})
+	w := httptest.NewRecorder()
+	api.CallbackHandler(providers, states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), storage).ServeHTTP(w, req)
+	return w
+}
+
+func TestGitHub_Callback(t *testing.T) {
+	server := newGitHubServer(`[
+		{"email":"old@example.com","primary":false,"verified":true},
+		{"email":"jj@example.com","primary":true,"verified":true}
+	]`)
+	defer server.Close()
+	storage := newMockStorage()
+
+	w := githubCallback(t, server, storage)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d: %s", w.Code, w.Body.String())
+	}
+	usr, err := storage.UserByEmail("jj@example.com")
+	if err != nil {
+		t.Fatalf("expected user with the primary email: %v", err)
+	}
+	if usr.ID != "7" || usr.Name != "jj" {
+		t.Fatalf("unexpected user: %+v", usr)
+	}
+}
+
+func TestGitHub_Callback_NoVerifiedEmail(t *testing.T) {
+	server := newGitHubServer(`[
+		{"email":"jj@example.com","primary":true,"verified":false},
+		{"email":"other@example.com","primary":false,"verified":true}
+	]`)
+	defer server.Close()
+	storage := newMockStorage()
+
+	w := githubCallback(t, server, storage)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "no verified email") {
+		t.Fatalf("expected login error page, got %s", body)
+	}
+	if _, err := storage.UserByEmail("jj@example.com"); err == nil {
+		t.Fatal("expected user not to be saved")
+	}
+}
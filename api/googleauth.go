@@ -2,79 +2,160 @@
 package api
 
 import (
+	"context"
 	"embed"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
+	"strings"
 
 	"github.com/birdie-ai/golibs/slog"
 	"github.com/birdie-ai/legitima"
 	"golang.org/x/oauth2"
 )
 
-// Auth endpoints
+// Auth endpoints, followed by the name of the identity provider.
+// Without a name the default provider is used.
 const (
 	loginURL    = "/login"
 	callbackURL = "/callback"
 )
 
+// GoogleIssuer is the OpenID Connect issuer of Google accounts.
+const GoogleIssuer = "https://accounts.google.com"
+
+// ErrDomainNotAllowed is returned when the user account does not belong to an allowed domain.
+var ErrDomainNotAllowed = errors.New("domain not allowed")
+
 // Storage interface take care of functionalities needed by the auth endpoints.
 type Storage interface {
-	SaveUser(gUsr legitima.GoogleUser) error
-	UserByEmail(email string) (*legitima.User, error)
+	// SaveUser returns legitima.ErrIdentityConflict when the email is linked to another identity.
+	SaveUser(identity legitima.Identity) error
+	UserStorage
+	RefreshTokenStorage
+	RevocationStorage
+	AuthorizationCodeStorage
+	ClientRegistry
+	DeviceCodeStorage
+	RoleStorage
+	OrganizationStorage
 }
 
 // SetupAuth sets up the authentication endpoints.
-func SetupAuth(mux *http.ServeMux, googleOAuthConfig *oauth2.Config, storage Storage) {
-	mux.Handle(loginURL, LoginHandler(googleOAuthConfig))
-	mux.Handle(callbackURL, CallbackHandler(googleOAuthConfig, storage))
+func SetupAuth(mux *http.ServeMux, providers *Providers, states StateStore, returnURLs *ReturnURLs, codes LoginCodeStore, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	login := LoginHandler(providers, states, returnURLs)
+	callback := CallbackHandler(providers, states, codes, signer, tokenCfg, storage)
+	mux.Handle(loginURL, login)
+	mux.Handle(loginURL+"/", login)
+	mux.Handle(callbackURL, callback)
+	mux.Handle(callbackURL+"/", callback)
 }
 
-// LoginHandler handles the login endpoint.
-func LoginHandler(googleOAuthConfig *oauth2.Config) http.Handler {
+// LoginHandler handles the login endpoint, redirecting to the identity provider named in the path.
+// The return_to query value is where the user goes after logging in.
+func LoginHandler(providers *Providers, states StateStore, returnURLs *ReturnURLs) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			login(w, r, googleOAuthConfig)
+			login(w, r, providers, states, returnURLs)
 		default:
 			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
 		}
 	})
 }
 
-// CallbackHandler handles the callback from Google.
-func CallbackHandler(googleOAuthConfig *oauth2.Config, storage Storage) http.Handler {
+// CallbackHandler handles the callback from the identity provider named in the path.
+// Users returning to other applications take a login code with them, exchanged for the tokens by the application.
+func CallbackHandler(providers *Providers, states StateStore, codes LoginCodeStore, signer Signer, tokenCfg TokenConfig, storage Storage) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			callback(w, r, googleOAuthConfig, storage)
+			callback(w, r, providers, states, codes, signer, tokenCfg, storage)
 		default:
 			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
 		}
 	})
 }
 
-// TODO(JOJO): randomize this
-var randState = "random"
+// providerFromPath returns the identity provider named after the given prefix of the request path.
+func providerFromPath(r *http.Request, prefix string, providers *Providers) (IdentityProvider, error) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	provider, ok := providers.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown identity provider %q", name)
+	}
+	return provider, nil
+}
 
-func login(w http.ResponseWriter, r *http.Request, googleOAuthConfig *oauth2.Config) {
+func login(w http.ResponseWriter, r *http.Request, providers *Providers, states StateStore, returnURLs *ReturnURLs) {
 	ctx := r.Context()
 	log := slog.FromCtx(ctx)
 
-	url := googleOAuthConfig.AuthCodeURL(randState, oauth2.AccessTypeOnline)
+	provider, err := providerFromPath(r, loginURL, providers)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusNotFound)
+		return
+	}
+
+	returnTo, err := returnURLs.Validate(r.URL.Query().Get("return_to"))
+	if err != nil {
+		log.Warn("rejected return url", "return_to", r.URL.Query().Get("return_to"))
+		sendErr(ctx, w, err, http.StatusBadRequest)
+		return
+	}
+	challenge, err := returnChallenge(r, returnTo)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusBadRequest)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	nonce, err := randomString(16)
+	if err != nil {
+		slog.Error("error creating nonce", "error", err.Error())
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	loginState := LoginState{
+		Provider:        provider.Name(),
+		Nonce:           nonce,
+		CodeVerifier:    verifier,
+		ReturnTo:        returnTo,
+		ReturnChallenge: challenge,
+	}
+	state, err := newState(w, states, loginState)
+	if err != nil {
+		slog.Error("error creating state", "error", err.Error())
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	url := provider.AuthCodeURL(state, loginState)
 	http.Redirect(w, r, url, http.StatusFound)
-	log.Info("login request received")
+	log.Info("login request received", "provider", provider.Name())
 }
 
-func callback(w http.ResponseWriter, r *http.Request, googleOAuthConfig *oauth2.Config, storage Storage) {
+func callback(w http.ResponseWriter, r *http.Request, providers *Providers, states StateStore, codes LoginCodeStore, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	provider, err := providerFromPath(r, callbackURL, providers)
+	if err != nil {
+		sendErr(r.Context(), w, err, http.StatusNotFound)
+		return
+	}
+
 	state := r.FormValue("state")
 	if state == "" {
 		sendErr(r.Context(), w, errors.New("missing state"), http.StatusBadRequest)
 		return
 	}
-	if state != randState {
-		sendErr(r.Context(), w, errors.New("invalid state"), http.StatusBadRequest)
+	loginState, err := verifyState(w, r, states, state)
+	if err != nil {
+		sendErr(r.Context(), w, err, http.StatusBadRequest)
+		return
+	}
+	if loginState.Provider != provider.Name() {
+		sendErr(r.Context(), w, ErrInvalidState, http.StatusBadRequest)
 		return
 	}
 
@@ -85,75 +166,193 @@ func callback(w http.ResponseWriter, r *http.Request, googleOAuthConfig *oauth2.
 	}
 
 	ctx := r.Context()
-	token, err := googleOAuthConfig.Exchange(ctx, code)
+	log := slog.FromCtx(ctx)
+	token, err := provider.Exchange(ctx, code, loginState)
 	if err != nil {
 		slog.Error("error exchanging token", "error", err.Error())
 		sendErr(ctx, w, err, http.StatusInternalServerError)
 		return
 	}
 
-	client := googleOAuthConfig.Client(ctx, token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+	identity, err := provider.Identity(ctx, token, loginState)
+	if err == nil && (identity.Email == "" || !identity.EmailVerified) {
+		// Users are identified by their email, so it must be one the provider verified.
+		err = ErrNoVerifiedEmail
+	}
+	if err == nil && !providers.EmailAllowed(identity.Email) {
+		err = fmt.Errorf("%w: %s", ErrDomainNotAllowed, identity.Email)
+	}
+	if errors.Is(err, ErrNoVerifiedEmail) || errors.Is(err, ErrTenantNotAllowed) || errors.Is(err, ErrDomainNotAllowed) {
+		log.Info("login rejected", "provider", provider.Name(), "error", err.Error())
+		sendLoginErr(w, err, http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, ErrInvalidIDToken) {
+		slog.Warn("invalid id token", "provider", provider.Name(), "error", err.Error())
+		sendErr(ctx, w, ErrInvalidIDToken, http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
 		slog.Error("error getting user info", "error", err.Error())
 		sendErr(ctx, w, err, http.StatusInternalServerError)
 		return
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-
-	d := json.NewDecoder(resp.Body)
 
-	var usr legitima.GoogleUser
-	err = d.Decode(&usr)
+	err = storage.SaveUser(identity)
+	if errors.Is(err, legitima.ErrIdentityConflict) {
+		// The email is not linked to another provider silently, that provider may not own it.
+		log.Info("login rejected", "provider", provider.Name(), "email", identity.Email, "error", err.Error())
+		sendLoginErr(w, err, http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		slog.Error("error decoding user info", "error", err.Error())
+		slog.Error("error saving user", "error", err.Error())
 		sendErr(ctx, w, err, http.StatusInternalServerError)
 		return
 	}
 
-	err = storage.SaveUser(usr)
+	res, refreshToken, err := issueTokens(signer, tokenCfg, storage, legitima.RefreshToken{Email: identity.Email})
 	if err != nil {
-		slog.Error("error saving user", "error", err.Error())
+		slog.Error("error generating token", "error", err.Error())
 		sendErr(ctx, w, err, http.StatusInternalServerError)
 		return
 	}
 
-	tokenString, err := GenerateToken(usr.Email)
+	err = storage.SaveRefreshToken(refreshToken)
 	if err != nil {
-		slog.Error("error generating token", "error", err.Error())
+		slog.Error("error saving refresh token", "error", err.Error())
 		sendErr(ctx, w, err, http.StatusInternalServerError)
 		return
 	}
 
-	cookie := http.Cookie{
-		Name:     "Authorization",
-		Value:    "Bearer " + tokenString,
-		HttpOnly: true,
-		Path:     profileURL,
-		Secure:   true,
+	location, err := returnURL(codes, identity.Email, loginState)
+	if err != nil {
+		slog.Error("error creating login code", "error", err.Error())
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
 	}
-	http.SetCookie(w, &cookie)
-	http.Redirect(w, r, profileURL, http.StatusSeeOther)
+
+	setTokenCookies(w, tokenCfg, res)
+	http.Redirect(w, r, location, http.StatusSeeOther)
 }
 
-//go:embed templates/index.html
-var indexTemplateFS embed.FS
+// GoogleConfig configures the Google provider.
+type GoogleConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// HostedDomains are the Google Workspace domains whose users can log in.
+	// When empty any Google account with a verified email can log in.
+	HostedDomains []string
+	// Issuer overrides GoogleIssuer.
+	Issuer string
+}
 
-// HomeHandler handles the home page.
-func HomeHandler(w http.ResponseWriter, _ *http.Request) {
+// GoogleProvider authenticates users with Google accounts, consumed as an OpenID Connect issuer.
+type GoogleProvider struct {
+	*OIDCProvider
+	hostedDomains []string
+}
 
-	tmpl, err := template.ParseFS(indexTemplateFS, "templates/index.html")
+// NewGoogleProvider returns a new GoogleProvider instance configured from the discovery document of Google.
+func NewGoogleProvider(ctx context.Context, cfg GoogleConfig) (*GoogleProvider, error) {
+	if cfg.Issuer == "" {
+		cfg.Issuer = GoogleIssuer
+	}
+	oidc, err := NewOIDCProvider(ctx, OIDCConfig{
+		Name:         "google",
+		Issuer:       cfg.Issuer,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+	})
 	if err != nil {
-		slog.Error("failed to parse template", "error", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	return &GoogleProvider{OIDCProvider: oidc, hostedDomains: cfg.HostedDomains}, nil
+}
+
+// AuthCodeURL returns the URL of the Google consent page, hinting the hosted domain of the accounts to choose from.
+func (p *GoogleProvider) AuthCodeURL(state string, login LoginState) string {
+	switch len(p.hostedDomains) {
+	case 0:
+		return p.authCodeURL(state, login)
+	case 1:
+		return p.authCodeURL(state, login, oauth2.SetAuthURLParam("hd", p.hostedDomains[0]))
+	default:
+		// Any Workspace domain, the allowed ones are checked in the callback.
+		return p.authCodeURL(state, login, oauth2.SetAuthURLParam("hd", "*"))
 	}
-	err = tmpl.Execute(w, nil)
+}
+
+// Identity validates the id_token, the verified email and the hosted domain of the user,
+// creating the identity from its claims. The hd parameter is just a hint, so the hd claim is always checked.
+func (p *GoogleProvider) Identity(ctx context.Context, token *oauth2.Token, login LoginState) (legitima.Identity, error) {
+	claims, err := p.claims(ctx, token, login)
 	if err != nil {
-		slog.Error("failed to execute template", "error", err.Error())
+		return legitima.Identity{}, err
+	}
+	if !claims.EmailVerified {
+		return legitima.Identity{}, ErrNoVerifiedEmail
+	}
+	if len(p.hostedDomains) > 0 && !contains(p.hostedDomains, claims.HostedDomain) {
+		return legitima.Identity{}, fmt.Errorf("%w: %s", ErrDomainNotAllowed, claims.Email)
+	}
+	return p.identity(claims), nil
+}
+
+//go:embed templates/login_error.html
+var loginErrorTemplateFS embed.FS
+
+// sendLoginErr renders the page telling the user why the login failed.
+func sendLoginErr(w http.ResponseWriter, err error, status int) {
+	tmpl, tmplErr := template.ParseFS(loginErrorTemplateFS, "templates/login_error.html")
+	if tmplErr != nil {
+		slog.Error("failed to parse template", "error", tmplErr.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmplErr = tmpl.Execute(w, struct{ Message string }{loginErrMessage(err)})
+	if tmplErr != nil {
+		slog.Error("failed to execute template", "error", tmplErr.Error())
+	}
+}
+
+// loginErrMessage explains the login errors to the user.
+func loginErrMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrNoVerifiedEmail):
+		return "Your account has no verified email."
+	case errors.Is(err, ErrDomainNotAllowed):
+		return "Only company accounts can log in. Please log in with your company account."
+	case errors.Is(err, ErrTenantNotAllowed):
+		return "Your organization is not allowed to log in."
+	case errors.Is(err, legitima.ErrIdentityConflict):
+		return "Your email is linked to another account. Please log in the way you did the first time."
+	default:
+		return err.Error()
+	}
+}
+
+//go:embed templates/index.html
+var indexTemplateFS embed.FS
+
+// HomeHandler handles the home page, linking to the login of each provider.
+func HomeHandler(providers *Providers) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		tmpl, err := template.ParseFS(indexTemplateFS, "templates/index.html")
+		if err != nil {
+			slog.Error("failed to parse template", "error", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = tmpl.Execute(w, providers.Names())
+		if err != nil {
+			slog.Error("failed to execute template", "error", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	})
 }
@@ -1,33 +1,51 @@
 package api_test
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/birdie-ai/legitima"
 	"github.com/birdie-ai/legitima/api"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"github.com/golang-jwt/jwt"
 )
 
-type mockStorage interface {
-	SaveUser(gUsr legitima.GoogleUser) error
-	UserByEmail(email string) (*legitima.User, error)
+func newProviders(t *testing.T, issuer *testIssuer) *api.Providers {
+	t.Helper()
+	providers, err := api.NewProviders(issuer.provider(t, "google"))
+	if err != nil {
+		t.Fatalf("failed to create providers: %v", err)
+	}
+	return providers
 }
 
-func TestAuth_Callback_EmptyCode(t *testing.T) {
-	mStorage := new(mockStorage)
-	googleOAuthConfig := oauth2.Config{
-		ClientID:     "client-id",
-		ClientSecret: "client-secret",
-		Endpoint:     google.Endpoint,
-		RedirectURL:  "http://localhost:8080/callback",
-		Scopes: []string{"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile"},
+func newCallbackRequest(state, cookie string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/callback/google?state="+url.QueryEscape(state), nil)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: "oauth_state", Value: cookie})
+	}
+	return req
+}
+
+func errMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var res api.ErrorResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
 	}
+	return res.Error.Message
+}
 
-	h := api.CallbackHandler(&googleOAuthConfig, *mStorage)
+func TestAuth_Callback_EmptyCode(t *testing.T) {
+	mStorage := newMockStorage()
+	h := api.CallbackHandler(newProviders(t, newTestIssuer(t)), api.NewMemoryStateStore(), api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), mStorage)
 	req := httptest.NewRequest("GET", "/callback", nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, req)
@@ -36,3 +54,314 @@ func TestAuth_Callback_EmptyCode(t *testing.T) {
 		t.Errorf("expected 400, got %d", w.Code)
 	}
 }
+
+func TestAuth_Login_State(t *testing.T) {
+	states := api.NewMemoryStateStore()
+	h := api.LoginHandler(newProviders(t, newTestIssuer(t)), states, nil)
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse location: %v", err)
+	}
+	state := location.Query().Get("state")
+	if state == "" {
+		t.Fatal("expected state in the redirect url")
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "oauth_state" {
+		t.Fatalf("expected oauth_state cookie, got %v", cookies)
+	}
+	if cookies[0].Value != state {
+		t.Fatalf("expected cookie %s, got %s", state, cookies[0].Value)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	location2, _ := url.Parse(w.Header().Get("Location"))
+	if location2.Query().Get("state") == state {
+		t.Fatal("expected a new state for each login")
+	}
+}
+
+func TestAuth_Callback_ForgedState(t *testing.T) {
+	states := api.NewMemoryStateStore()
+	err := states.Save("legit", api.LoginState{Provider: "google", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+	h := api.CallbackHandler(newProviders(t, newTestIssuer(t)), states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), nil)
+
+	tests := []struct {
+		name   string
+		state  string
+		cookie string
+	}{
+		{name: "unknown state", state: "forged", cookie: "forged"},
+		{name: "missing cookie", state: "legit"},
+		{name: "cookie mismatch", state: "legit", cookie: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, newCallbackRequest(tt.state, tt.cookie))
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", w.Code)
+			}
+			if msg := errMessage(t, w); msg != api.ErrInvalidState.Error() {
+				t.Fatalf("expected %q, got %q", api.ErrInvalidState, msg)
+			}
+		})
+	}
+}
+
+func TestAuth_Callback_ExpiredState(t *testing.T) {
+	states := api.NewMemoryStateStore()
+	err := states.Save("expired", api.LoginState{Provider: "google", ExpiresAt: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+	h := api.CallbackHandler(newProviders(t, newTestIssuer(t)), states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newCallbackRequest("expired", "expired"))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if msg := errMessage(t, w); msg != api.ErrStateExpired.Error() {
+		t.Fatalf("expected %q, got %q", api.ErrStateExpired, msg)
+	}
+}
+
+func TestAuth_Callback_ReplayedState(t *testing.T) {
+	states := api.NewMemoryStateStore()
+	err := states.Save("once", api.LoginState{Provider: "google", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+	h := api.CallbackHandler(newProviders(t, newTestIssuer(t)), states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), nil)
+
+	// The first request goes through the state check and fails on the missing code.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newCallbackRequest("once", "once"))
+	if msg := errMessage(t, w); msg != "missing code" {
+		t.Fatalf("expected %q, got %q", "missing code", msg)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, newCallbackRequest("once", "once"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if msg := errMessage(t, w); msg != api.ErrInvalidState.Error() {
+		t.Fatalf("expected %q, got %q", api.ErrInvalidState, msg)
+	}
+}
+
+func TestAuth_PKCE(t *testing.T) {
+	issuer := newTestIssuer(t)
+	providers := newProviders(t, issuer)
+	states := api.NewMemoryStateStore()
+
+	w := httptest.NewRecorder()
+	api.LoginHandler(providers, states, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse location: %v", err)
+	}
+	query := location.Query()
+	if method := query.Get("code_challenge_method"); method != "S256" {
+		t.Fatalf("expected S256 challenge method, got %q", method)
+	}
+	challenge := query.Get("code_challenge")
+	state := query.Get("state")
+
+	req := newCallbackRequest(state, state)
+	req.URL.RawQuery += "&code=code"
+	w = httptest.NewRecorder()
+	api.CallbackHandler(providers, states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), nil).ServeHTTP(w, req)
+
+	if issuer.codeVerifier == "" {
+		t.Fatal("expected code_verifier on the token exchange")
+	}
+	sum := sha256.Sum256([]byte(issuer.codeVerifier))
+	if want := base64.RawURLEncoding.EncodeToString(sum[:]); challenge != want {
+		t.Fatalf("expected challenge %s, got %s", want, challenge)
+	}
+}
+
+func TestAuth_UnknownProvider(t *testing.T) {
+	states := api.NewMemoryStateStore()
+	h := api.LoginHandler(newProviders(t, newTestIssuer(t)), states, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login/unknown", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestAuth_Callback_ProviderMismatch(t *testing.T) {
+	states := api.NewMemoryStateStore()
+	err := states.Save("other", api.LoginState{Provider: "other", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+	h := api.CallbackHandler(newProviders(t, newTestIssuer(t)), states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newCallbackRequest("other", "other"))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if msg := errMessage(t, w); msg != api.ErrInvalidState.Error() {
+		t.Fatalf("expected %q, got %q", api.ErrInvalidState, msg)
+	}
+}
+
+func googleCallback(t *testing.T, issuer *testIssuer, hostedDomains []string, storage api.Storage) *httptest.ResponseRecorder {
+	t.Helper()
+	provider, err := api.NewGoogleProvider(context.Background(), api.GoogleConfig{
+		ClientID:      "client-id",
+		ClientSecret:  "client-secret",
+		HostedDomains: hostedDomains,
+		Issuer:        issuer.server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create google provider: %v", err)
+	}
+	providers, err := api.NewProviders(provider)
+	if err != nil {
+		t.Fatalf("failed to create providers: %v", err)
+	}
+	states := api.NewMemoryStateStore()
+	err = states.Save("state", api.LoginState{Provider: "google", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	req := newCallbackRequest("state", "state")
+	req.URL.RawQuery += "&code=code"
+	w := httptest.NewRecorder()
+	api.CallbackHandler(providers, states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), storage).ServeHTTP(w, req)
+	return w
+}
+
+func TestGoogle_Login_HostedDomainHint(t *testing.T) {
+	issuer := newTestIssuer(t)
+	tests := []struct {
+		name    string
+		domains []string
+		want    string
+	}{
+		{name: "no domains"},
+		{name: "one domain", domains: []string{"example.com"}, want: "example.com"},
+		{name: "many domains", domains: []string{"example.com", "example.org"}, want: "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := api.NewGoogleProvider(context.Background(), api.GoogleConfig{
+				ClientID:      "client-id",
+				HostedDomains: tt.domains,
+				Issuer:        issuer.server.URL,
+			})
+			if err != nil {
+				t.Fatalf("failed to create google provider: %v", err)
+			}
+			location, err := url.Parse(provider.AuthCodeURL("state", api.LoginState{Nonce: "nonce"}))
+			if err != nil {
+				t.Fatalf("failed to parse auth url: %v", err)
+			}
+			if hd := location.Query().Get("hd"); hd != tt.want {
+				t.Fatalf("expected hd %q, got %q", tt.want, hd)
+			}
+		})
+	}
+}
+
+func TestGoogle_Callback_Restrictions(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		claims  jwt.MapClaims
+		code    int
+		message string
+	}{
+		{name: "any account", code: http.StatusSeeOther},
+		{name: "allowed domain", domains: []string{"example.com"}, claims: jwt.MapClaims{"hd": "example.com"}, code: http.StatusSeeOther},
+		{name: "unverified email", claims: jwt.MapClaims{"email_verified": false}, code: http.StatusForbidden, message: "no verified email"},
+		{name: "other domain", domains: []string{"example.com"}, claims: jwt.MapClaims{"hd": "evil.com"}, code: http.StatusForbidden, message: "company account"},
+		{name: "consumer account", domains: []string{"example.com"}, code: http.StatusForbidden, message: "company account"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newTestIssuer(t)
+			for k, v := range tt.claims {
+				issuer.claims[k] = v
+			}
+			storage := newMockStorage()
+
+			w := googleCallback(t, issuer, tt.domains, storage)
+
+			if w.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+			if tt.message != "" && !strings.Contains(w.Body.String(), tt.message) {
+				t.Fatalf("expected login error page with %q, got %s", tt.message, w.Body.String())
+			}
+			_, err := storage.UserByEmail("jj@example.com")
+			if saved := err == nil; saved != (tt.code == http.StatusSeeOther) {
+				t.Fatalf("expected user saved to be %v", !saved)
+			}
+		})
+	}
+}
+
+func TestAuth_Callback_AllowedEmailDomains(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		code    int
+	}{
+		{name: "any domain", code: http.StatusSeeOther},
+		{name: "allowed domain", domains: []string{"Example.com"}, code: http.StatusSeeOther},
+		{name: "other domain", domains: []string{"example.org"}, code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers := newProviders(t, newTestIssuer(t))
+			providers.AllowEmailDomains(tt.domains...)
+			states := api.NewMemoryStateStore()
+			err := states.Save("state", api.LoginState{Provider: "google", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: time.Now().Add(time.Minute)})
+			if err != nil {
+				t.Fatalf("failed to save state: %v", err)
+			}
+			storage := newMockStorage()
+
+			req := newCallbackRequest("state", "state")
+			req.URL.RawQuery += "&code=code"
+			w := httptest.NewRecorder()
+			api.CallbackHandler(providers, states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), storage).ServeHTTP(w, req)
+
+			if w.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+			if tt.code == http.StatusForbidden && !strings.Contains(w.Body.String(), "company account") {
+				t.Fatalf("expected login error page, got %s", w.Body.String())
+			}
+			_, err = storage.UserByEmail("jj@example.com")
+			if saved := err == nil; saved != (tt.code == http.StatusSeeOther) {
+				t.Fatalf("expected user saved to be %v", !saved)
+			}
+		})
+	}
+}
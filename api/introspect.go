@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/birdie-ai/legitima"
+)
+
+const introspectURL = "/oauth/introspect"
+
+// IntrospectionResponse is the response of the introspection endpoint, as defined in RFC 7662.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Nbf       int64  `json:"nbf,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	Email     string `json:"email,omitempty"`
+	// Roles are the roles of the user when the token was issued.
+	Roles []string `json:"roles,omitempty"`
+	OrgID string   `json:"org_id,omitempty"`
+}
+
+// SetupIntrospection sets up the token introspection endpoint.
+func SetupIntrospection(mux *http.ServeMux, verifier Verifier, tokenCfg TokenConfig, clients ClientAuthenticator, registered ClientStorage) {
+	mux.Handle(introspectURL, IntrospectionHandler(verifier, tokenCfg, clients, registered))
+}
+
+// IntrospectionHandler handles the token introspection endpoint.
+// The resource servers calling it must authenticate with their client credentials.
+// Besides the tokens for legitima, the tokens exchanged for a registered client or for the caller are active.
+func IntrospectionHandler(verifier Verifier, tokenCfg TokenConfig, clients ClientAuthenticator, registered ClientStorage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			introspect(w, r, verifier, tokenCfg, clients, registered)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func introspect(w http.ResponseWriter, r *http.Request, verifier Verifier, tokenCfg TokenConfig, clients ClientAuthenticator, registered ClientStorage) {
+	ctx := r.Context()
+
+	callerID, err := authenticateClient(r, clients)
+	if err != nil {
+		sendOAuthErr(ctx, w, "invalid_client", err, http.StatusUnauthorized)
+		return
+	}
+
+	tokenString := r.PostFormValue("token")
+	if tokenString == "" {
+		sendOAuthErr(ctx, w, "invalid_request", errors.New("missing token"), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Any token that is not valid, for whatever reason, is just inactive.
+	// The audience is checked apart, exchanged tokens are for the client they were exchanged for.
+	anyAudience := tokenCfg
+	anyAudience.Audience = ""
+	token, err := parseToken(tokenString, verifier, anyAudience)
+	if err != nil {
+		sendJSON(ctx, w, http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+	known, err := knownAudience(token.Claims.Audience, tokenCfg, callerID, registered)
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+	if !known {
+		sendJSON(ctx, w, http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	claims := token.Claims
+	sendJSON(ctx, w, http.StatusOK, IntrospectionResponse{
+		Active:    true,
+		TokenType: "Bearer",
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Nbf:       claims.NotBefore,
+		Sub:       claims.Subject,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.Id,
+		Scope:     claims.Scope,
+		Email:     token.Email,
+		ClientID:  token.ClientID,
+		Roles:     claims.Roles,
+		OrgID:     token.OrgID,
+	})
+}
+
+// knownAudience tells if the audience is legitima itself, the caller or a registered client.
+func knownAudience(aud string, tokenCfg TokenConfig, callerID string, registered ClientStorage) (bool, error) {
+	if aud == tokenCfg.Audience || aud == callerID {
+		return true, nil
+	}
+	_, err := registered.ClientByID(aud)
+	if errors.Is(err, legitima.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
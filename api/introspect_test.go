@@ -0,0 +1,137 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima/api"
+)
+
+func introspectionRequest(token string) *http.Request {
+	form := url.Values{"token": {token}}
+	r := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("service", "service-secret")
+	return r
+}
+
+func introspectionResponse(t *testing.T, w *httptest.ResponseRecorder) api.IntrospectionResponse {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var res api.IntrospectionResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return res
+}
+
+func TestIntrospection(t *testing.T) {
+	storage := newMockStorage()
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+	cfg.Revocations = storage
+	h := api.IntrospectionHandler(key, cfg, api.StaticClients{"service": "service-secret"}, storage)
+
+	token, err := api.GenerateToken(key, cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, introspectionRequest(token))
+	res := introspectionResponse(t, w)
+	if !res.Active || res.Email != "jj@gmail.com" || res.Sub != "jj@gmail.com" || res.Exp == 0 {
+		t.Fatalf("unexpected response for a valid token: %+v", res)
+	}
+
+	parsed, err := api.TokenFromHeader(requestWithToken(token), key, cfg)
+	if err != nil {
+		t.Fatalf("failed to parse token: %v", err)
+	}
+	err = storage.RevokeToken(parsed.Claims.Id, time.Unix(parsed.Claims.ExpiresAt, 0))
+	if err != nil {
+		t.Fatalf("failed to revoke token: %v", err)
+	}
+
+	for name, token := range map[string]string{"revoked": token, "garbage": "garbage"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, introspectionRequest(token))
+		if res := introspectionResponse(t, w); !reflect.DeepEqual(res, api.IntrospectionResponse{}) {
+			t.Fatalf("expected only active false for a %s token, got %+v", name, res)
+		}
+	}
+}
+
+func TestIntrospection_ExchangedToken(t *testing.T) {
+	s := newAuthorizeServer(t)
+	h := api.IntrospectionHandler(s.key, api.DefaultTokenConfig(), api.StaticClients{"service": "service-secret"}, s.storage)
+	subject := s.subjectToken(t, api.DefaultTokenConfig(), "jobs:read")
+	exchanged := s.exchange(t, subject, url.Values{"audience": {"worker"}})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, introspectionRequest(exchanged.AccessToken))
+	res := introspectionResponse(t, w)
+	if !res.Active || res.Aud != "worker" || res.Email != "jj@example.com" || res.Scope != "jobs:read" {
+		t.Fatalf("expected the exchanged token to be active for the worker, got %+v", res)
+	}
+
+	for aud, active := range map[string]bool{"service": true, "unknown": false} {
+		cfg := api.DefaultTokenConfig()
+		cfg.Audience = aud
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, introspectionRequest(s.subjectToken(t, cfg)))
+		if res := introspectionResponse(t, w); res.Active != active {
+			t.Fatalf("expected active %v for a token for %s, got %+v", active, aud, res)
+		}
+	}
+}
+
+func TestIntrospection_ClientAuthentication(t *testing.T) {
+	h := api.IntrospectionHandler(newKey(t), api.DefaultTokenConfig(), api.StaticClients{"service": "other-secret"}, newMockStorage())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, introspectionRequest("token"))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	var res api.OAuthError
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if res.Error != "invalid_client" {
+		t.Fatalf("expected invalid_client, got %s", res.Error)
+	}
+}
+
+func TestParseStaticClients(t *testing.T) {
+	clients, err := api.ParseStaticClients("a:secret-a, b:secret-b,")
+	if err != nil {
+		t.Fatalf("failed to parse clients: %v", err)
+	}
+	if len(clients) != 2 || clients["b"] != "secret-b" {
+		t.Fatalf("unexpected clients: %v", clients)
+	}
+
+	_, err = api.ParseStaticClients("a")
+	if err == nil {
+		t.Fatal("expected error for a client without secret")
+	}
+
+	_, err = api.ParseStaticClients("a:secret-a,:secret-b")
+	if err == nil {
+		t.Fatal("expected error for a client without id")
+	}
+	if strings.Contains(err.Error(), "secret-b") {
+		t.Fatalf("expected the secret to be left out of the error, got %q", err)
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const jwksURL = "/.well-known/jwks.json"
+
+// JWKS is a JSON Web Key Set as defined in RFC 7517.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is a public JSON Web Key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWK returns the key in its JWK representation.
+func (k PublicKey) JWK() JWK {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch key := k.Key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(key.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = encodeSegment(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeSegment(key.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeSegment(key)
+	}
+	return jwk
+}
+
+// PublicKey returns the public key represented by the JWK.
+// The signing method is taken from the alg member when present.
+func (k JWK) PublicKey() (PublicKey, error) {
+	var key crypto.PublicKey
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return PublicKey{}, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		key = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return PublicKey{}, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return PublicKey{}, errors.New("invalid Ed25519 key size")
+		}
+		key = ed25519.PublicKey(x)
+	default:
+		return PublicKey{}, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+
+	pk, err := NewPublicKey(k.Kid, key)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	if k.Alg != "" {
+		method := jwt.GetSigningMethod(k.Alg)
+		if method == nil {
+			return PublicKey{}, fmt.Errorf("unsupported algorithm %s", k.Alg)
+		}
+		pk.Method = method
+	}
+	return pk, nil
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the key.
+func (k JWK) Thumbprint() string {
+	// The required members, in lexicographic order.
+	var members interface{}
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	}
+	b, _ := json.Marshal(members)
+	sum := sha256.Sum256(b)
+	return encodeSegment(sum[:])
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// SetupJWKS sets up the endpoint publishing the token verification keys.
+func SetupJWKS(mux *http.ServeMux, publisher KeyPublisher) {
+	mux.Handle(jwksURL, JWKSHandler(publisher))
+}
+
+// JWKSHandler handles the JWKS endpoint.
+func JWKSHandler(publisher KeyPublisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Cache-Control", "public, max-age=300")
+			sendJSON(r.Context(), w, http.StatusOK, publisher.JWKS())
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+	"github.com/golang-jwt/jwt"
+)
+
+// keyRingRefresh is how often a running KeyRing reloads the keys from the storage,
+// so keys rotated by other instances are picked up.
+const keyRingRefresh = time.Minute
+
+// ErrNoActiveKey is returned when signing without an active key.
+var ErrNoActiveKey = errors.New("no active signing key")
+
+// KeyStorage persists the signing keys.
+type KeyStorage interface {
+	SigningKeys() ([]legitima.SigningKey, error)
+	RotateSigningKeys(activeID string, next legitima.SigningKey) error
+	DeleteRetiredSigningKeys(before time.Time) (int64, error)
+}
+
+// KeyRing signs tokens with the active key of a rotating set of keys.
+// Tokens signed by any key that is not retired are accepted, so rotating
+// the keys does not invalidate the tokens already issued.
+type KeyRing struct {
+	storage   KeyStorage
+	algorithm string
+
+	mu          sync.RWMutex
+	active      *PrivateKey
+	activatedAt time.Time
+	set         *KeySet
+}
+
+// NewKeyRing returns a new KeyRing instance with the keys loaded from the storage.
+// If there is no active key yet the keys are rotated until there is one.
+// New keys are generated with the given signing algorithm: RS256, ES256 or EdDSA.
+func NewKeyRing(storage KeyStorage, algorithm string) (*KeyRing, error) {
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg(), jwt.SigningMethodEdDSA.Alg():
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	k := &KeyRing{storage: storage, algorithm: algorithm, set: NewKeySet()}
+	err := k.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// A fresh storage needs one rotation to create the next key and another one to activate it.
+	for i := 0; i < 2 && k.ActiveID() == ""; i++ {
+		err = k.Rotate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Load reloads the keys from the storage.
+func (k *KeyRing) Load() error {
+	keys, err := k.storage.SigningKeys()
+	if err != nil {
+		return fmt.Errorf("loading signing keys: %w", err)
+	}
+
+	var (
+		active      *PrivateKey
+		activatedAt time.Time
+		public      []PublicKey
+	)
+	for _, key := range keys {
+		if key.State == legitima.KeyStateRetired {
+			continue
+		}
+		pk, err := ParsePrivateKey(key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("parsing signing key %s: %w", key.ID, err)
+		}
+		// The stored ID is the one stamped in the tokens, even if it is not a thumbprint.
+		pk.public.ID = key.ID
+		public = append(public, pk.Public())
+		if key.State == legitima.KeyStateActive {
+			active = pk
+			activatedAt = key.ActivatedAt
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active = active
+	k.activatedAt = activatedAt
+	k.set = NewKeySet(public...)
+	return nil
+}
+
+// Rotate generates a new next key, activates the current next key and
+// retires the previous ones.
+func (k *KeyRing) Rotate() error {
+	next, err := GenerateKey(k.algorithm)
+	if err != nil {
+		return err
+	}
+	nextPEM, err := next.PEM()
+	if err != nil {
+		return err
+	}
+
+	err = k.storage.RotateSigningKeys(k.ActiveID(), legitima.SigningKey{
+		ID:         next.ID(),
+		State:      legitima.KeyStateNext,
+		PrivateKey: nextPEM,
+	})
+	if err != nil {
+		return fmt.Errorf("rotating signing keys: %w", err)
+	}
+	return k.Load()
+}
+
+// Run reloads the keys periodically and rotates them once the active key is older than period,
+// which must be longer than the token TTL and leeway so the retiring key outlives its tokens.
+// The retention is how long the retired keys are kept before they are deleted from the storage,
+// it has nothing to do with the rotation period. It blocks until the context is cancelled.
+func (k *KeyRing) Run(ctx context.Context, period, retention time.Duration) {
+	log := slog.FromCtx(ctx)
+	ticker := time.NewTicker(keyRingRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := k.storage.DeleteRetiredSigningKeys(time.Now().Add(-retention))
+		if err != nil {
+			log.Error("failed to delete retired signing keys", "error", err.Error())
+		} else if n > 0 {
+			log.Info("retired signing keys deleted", "count", n)
+		}
+
+		err = k.Load()
+		if err != nil {
+			log.Error("failed to load signing keys", "error", err.Error())
+			continue
+		}
+
+		k.mu.RLock()
+		due := time.Since(k.activatedAt) >= period
+		k.mu.RUnlock()
+		if !due {
+			continue
+		}
+
+		err = k.Rotate()
+		if err != nil {
+			log.Error("failed to rotate signing keys", "error", err.Error())
+			continue
+		}
+		log.Info("signing keys rotated", "active", k.ActiveID())
+	}
+}
+
+// ActiveID returns the ID of the active key, or an empty string if there is none.
+func (k *KeyRing) ActiveID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.active == nil {
+		return ""
+	}
+	return k.active.ID()
+}
+
+// ActivatedAt returns when the active key was activated, zero if there is none.
+func (k *KeyRing) ActivatedAt() time.Time {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.activatedAt
+}
+
+// Sign signs the claims with the active key.
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	active := k.active
+	k.mu.RUnlock()
+	if active == nil {
+		return "", ErrNoActiveKey
+	}
+	return active.Sign(claims)
+}
+
+// VerificationKey returns the public key of any key that is not retired matching the kid header of the token.
+func (k *KeyRing) VerificationKey(token *jwt.Token) (interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.set.VerificationKey(token)
+}
+
+// JWKS returns the public keys that are not retired, including the next one,
+// so verifiers can cache it before it starts signing.
+func (k *KeyRing) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.set.JWKS()
+}
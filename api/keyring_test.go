@@ -0,0 +1,165 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+// memoryKeyStorage mimics the state transitions of mysql.Storage.RotateSigningKeys.
+type memoryKeyStorage struct {
+	keys []legitima.SigningKey
+}
+
+func (s *memoryKeyStorage) SigningKeys() ([]legitima.SigningKey, error) {
+	var keys []legitima.SigningKey
+	for _, k := range s.keys {
+		if k.State != legitima.KeyStateRetired {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryKeyStorage) RotateSigningKeys(activeID string, next legitima.SigningKey) error {
+	var currentID string
+	for _, k := range s.keys {
+		if k.State == legitima.KeyStateActive {
+			currentID = k.ID
+		}
+	}
+	if currentID != activeID {
+		return nil
+	}
+
+	for i, k := range s.keys {
+		switch k.State {
+		case legitima.KeyStateRetiring:
+			s.keys[i].State = legitima.KeyStateRetired
+			s.keys[i].RetiredAt = time.Now()
+		case legitima.KeyStateActive:
+			s.keys[i].State = legitima.KeyStateRetiring
+		case legitima.KeyStateNext:
+			s.keys[i].State = legitima.KeyStateActive
+			s.keys[i].ActivatedAt = time.Now()
+		}
+	}
+	next.State = legitima.KeyStateNext
+	next.CreatedAt = time.Now()
+	s.keys = append(s.keys, next)
+	return nil
+}
+
+func (s *memoryKeyStorage) DeleteRetiredSigningKeys(before time.Time) (int64, error) {
+	var (
+		keys    []legitima.SigningKey
+		deleted int64
+	)
+	for _, k := range s.keys {
+		if k.State == legitima.KeyStateRetired && k.RetiredAt.Before(before) {
+			deleted++
+			continue
+		}
+		keys = append(keys, k)
+	}
+	s.keys = keys
+	return deleted, nil
+}
+
+func (s *memoryKeyStorage) state(id string) legitima.KeyState {
+	for _, k := range s.keys {
+		if k.ID == id {
+			return k.State
+		}
+	}
+	return ""
+}
+
+func TestKeyRing_Rotation(t *testing.T) {
+	storage := &memoryKeyStorage{}
+	keyRing, err := api.NewKeyRing(storage, "ES256")
+	if err != nil {
+		t.Fatalf("failed to create key ring: %v", err)
+	}
+	first := keyRing.ActiveID()
+	if first == "" {
+		t.Fatal("expected an active key after bootstrapping")
+	}
+	if time.Since(keyRing.ActivatedAt()) > time.Minute {
+		t.Fatalf("expected the active key to be just activated, got %v", keyRing.ActivatedAt())
+	}
+	if got := len(keyRing.JWKS().Keys); got != 2 {
+		t.Fatalf("expected the active and next keys to be published, got %d", got)
+	}
+
+	cfg := api.DefaultTokenConfig()
+	token, err := api.GenerateToken(keyRing, cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	err = keyRing.Rotate()
+	if err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+	if keyRing.ActiveID() == first {
+		t.Fatal("expected a new active key after rotating")
+	}
+	if got := storage.state(first); got != legitima.KeyStateRetiring {
+		t.Fatalf("expected first key to be retiring, got %s", got)
+	}
+	_, err = api.TokenFromHeader(requestWithToken(token), keyRing, cfg)
+	if err != nil {
+		t.Fatalf("expected token signed by a retiring key to be valid: %v", err)
+	}
+
+	err = keyRing.Rotate()
+	if err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+	if got := storage.state(first); got != legitima.KeyStateRetired {
+		t.Fatalf("expected first key to be retired, got %s", got)
+	}
+	_, err = api.TokenFromHeader(requestWithToken(token), keyRing, cfg)
+	if !errors.Is(err, api.ErrUnknownKey) {
+		t.Fatalf("expected %v for a token signed by a retired key, got %v", api.ErrUnknownKey, err)
+	}
+}
+
+func TestKeyRing_ConcurrentRotation(t *testing.T) {
+	storage := &memoryKeyStorage{}
+	a, err := api.NewKeyRing(storage, "EdDSA")
+	if err != nil {
+		t.Fatalf("failed to create key ring: %v", err)
+	}
+	b, err := api.NewKeyRing(storage, "EdDSA")
+	if err != nil {
+		t.Fatalf("failed to create key ring: %v", err)
+	}
+	if a.ActiveID() != b.ActiveID() {
+		t.Fatal("expected key rings sharing a storage to share the active key")
+	}
+
+	err = a.Rotate()
+	if err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+	// b still sees the old active key, so its rotation must be a no-op.
+	err = b.Rotate()
+	if err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+	if a.ActiveID() != b.ActiveID() {
+		t.Fatalf("expected a single rotation, got active keys %s and %s", a.ActiveID(), b.ActiveID())
+	}
+}
+
+func TestKeyRing_InvalidAlgorithm(t *testing.T) {
+	_, err := api.NewKeyRing(&memoryKeyStorage{}, "HS256")
+	if err == nil {
+		t.Fatal("expected error for a symmetric algorithm")
+	}
+}
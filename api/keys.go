@@ -0,0 +1,300 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// minSecretLen is the minimum length of an HS256 secret (256 bits).
+const minSecretLen = 32
+
+// Key errors
+var (
+	ErrMissingKey           = errors.New("missing signing key")
+	ErrInvalidSigningMethod = errors.New("invalid signing method")
+	ErrUnknownKey           = errors.New("unknown signing key")
+)
+
+// Signer signs the tokens issued by legitima.
+type Signer interface {
+	Sign(claims jwt.Claims) (string, error)
+}
+
+// Verifier provides the key that verifies the signature of a token.
+type Verifier interface {
+	VerificationKey(token *jwt.Token) (interface{}, error)
+}
+
+// KeyPublisher publishes the public keys that verify the issued tokens.
+type KeyPublisher interface {
+	JWKS() JWKS
+}
+
+// Key signs tokens, verifies them and publishes its public part, if any.
+type Key interface {
+	Signer
+	Verifier
+	KeyPublisher
+}
+
+// KeyConfig tells where the signing key is loaded from.
+// Secret takes precedence over File.
+// A PEM encoded RSA, ECDSA or Ed25519 private key is used for asymmetric signing,
+// anything else is used as an HS256 secret.
+type KeyConfig struct {
+	// Secret is the signing key itself, e.g. from an environment variable.
+	Secret string
+	// File is the path of a file holding the signing key, e.g. a mounted secret.
+	File string
+}
+
+// LoadKey loads the signing key described by cfg.
+func LoadKey(cfg KeyConfig) (Key, error) {
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 && cfg.File != "" {
+		b, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file: %w", err)
+		}
+		secret = bytes.TrimSpace(b)
+	}
+
+	if bytes.HasPrefix(secret, []byte("-----BEGIN")) {
+		return ParsePrivateKey(string(secret))
+	}
+	return NewHMACKey(secret)
+}
+
+// GenerateKey generates a private key for the given signing algorithm: RS256, ES256 or EdDSA.
+func GenerateKey(alg string) (*PrivateKey, error) {
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generating %s key: %w", alg, err)
+	}
+	return NewPrivateKey(key)
+}
+
+// ParsePrivateKey parses a PEM encoded RSA, ECDSA or Ed25519 private key.
+func ParsePrivateKey(pemKey string) (*PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+	return NewPrivateKey(key)
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, nil
+}
+
+// HMACKey signs and verifies tokens with HS256.
+type HMACKey struct {
+	secret []byte
+}
+
+// NewHMACKey returns a new HMACKey instance.
+func NewHMACKey(secret []byte) (*HMACKey, error) {
+	if len(secret) == 0 {
+		return nil, ErrMissingKey
+	}
+	if len(secret) < minSecretLen {
+		return nil, fmt.Errorf("signing key must have at least %d bytes, got %d", minSecretLen, len(secret))
+	}
+	return &HMACKey{secret: secret}, nil
+}
+
+// Sign signs the claims with HS256.
+func (k *HMACKey) Sign(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+}
+
+// VerificationKey returns the secret for HS256 signed tokens.
+func (k *HMACKey) VerificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method != jwt.SigningMethodHS256 {
+		return nil, ErrInvalidSigningMethod
+	}
+	return k.secret, nil
+}
+
+// JWKS returns an empty set, since the secret must never be published.
+func (k *HMACKey) JWKS() JWKS {
+	return JWKS{Keys: []JWK{}}
+}
+
+// PrivateKey signs tokens with an RSA, ECDSA or Ed25519 key.
+type PrivateKey struct {
+	public PublicKey
+	key    crypto.Signer
+}
+
+// NewPrivateKey returns a new PrivateKey instance.
+// The signing method is picked from the key type and the key ID is its JWK thumbprint.
+func NewPrivateKey(key crypto.Signer) (*PrivateKey, error) {
+	public, err := NewPublicKey("", key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{public: public, key: key}, nil
+}
+
+// PEM returns the PKCS #8 PEM encoding of the key.
+func (k *PrivateKey) PEM() (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.key)
+	if err != nil {
+		return "", fmt.Errorf("marshaling private key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ID returns the key ID stamped in the kid header of the signed tokens.
+func (k *PrivateKey) ID() string {
+	return k.public.ID
+}
+
+// Public returns the public part of the key.
+func (k *PrivateKey) Public() PublicKey {
+	return k.public
+}
+
+// Sign signs the claims and stamps the kid header.
+func (k *PrivateKey) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.public.Method, claims)
+	token.Header["kid"] = k.public.ID
+	return token.SignedString(k.key)
+}
+
+// VerificationKey returns the public key for tokens signed by this key.
+func (k *PrivateKey) VerificationKey(token *jwt.Token) (interface{}, error) {
+	return NewKeySet(k.public).VerificationKey(token)
+}
+
+// JWKS returns the public part of the key.
+func (k *PrivateKey) JWKS() JWKS {
+	return NewKeySet(k.public).JWKS()
+}
+
+// PublicKey verifies the tokens signed by the matching PrivateKey.
+type PublicKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Key    crypto.PublicKey
+}
+
+// NewPublicKey returns a new PublicKey instance.
+// If id is empty the JWK thumbprint of the key is used.
+func NewPublicKey(id string, key crypto.PublicKey) (PublicKey, error) {
+	var method jwt.SigningMethod
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return PublicKey{}, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return PublicKey{}, fmt.Errorf("unsupported public key %T", key)
+	}
+
+	pk := PublicKey{ID: id, Method: method, Key: key}
+	if pk.ID == "" {
+		pk.ID = pk.JWK().Thumbprint()
+	}
+	return pk, nil
+}
+
+// KeySet verifies tokens with the public key matching their kid header.
+type KeySet struct {
+	keys []PublicKey
+}
+
+// NewKeySet returns a new KeySet instance.
+func NewKeySet(keys ...PublicKey) *KeySet {
+	return &KeySet{keys: keys}
+}
+
+// VerificationKey returns the public key matching the kid header of the token.
+func (s *KeySet) VerificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("%w: missing kid header", ErrUnknownKey)
+	}
+	for _, k := range s.keys {
+		if k.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != k.Method.Alg() {
+			return nil, ErrInvalidSigningMethod
+		}
+		return k.Key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// JWKS returns the public keys of the set.
+func (s *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(s.keys))}
+	for _, k := range s.keys {
+		jwks.Keys = append(jwks.Keys, k.JWK())
+	}
+	return jwks
+}
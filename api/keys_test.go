@@ -0,0 +1,217 @@
+package api_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/birdie-ai/legitima/api"
+	"github.com/golang-jwt/jwt"
+)
+
+func TestLoadKey(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	file := filepath.Join(t.TempDir(), "secret")
+	err := os.WriteFile(file, []byte(secret+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     api.KeyConfig
+		wantErr bool
+	}{
+		{name: "secret", cfg: api.KeyConfig{Secret: secret}},
+		{name: "file", cfg: api.KeyConfig{File: file}},
+		{name: "missing", cfg: api.KeyConfig{}, wantErr: true},
+		{name: "missing file", cfg: api.KeyConfig{File: file + ".missing"}, wantErr: true},
+		{name: "short secret", cfg: api.KeyConfig{Secret: "secret"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := api.LoadKey(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadKey_Missing(t *testing.T) {
+	_, err := api.LoadKey(api.KeyConfig{})
+	if !errors.Is(err, api.ErrMissingKey) {
+		t.Fatalf("expected %v, got %v", api.ErrMissingKey, err)
+	}
+}
+
+func TestToken_WrongKey(t *testing.T) {
+	cfg := api.DefaultTokenConfig()
+	token, err := api.GenerateToken(newKey(t), cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	other, err := api.NewHMACKey([]byte("fedcba9876543210fedcba9876543210"))
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	_, err = api.TokenFromHeader(requestWithToken(token), other, cfg)
+	if err == nil {
+		t.Fatal("expected error verifying with another key")
+	}
+}
+
+func pemKey(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func newPrivateKey(t *testing.T) *api.PrivateKey {
+	t.Helper()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	key, err := api.NewPrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	return key
+}
+
+func TestLoadKey_Asymmetric(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		wantAlg string
+		wantKty string
+	}{
+		{name: "rsa", key: rsaKey, wantAlg: "RS256", wantKty: "RSA"},
+		{name: "ecdsa", key: ecKey, wantAlg: "ES256", wantKty: "EC"},
+		{name: "ed25519", key: edKey, wantAlg: "EdDSA", wantKty: "OKP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := api.LoadKey(api.KeyConfig{Secret: pemKey(t, tt.key)})
+			if err != nil {
+				t.Fatalf("failed to load key: %v", err)
+			}
+
+			cfg := api.DefaultTokenConfig()
+			token, err := api.GenerateToken(key, cfg, "jj@gmail.com")
+			if err != nil {
+				t.Fatalf("failed to generate token: %v", err)
+			}
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token, &api.Claims{})
+			if err != nil {
+				t.Fatalf("failed to parse token: %v", err)
+			}
+			if parsed.Method.Alg() != tt.wantAlg {
+				t.Fatalf("expected alg %s, got %s", tt.wantAlg, parsed.Method.Alg())
+			}
+
+			jwks := key.JWKS()
+			if len(jwks.Keys) != 1 {
+				t.Fatalf("expected 1 published key, got %d", len(jwks.Keys))
+			}
+			jwk := jwks.Keys[0]
+			if jwk.Kty != tt.wantKty || jwk.Alg != tt.wantAlg {
+				t.Fatalf("unexpected jwk: %+v", jwk)
+			}
+			if parsed.Header["kid"] != jwk.Kid {
+				t.Fatalf("expected kid %s, got %v", jwk.Kid, parsed.Header["kid"])
+			}
+
+			_, err = api.TokenFromHeader(requestWithToken(token), key, cfg)
+			if err != nil {
+				t.Fatalf("failed to verify token: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeySet_PicksKeyByKid(t *testing.T) {
+	cfg := api.DefaultTokenConfig()
+	signing := newPrivateKey(t)
+	other := newPrivateKey(t)
+
+	token, err := api.GenerateToken(signing, cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	set := api.NewKeySet(other.Public(), signing.Public())
+	_, err = api.TokenFromHeader(requestWithToken(token), set, cfg)
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	_, err = api.TokenFromHeader(requestWithToken(token), api.NewKeySet(other.Public()), cfg)
+	if !errors.Is(err, api.ErrUnknownKey) {
+		t.Fatalf("expected %v, got %v", api.ErrUnknownKey, err)
+	}
+
+	// An HS256 token must not be accepted by a set of asymmetric keys.
+	hsToken, err := api.GenerateToken(newKey(t), cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	_, err = api.TokenFromHeader(requestWithToken(hsToken), set, cfg)
+	if err == nil {
+		t.Fatal("expected error verifying HS256 token")
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	key := newPrivateKey(t)
+	w := httptest.NewRecorder()
+	api.JWKSHandler(key).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var jwks api.JWKS
+	err := json.NewDecoder(w.Body).Decode(&jwks)
+	if err != nil {
+		t.Fatalf("failed to decode jwks: %v", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != key.ID() {
+		t.Fatalf("expected key %s, got %+v", key.ID(), jwks.Keys)
+	}
+
+	w = httptest.NewRecorder()
+	api.JWKSHandler(newKey(t)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if body := w.Body.String(); body != "{\"keys\":[]}\n" {
+		t.Fatalf("expected no published keys for HS256, got %s", body)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/birdie-ai/legitima"
+	"golang.org/x/oauth2"
+)
+
+// MicrosoftLoginURL is the base URL of the Microsoft identity platform.
+const MicrosoftLoginURL = "https://login.microsoftonline.com"
+
+// ErrTenantNotAllowed is returned when the user belongs to a Microsoft tenant that is not allowed to log in.
+var ErrTenantNotAllowed = errors.New("tenant not allowed")
+
+// MicrosoftConfig configures the Microsoft Entra ID (Azure AD) provider.
+type MicrosoftConfig struct {
+	// Tenant is common, organizations or the ID of a single tenant.
+	Tenant       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// AllowedTenants are the IDs of the tenants whose users can log in.
+	// It is required by the multi-tenant common and organizations endpoints.
+	AllowedTenants []string
+	// LoginURL overrides MicrosoftLoginURL.
+	LoginURL string
+}
+
+// MicrosoftProvider authenticates users with Microsoft Entra ID (Azure AD) accounts.
+type MicrosoftProvider struct {
+	*OIDCProvider
+	allowedTenants []string
+}
+
+// NewMicrosoftProvider returns a new MicrosoftProvider instance configured from the discovery document of the tenant.
+func NewMicrosoftProvider(ctx context.Context, cfg MicrosoftConfig) (*MicrosoftProvider, error) {
+	if cfg.Tenant == "" {
+		cfg.Tenant = "organizations"
+	}
+	if cfg.LoginURL == "" {
+		cfg.LoginURL = MicrosoftLoginURL
+	}
+	multiTenant := cfg.Tenant == "common" || cfg.Tenant == "organizations"
+	if multiTenant && len(cfg.AllowedTenants) == 0 {
+		return nil, fmt.Errorf("microsoft %s tenant requires allowed tenants", cfg.Tenant)
+	}
+
+	issuer := cfg.LoginURL + "/" + cfg.Tenant + "/v2.0"
+	discovery, err := fetchDiscovery(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	// The multi-tenant endpoints publish an issuer template, completed with the tid claim of each token.
+	if discovery.Issuer != issuer && !(multiTenant && strings.Contains(discovery.Issuer, "{tenantid}")) {
+		return nil, fmt.Errorf("discovering %s: issuer mismatch %s", issuer, discovery.Issuer)
+	}
+
+	return &MicrosoftProvider{
+		OIDCProvider: newOIDCProvider(OIDCConfig{
+			Name:         "microsoft",
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+		}, discovery),
+		allowedTenants: cfg.AllowedTenants,
+	}, nil
+}
+
+// Identity validates the id_token and the tenant of the user, creating the identity from its claims.
+// Microsoft does not verify the email claim, which tenant admins can set to any address, so it is
+// accepted only when the xms_edov optional claim tells the tenant owns the domain of the email.
+func (p *MicrosoftProvider) Identity(ctx context.Context, token *oauth2.Token, login LoginState) (legitima.Identity, error) {
+	claims, err := p.claims(ctx, token, login)
+	if err != nil {
+		return legitima.Identity{}, err
+	}
+	if len(p.allowedTenants) > 0 && !contains(p.allowedTenants, claims.TenantID) {
+		return legitima.Identity{}, fmt.Errorf("%w: %s", ErrTenantNotAllowed, claims.TenantID)
+	}
+
+	if claims.Email == "" || !claims.EmailDomainVerified {
+		return legitima.Identity{}, ErrNoVerifiedEmail
+	}
+	claims.EmailVerified = true
+	return p.identity(claims), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
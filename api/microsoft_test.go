@@ -0,0 +1,145 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima/api"
+	"github.com/golang-jwt/jwt"
+)
+
+const allowedTenant = "11111111-1111-1111-1111-111111111111"
+
+func newMicrosoftIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	issuer := newTestIssuer(t)
+	issuer.issuer = issuer.server.URL + "/{tenantid}/v2.0"
+	issuer.claims = jwt.MapClaims{
+		"iss":      issuer.server.URL + "/" + allowedTenant + "/v2.0",
+		"tid":      allowedTenant,
+		"email":    "jj@contoso.com",
+		"xms_edov": true,
+	}
+	return issuer
+}
+
+func microsoftCallback(t *testing.T, issuer *testIssuer, storage api.Storage) *httptest.ResponseRecorder {
+	t.Helper()
+	provider, err := api.NewMicrosoftProvider(context.Background(), api.MicrosoftConfig{
+		ClientID:       "client-id",
+		ClientSecret:   "client-secret",
+		AllowedTenants: []string{allowedTenant},
+		LoginURL:       issuer.server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create microsoft provider: %v", err)
+	}
+	providers, err := api.NewProviders(provider)
+	if err != nil {
+		t.Fatalf("failed to create providers: %v", err)
+	}
+	states := api.NewMemoryStateStore()
+	err = states.Save("state", api.LoginState{Provider: "microsoft", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/callback/microsoft?state=state&code=code", nil)
+	req.AddCookie(&http.Cookie{Name: "oauth_state", Value: "state"})
+	w := httptest.NewRecorder()
+	api.CallbackHandler(providers, states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), storage).ServeHTTP(w, req)
+	return w
+}
+
+func TestMicrosoft_RequiresAllowedTenants(t *testing.T) {
+	issuer := newMicrosoftIssuer(t)
+
+	for _, tenant := range []string{"", "common", "organizations"} {
+		_, err := api.NewMicrosoftProvider(context.Background(), api.MicrosoftConfig{
+			Tenant:   tenant,
+			ClientID: "client-id",
+			LoginURL: issuer.server.URL,
+		})
+		if err == nil {
+			t.Fatalf("expected error for tenant %q without allowed tenants", tenant)
+		}
+	}
+}
+
+func TestMicrosoft_Callback(t *testing.T) {
+	issuer := newMicrosoftIssuer(t)
+	storage := newMockStorage()
+
+	w := microsoftCallback(t, issuer, storage)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := storage.UserByEmail("jj@contoso.com"); err != nil {
+		t.Fatalf("expected user to be saved: %v", err)
+	}
+}
+
+func TestMicrosoft_Callback_UnverifiedEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{name: "unverified email", claims: jwt.MapClaims{"xms_edov": false}},
+		{name: "preferred username only", claims: jwt.MapClaims{"email": "", "xms_edov": nil, "preferred_username": "jj@contoso.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newMicrosoftIssuer(t)
+			for k, v := range tt.claims {
+				issuer.claims[k] = v
+			}
+			storage := newMockStorage()
+
+			w := microsoftCallback(t, issuer, storage)
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), "no verified email") {
+				t.Fatalf("expected login error page, got %s", w.Body.String())
+			}
+			if _, err := storage.UserByEmail("jj@contoso.com"); err == nil {
+				t.Fatal("expected user not to be saved")
+			}
+		})
+	}
+}
+
+func TestMicrosoft_Callback_TenantNotAllowed(t *testing.T) {
+	const otherTenant = "22222222-2222-2222-2222-222222222222"
+	issuer := newMicrosoftIssuer(t)
+	issuer.claims["iss"] = issuer.server.URL + "/" + otherTenant + "/v2.0"
+	issuer.claims["tid"] = otherTenant
+	storage := newMockStorage()
+
+	w := microsoftCallback(t, issuer, storage)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := storage.UserByEmail("jj@contoso.com"); err == nil {
+		t.Fatal("expected user not to be saved")
+	}
+}
+
+func TestMicrosoft_Callback_IssuerTenantMismatch(t *testing.T) {
+	issuer := newMicrosoftIssuer(t)
+	// A token of another tenant can't claim to be of an allowed one.
+	issuer.claims["iss"] = issuer.server.URL + "/22222222-2222-2222-2222-222222222222/v2.0"
+
+	w := microsoftCallback(t, issuer, newMockStorage())
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/birdie-ai/legitima"
+)
+
+// Authorization errors
+var (
+	// ErrUnknownUser is returned when a valid token belongs to a user that does not exist.
+	ErrUnknownUser = errors.New("unknown user")
+	// ErrInsufficientScope is returned when a valid token lacks a scope required by the route.
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// UserStorage loads the authenticated users.
+type UserStorage interface {
+	UserByEmail(email string) (*legitima.User, error)
+}
+
+type ctxKey int
+
+const (
+	userCtxKey ctxKey = iota
+	tokenCtxKey
+)
+
+// AuthOption configures the checks done by RequireAuth.
+type AuthOption func(*authOptions)
+
+type authOptions struct {
+	scopes   []string
+	ownLogin bool
+}
+
+// RequireScopes makes RequireAuth reject the tokens that were not granted all the given scopes.
+func RequireScopes(scopes ...string) AuthOption {
+	return func(o *authOptions) {
+		o.scopes = append(o.scopes, scopes...)
+	}
+}
+
+// RequireOwnLogin makes RequireAuth reject the tokens issued to OAuth clients, so only the own login
+// of legitima can use the route. Third party clients share the audience of legitima, scopes alone
+// don't keep them out of the routes that don't check any.
+func RequireOwnLogin() AuthOption {
+	return func(o *authOptions) {
+		o.ownLogin = true
+	}
+}
+
+// RequireAuth returns a middleware that only lets requests with a valid token through,
+// read from the Authorization header or, for browser sessions, from the Authorization cookie.
+// The user of the token and the token itself are stored in the request context,
+// see UserFromContext and TokenFromContext.
+func RequireAuth(verifier Verifier, tokenCfg TokenConfig, storage UserStorage, opts ...AuthOption) func(http.Handler) http.Handler {
+	var options authOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			token, err := tokenFromRequest(r, verifier, tokenCfg)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="legitima"`)
+				sendErr(ctx, w, err, http.StatusUnauthorized)
+				return
+			}
+			if token.IsService() {
+				sendErr(ctx, w, ErrServiceToken, http.StatusForbidden)
+				return
+			}
+			if options.ownLogin && !token.IsOwnLogin() {
+				sendErr(ctx, w, ErrClientToken, http.StatusForbidden)
+				return
+			}
+			for _, scope := range options.scopes {
+				if !token.HasScope(scope) {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="legitima", error="insufficient_scope", scope=%q`, strings.Join(options.scopes, " ")))
+					sendErr(ctx, w, fmt.Errorf("%w: missing %s", ErrInsufficientScope, scope), http.StatusForbidden)
+					return
+				}
+			}
+
+			usr, err := storage.UserByEmail(token.Email)
+			if errors.Is(err, legitima.ErrNotFound) {
+				sendErr(ctx, w, ErrUnknownUser, http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				sendErr(ctx, w, fmt.Errorf("loading user: %w", err), http.StatusInternalServerError)
+				return
+			}
+
+			ctx = context.WithValue(ctx, userCtxKey, usr)
+			ctx = context.WithValue(ctx, tokenCtxKey, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// UserFromContext returns the user stored by RequireAuth.
+func UserFromContext(ctx context.Context) (*legitima.User, bool) {
+	usr, ok := ctx.Value(userCtxKey).(*legitima.User)
+	return usr, ok
+}
+
+// TokenFromContext returns the token stored by RequireAuth.
+func TokenFromContext(ctx context.Context) (*Token, bool) {
+	token, ok := ctx.Value(tokenCtxKey).(*Token)
+	return token, ok
+}
+
+// tokenFromRequest validates the token from the Authorization header, or from the cookie of browser sessions.
+func tokenFromRequest(r *http.Request, verifier Verifier, tokenCfg TokenConfig) (*Token, error) {
+	if r.Header.Get("Authorization") == "" {
+		cookie, err := r.Cookie("Authorization")
+		if err != nil {
+			return nil, errors.New("no authorization header")
+		}
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", cookie.Value)
+	}
+	return TokenFromHeader(r, verifier, tokenCfg)
+}
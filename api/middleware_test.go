@@ -0,0 +1,115 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+func TestRequireAuth(t *testing.T) {
+	storage := newMockStorage()
+	err := storage.SaveUser(legitima.Identity{Provider: "google", Subject: "1", Name: "JJ", Email: "jj@gmail.com"})
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+
+	var gotUser *legitima.User
+	h := api.RequireAuth(key, cfg, storage)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, _ = api.UserFromContext(r.Context())
+		token, ok := api.TokenFromContext(r.Context())
+		if !ok || token.Email != gotUser.Email {
+			t.Errorf("expected token of %s in the context, got %v", gotUser.Email, token)
+		}
+	}))
+
+	token, err := api.GenerateToken(key, cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	unknownToken, err := api.GenerateToken(key, cfg, "unknown@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		cookie   string
+		wantCode int
+	}{
+		{name: "header", header: "Bearer " + token, wantCode: http.StatusOK},
+		{name: "cookie", cookie: "Bearer " + token, wantCode: http.StatusOK},
+		{name: "missing token", wantCode: http.StatusUnauthorized},
+		{name: "invalid token", header: "Bearer garbage", wantCode: http.StatusUnauthorized},
+		{name: "unknown user", header: "Bearer " + unknownToken, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUser = nil
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "Authorization", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				errMessage(t, w)
+				return
+			}
+			if gotUser == nil || gotUser.Name != "JJ" {
+				t.Fatalf("expected user in the context, got %v", gotUser)
+			}
+		})
+	}
+}
+
+func TestRequireAuth_Scopes(t *testing.T) {
+	storage := newMockStorage()
+	err := storage.SaveUser(legitima.Identity{Provider: "google", Subject: "1", Email: "jj@gmail.com"})
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+	h := api.RequireAuth(key, cfg, storage, api.RequireScopes("jobs:read", "jobs:write"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name     string
+		scopes   []string
+		wantCode int
+	}{
+		{name: "all scopes", scopes: []string{"jobs:read", "jobs:write", "openid"}, wantCode: http.StatusOK},
+		{name: "missing scope", scopes: []string{"jobs:read"}, wantCode: http.StatusForbidden},
+		{name: "no scopes", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := api.GenerateToken(key, cfg, "jj@gmail.com", tt.scopes...)
+			if err != nil {
+				t.Fatalf("failed to generate token: %v", err)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, requestWithToken(token))
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode == http.StatusForbidden && !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`) {
+				t.Fatalf("expected insufficient_scope challenge, got %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/birdie-ai/golibs/slog"
+)
+
+// ErrInvalidClient is returned when the client authentication fails.
+var ErrInvalidClient = errors.New("invalid client")
+
+// OAuthError is the error response of the OAuth endpoints, as defined in RFC 6749.
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func sendOAuthErr(ctx context.Context, w http.ResponseWriter, code string, err error, statusCode int) {
+	log := slog.FromCtx(ctx)
+	switch {
+	case statusCode >= 500:
+		log.Error("server side error", "error", err, "status", statusCode)
+	case statusCode >= 400:
+		log.Warn("client side error", "error", err, "status", statusCode)
+	}
+
+	if statusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="legitima"`)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, statusCode, OAuthError{Error: code, ErrorDescription: err.Error()})
+}
+
+// ClientAuthenticator authenticates the clients calling the OAuth endpoints.
+type ClientAuthenticator interface {
+	AuthenticateClient(clientID, clientSecret string) error
+}
+
+// StaticClients authenticates a fixed set of clients, mapping client IDs to their secrets.
+type StaticClients map[string]string
+
+// ParseStaticClients parses a comma separated list of client_id:client_secret pairs.
+func ParseStaticClients(list string) (StaticClients, error) {
+	clients := StaticClients{}
+	for i, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// The entry is not echoed in the error, it holds the secret.
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid client at index %d, expected client_id:client_secret", i)
+		}
+		clients[id] = secret
+	}
+	return clients, nil
+}
+
+// AuthenticateClient checks the secret of the client.
+func (c StaticClients) AuthenticateClient(clientID, clientSecret string) error {
+	secret, ok := c[clientID]
+	if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(clientSecret)) != 1 {
+		return ErrInvalidClient
+	}
+	return nil
+}
+
+// clientCredentials reads the client credentials from the Authorization header (client_secret_basic)
+// or from the form values (client_secret_post).
+func clientCredentials(r *http.Request) (clientID, clientSecret string, ok bool) {
+	clientID, clientSecret, ok = r.BasicAuth()
+	if ok {
+		// The credentials are form encoded before being base64 encoded (RFC 6749 2.3.1).
+		id, idErr := url.QueryUnescape(clientID)
+		secret, secretErr := url.QueryUnescape(clientSecret)
+		if idErr != nil || secretErr != nil {
+			return "", "", false
+		}
+		return id, secret, true
+	}
+
+	clientID = r.PostFormValue("client_id")
+	clientSecret = r.PostFormValue("client_secret")
+	return clientID, clientSecret, clientID != "" && clientSecret != ""
+}
+
+// authenticateClient authenticates the client of the request, returning its ID.
+func authenticateClient(r *http.Request, clients ClientAuthenticator) (string, error) {
+	clientID, clientSecret, ok := clientCredentials(r)
+	if !ok {
+		return "", fmt.Errorf("%w: missing client credentials", ErrInvalidClient)
+	}
+	err := clients.AuthenticateClient(clientID, clientSecret)
+	if err != nil {
+		return "", err
+	}
+	return clientID, nil
+}
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	// jwksCacheTTL is how long the keys of an issuer are cached.
+	jwksCacheTTL = time.Hour
+	// jwksMinRefresh limits how often tokens with unknown kids can refetch the keys of an issuer.
+	jwksMinRefresh = time.Minute
+	// idTokenLeeway is the clock skew tolerated when validating the id_token times.
+	idTokenLeeway = time.Minute
+)
+
+// ErrInvalidIDToken is returned when the id_token of an OpenID Connect provider fails the validation.
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// idTokenMethods are the signing methods accepted for id_tokens.
+// Symmetric methods are not accepted since the client secret is not a signing key.
+var idTokenMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// OIDCDiscovery is the provider metadata published by an OpenID Connect issuer.
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint,omitempty"`
+	JWKSURI               string `json:"jwks_uri"`
+	// DeviceAuthorizationEndpoint is defined by RFC 8628.
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
+	// The remaining metadata is only published by legitima itself.
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	ClaimsSupported                   []string `json:"claims_supported,omitempty"`
+}
+
+// OIDCConfig configures an OpenID Connect provider.
+type OIDCConfig struct {
+	// Name identifies the provider in the login and callback URLs.
+	Name string
+	// Issuer is the issuer URL, whose discovery document is at /.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested along with openid, email and profile.
+	Scopes []string
+}
+
+// DiscoverOIDC fetches the discovery document of the issuer.
+func DiscoverOIDC(ctx context.Context, issuer string) (OIDCDiscovery, error) {
+	discovery, err := fetchDiscovery(ctx, issuer)
+	if err != nil {
+		return OIDCDiscovery{}, err
+	}
+	if discovery.Issuer != issuer {
+		return OIDCDiscovery{}, fmt.Errorf("discovering %s: issuer mismatch %s", issuer, discovery.Issuer)
+	}
+	return discovery, nil
+}
+
+// fetchDiscovery fetches the discovery document at the URL of the issuer, without checking the issuer it names.
+func fetchDiscovery(ctx context.Context, issuer string) (OIDCDiscovery, error) {
+	var discovery OIDCDiscovery
+	err := getJSON(oauth2.NewClient(ctx, nil), strings.TrimSuffix(issuer, "/")+oidcDiscoveryPath, &discovery)
+	if err != nil {
+		return OIDCDiscovery{}, fmt.Errorf("discovering %s: %w", issuer, err)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return OIDCDiscovery{}, fmt.Errorf("discovering %s: missing endpoints", issuer)
+	}
+	return discovery, nil
+}
+
+// OIDCProvider authenticates users with any OpenID Connect issuer,
+// creating the identity from the validated id_token.
+type OIDCProvider struct {
+	oauth2Provider
+	issuer      string
+	userInfoURL string
+	keys        *remoteKeySet
+}
+
+// NewOIDCProvider returns a new OIDCProvider instance configured from the discovery document of the issuer.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	discovery, err := DiscoverOIDC(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return newOIDCProvider(cfg, discovery), nil
+}
+
+func newOIDCProvider(cfg OIDCConfig, discovery OIDCDiscovery) *OIDCProvider {
+	config := &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
+		},
+		Scopes: append([]string{"openid", "email", "profile"}, cfg.Scopes...),
+	}
+	return &OIDCProvider{
+		oauth2Provider: oauth2Provider{name: cfg.Name, config: config},
+		issuer:         discovery.Issuer,
+		userInfoURL:    discovery.UserInfoEndpoint,
+		keys:           newRemoteKeySet(discovery.JWKSURI),
+	}
+}
+
+// AuthCodeURL returns the URL of the provider consent page, sending the PKCE challenge and the nonce.
+func (p *OIDCProvider) AuthCodeURL(state string, login LoginState) string {
+	return p.authCodeURL(state, login)
+}
+
+func (p *OIDCProvider) authCodeURL(state string, login LoginState, extra ...oauth2.AuthCodeOption) string {
+	opts := []oauth2.AuthCodeOption{
+		oauth2.AccessTypeOnline,
+		oauth2.S256ChallengeOption(login.CodeVerifier),
+		oauth2.SetAuthURLParam("nonce", login.Nonce),
+	}
+	return p.config.AuthCodeURL(state, append(opts, extra...)...)
+}
+
+// Identity validates the id_token returned along with the token and the verified email of the user,
+// creating the identity from its claims.
+func (p *OIDCProvider) Identity(ctx context.Context, token *oauth2.Token, login LoginState) (legitima.Identity, error) {
+	claims, err := p.claims(ctx, token, login)
+	if err != nil {
+		return legitima.Identity{}, err
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return legitima.Identity{}, ErrNoVerifiedEmail
+	}
+	return p.identity(claims), nil
+}
+
+// claims returns the validated claims of the id_token returned along with the token.
+// The userinfo endpoint is only used when the id_token has no email.
+func (p *OIDCProvider) claims(ctx context.Context, token *oauth2.Token, login LoginState) (*IDTokenClaims, error) {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, fmt.Errorf("%w: missing id_token", ErrInvalidIDToken)
+	}
+	claims, err := p.VerifyIDToken(rawIDToken, login.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Email == "" && p.userInfoURL != "" {
+		var info IDTokenClaims
+		err = getJSON(p.config.Client(ctx, token), p.userInfoURL, &info)
+		if err != nil {
+			return nil, fmt.Errorf("getting %s user info: %w", p.name, err)
+		}
+		if info.Subject != claims.Subject {
+			return nil, fmt.Errorf("%w: userinfo subject mismatch", ErrInvalidIDToken)
+		}
+		claims.Email, claims.EmailVerified = info.Email, info.EmailVerified
+		if claims.Name == "" {
+			claims.Name = info.Name
+		}
+		if claims.Picture == "" {
+			claims.Picture = info.Picture
+		}
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) identity(claims *IDTokenClaims) legitima.Identity {
+	return legitima.Identity{
+		Provider:      p.name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Picture:       claims.Picture,
+	}
+}
+
+// VerifyIDToken validates the signature, issuer, audience, expiration and nonce of an id_token.
+func (p *OIDCProvider) VerifyIDToken(rawIDToken, nonce string) (*IDTokenClaims, error) {
+	var claims IDTokenClaims
+	parser := &jwt.Parser{ValidMethods: idTokenMethods, SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(rawIDToken, &claims, p.keys.VerificationKey)
+	if err != nil {
+		var vErr *jwt.ValidationError
+		if errors.As(err, &vErr) && vErr.Inner != nil {
+			err = vErr.Inner
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// Multi-tenant issuers name the tenant of each token in their issuer.
+	issuer := strings.ReplaceAll(p.issuer, "{tenantid}", claims.TenantID)
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != issuer:
+		return nil, fmt.Errorf("%w: issuer %s", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, fmt.Errorf("%w: audience", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fmt.Errorf("%w: authorized party", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, ErrTokenExpired)
+	case now.Add(idTokenLeeway).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, ErrTokenNotYetValid)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce", ErrInvalidIDToken)
+	}
+	return &claims, nil
+}
+
+// IDTokenClaims are the claims of an id_token, also returned by the userinfo endpoint.
+type IDTokenClaims struct {
+	Issuer          string   `json:"iss,omitempty"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud,omitempty"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	ExpiresAt       int64    `json:"exp,omitempty"`
+	IssuedAt        int64    `json:"iat,omitempty"`
+	Nonce           string   `json:"nonce,omitempty"`
+	Email           string   `json:"email,omitempty"`
+	EmailVerified   bool     `json:"email_verified,omitempty"`
+	Name            string   `json:"name,omitempty"`
+	Picture         string   `json:"picture,omitempty"`
+	// EmailDomainVerified is the xms_edov optional claim of Microsoft, telling the tenant owns the domain of the email.
+	EmailDomainVerified bool `json:"xms_edov,omitempty"`
+	// HostedDomain is the Google Workspace domain of the user.
+	HostedDomain string `json:"hd,omitempty"`
+	// TenantID is the Microsoft tenant of the user.
+	TenantID string `json:"tid,omitempty"`
+}
+
+// Valid implements jwt.Claims, the claims are validated by VerifyIDToken.
+func (c IDTokenClaims) Valid() error {
+	return nil
+}
+
+// audience is the aud claim, either a single string or an array of strings.
+type audience []string
+
+// MarshalJSON encodes a single audience as a string, as most tokens do.
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	err := json.Unmarshal(b, &many)
+	if err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteKeySet caches the keys published at the JWKS URL of an issuer.
+type remoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	set       *KeySet
+	fetchedAt time.Time
+}
+
+func newRemoteKeySet(url string) *remoteKeySet {
+	return &remoteKeySet{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// VerificationKey returns the public key matching the kid header of the token.
+// Unknown kids refetch the keys, since the issuer may have rotated them.
+func (s *remoteKeySet) VerificationKey(token *jwt.Token) (interface{}, error) {
+	set, err := s.keySet(false)
+	if err != nil {
+		return nil, err
+	}
+	key, err := set.VerificationKey(token)
+	if !errors.Is(err, ErrUnknownKey) {
+		return key, err
+	}
+	set, err = s.keySet(true)
+	if err != nil {
+		return nil, err
+	}
+	return set.VerificationKey(token)
+}
+
+// keySet returns the cached keys, fetching them when expired or when refresh is requested.
+func (s *remoteKeySet) keySet(refresh bool) (*KeySet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	age := time.Since(s.fetchedAt)
+	if s.set != nil && age < jwksCacheTTL && (!refresh || age < jwksMinRefresh) {
+		return s.set, nil
+	}
+
+	var jwks JWKS
+	err := getJSON(s.client, s.url, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", s.url, err)
+	}
+	var keys []PublicKey
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			// Keys of unsupported types can't verify any accepted token.
+			continue
+		}
+		keys = append(keys, key)
+	}
+	s.set = NewKeySet(keys...)
+	s.fetchedAt = time.Now()
+	return s.set, nil
+}
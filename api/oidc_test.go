@@ -0,0 +1,320 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+	"github.com/golang-jwt/jwt"
+)
+
+// testIssuer is a stand-in for an OpenID Connect issuer.
+type testIssuer struct {
+	server *httptest.Server
+	key    *api.PrivateKey
+	// issuer is the issuer published by the discovery documents, the server URL by default.
+	issuer string
+	// claims are merged into the claims of the issued id_tokens.
+	claims jwt.MapClaims
+	// codeVerifier is the PKCE verifier received by the token endpoint.
+	codeVerifier string
+	jwksFetches  int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	issuer := &testIssuer{key: generateKey(t, "ES256"), claims: jwt.MapClaims{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/.well-known/openid-configuration") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		published := issuer.issuer
+		if published == "" {
+			published = issuer.server.URL
+		}
+		_ = json.NewEncoder(w).Encode(api.OIDCDiscovery{
+			Issuer:                published,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.jwksFetches++
+		_ = json.NewEncoder(w).Encode(issuer.key.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		issuer.codeVerifier = r.FormValue("code_verifier")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     issuer.idToken(t),
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// idToken returns an id_token for the nonce "nonce", with the claims of the issuer merged in.
+func (i *testIssuer) idToken(t *testing.T) string {
+	t.Helper()
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            i.server.URL,
+		"sub":            "42",
+		"aud":            "client-id",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          "nonce",
+		"email":          "jj@example.com",
+		"email_verified": true,
+		"name":           "JJ",
+	}
+	for k, v := range i.claims {
+		claims[k] = v
+	}
+	token, err := i.key.Sign(claims)
+	if err != nil {
+		t.Fatalf("failed to sign id token: %v", err)
+	}
+	return token
+}
+
+func generateKey(t *testing.T, alg string) *api.PrivateKey {
+	t.Helper()
+	key, err := api.GenerateKey(alg)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+func (i *testIssuer) provider(t *testing.T, name string) *api.OIDCProvider {
+	t.Helper()
+	provider, err := api.NewOIDCProvider(context.Background(), api.OIDCConfig{
+		Name:         name,
+		Issuer:       i.server.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "http://localhost:8080/callback/" + name,
+	})
+	if err != nil {
+		t.Fatalf("failed to create oidc provider: %v", err)
+	}
+	return provider
+}
+
+// oidcCallback runs the callback of a login attempt whose nonce is "nonce".
+func oidcCallback(t *testing.T, issuer *testIssuer, storage api.Storage) *httptest.ResponseRecorder {
+	t.Helper()
+	providers, err := api.NewProviders(issuer.provider(t, "oidc"))
+	if err != nil {
+		t.Fatalf("failed to create providers: %v", err)
+	}
+	states := api.NewMemoryStateStore()
+	err = states.Save("state", api.LoginState{Provider: "oidc", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/callback/oidc?state=state&code=code", nil)
+	req.AddCookie(&http.Cookie{Name: "oauth_state", Value: "state"})
+	w := httptest.NewRecorder()
+	api.CallbackHandler(providers, states, api.NewMemoryLoginCodeStore(), newKey(t), api.DefaultTokenConfig(), storage).ServeHTTP(w, req)
+	return w
+}
+
+func TestOIDC_Login(t *testing.T) {
+	issuer := newTestIssuer(t)
+	providers, err := api.NewProviders(issuer.provider(t, "oidc"))
+	if err != nil {
+		t.Fatalf("failed to create providers: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	api.LoginHandler(providers, api.NewMemoryStateStore(), nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login/oidc", nil))
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse location: %v", err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != issuer.server.URL+"/authorize" {
+		t.Fatalf("expected the discovered authorization endpoint, got %s", got)
+	}
+	query := location.Query()
+	if scope := query.Get("scope"); scope != "openid email profile" {
+		t.Fatalf("expected openid scopes, got %q", scope)
+	}
+	if query.Get("nonce") == "" {
+		t.Fatal("expected nonce in the redirect url")
+	}
+}
+
+func TestOIDC_Callback(t *testing.T) {
+	issuer := newTestIssuer(t)
+	storage := newMockStorage()
+
+	w := oidcCallback(t, issuer, storage)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d: %s", w.Code, w.Body.String())
+	}
+	usr, err := storage.UserByEmail("jj@example.com")
+	if err != nil {
+		t.Fatalf("expected user to be saved: %v", err)
+	}
+	if usr.ID != "42" || usr.Name != "JJ" {
+		t.Fatalf("unexpected user: %+v", usr)
+	}
+	if issuer.codeVerifier != "verifier" {
+		t.Fatalf("expected code verifier on the token exchange, got %q", issuer.codeVerifier)
+	}
+}
+
+func TestOIDC_Callback_Rejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		existing *legitima.Identity
+		message  string
+	}{
+		{name: "unverified email", claims: jwt.MapClaims{"email_verified": false}, message: "no verified email"},
+		{name: "missing email", claims: jwt.MapClaims{"email": ""}, message: "no verified email"},
+		{
+			name:     "email linked to another provider",
+			existing: &legitima.Identity{Provider: "github", Subject: "7", Email: "jj@example.com"},
+			message:  "linked to another account",
+		},
+		{
+			name:     "email linked to another subject",
+			existing: &legitima.Identity{Provider: "oidc", Subject: "7", Email: "jj@example.com"},
+			message:  "linked to another account",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newTestIssuer(t)
+			issuer.claims = tt.claims
+			storage := newMockStorage()
+			if tt.existing != nil {
+				err := storage.SaveUser(*tt.existing)
+				if err != nil {
+					t.Fatalf("failed to save user: %v", err)
+				}
+			}
+
+			w := oidcCallback(t, issuer, storage)
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.message) {
+				t.Fatalf("expected login error page with %q, got %s", tt.message, w.Body.String())
+			}
+			for _, c := range w.Result().Cookies() {
+				if c.Name == "Authorization" {
+					t.Fatal("expected no tokens to be issued")
+				}
+			}
+		})
+	}
+}
+
+func TestOIDC_Callback_InvalidIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{name: "wrong issuer", claims: jwt.MapClaims{"iss": "https://evil.example.com"}},
+		{name: "wrong audience", claims: jwt.MapClaims{"aud": "other-client"}},
+		{name: "audience list without azp", claims: jwt.MapClaims{"aud": []string{"client-id", "other-client"}}},
+		{name: "expired", claims: jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{name: "issued in the future", claims: jwt.MapClaims{"iat": time.Now().Add(time.Hour).Unix()}},
+		{name: "wrong nonce", claims: jwt.MapClaims{"nonce": "replayed"}},
+		{name: "missing nonce", claims: jwt.MapClaims{"nonce": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newTestIssuer(t)
+			issuer.claims = tt.claims
+			storage := newMockStorage()
+
+			w := oidcCallback(t, issuer, storage)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("expected 401, got %d: %s", w.Code, w.Body.String())
+			}
+			if msg := errMessage(t, w); msg != api.ErrInvalidIDToken.Error() {
+				t.Fatalf("expected %q, got %q", api.ErrInvalidIDToken, msg)
+			}
+			if _, err := storage.UserByEmail("jj@example.com"); err == nil {
+				t.Fatal("expected user not to be saved")
+			}
+		})
+	}
+}
+
+func TestOIDC_Callback_AudienceList(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.claims = jwt.MapClaims{"aud": []string{"other-client", "client-id"}, "azp": "client-id"}
+
+	w := oidcCallback(t, issuer, newMockStorage())
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDC_UnknownKey(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t, "oidc")
+	// Caches the keys of the issuer.
+	if _, err := provider.VerifyIDToken(issuer.idToken(t), "nonce"); err != nil {
+		t.Fatalf("failed to verify id token: %v", err)
+	}
+
+	// Unknown kids can't refetch the keys right after they were fetched.
+	issuer.key = generateKey(t, "EdDSA")
+	if _, err := provider.VerifyIDToken(issuer.idToken(t), "nonce"); err == nil {
+		t.Fatal("expected unknown key to fail")
+	}
+	if issuer.jwksFetches != 1 {
+		t.Fatalf("expected keys to be fetched once, got %d", issuer.jwksFetches)
+	}
+
+	forged := generateKey(t, "ES256")
+	token, err := forged.Sign(jwt.MapClaims{"iss": issuer.server.URL, "sub": "42", "aud": "client-id", "nonce": "nonce"})
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	if _, err := issuer.provider(t, "other").VerifyIDToken(token, "nonce"); err == nil {
+		t.Fatal("expected token signed by an unknown key to fail")
+	}
+}
+
+func TestOIDC_SymmetricSignature(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t, "oidc")
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": issuer.server.URL, "sub": "42", "aud": "client-id", "nonce": "nonce",
+	}).SignedString([]byte("client-secret"))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	if _, err := provider.VerifyIDToken(token, "nonce"); err == nil {
+		t.Fatal("expected symmetric signature to be rejected")
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+)
+
+const userInfoURL = "/userinfo"
+
+// ErrInvalidOpenIDConfig is returned when legitima can't act as an OpenID Connect provider.
+var ErrInvalidOpenIDConfig = errors.New("invalid openid config")
+
+// SetupOpenID sets up the OpenID Connect discovery and userinfo endpoints.
+// The baseURL is the public URL of legitima, used to build the published endpoints.
+// It fails unless the clients can verify the id_tokens, see ValidateOpenID.
+func SetupOpenID(mux *http.ServeMux, baseURL string, key Key, tokenCfg TokenConfig, users UserStorage) error {
+	err := ValidateOpenID(key, tokenCfg)
+	if err != nil {
+		return err
+	}
+	mux.Handle(oidcDiscoveryPath, OpenIDConfigurationHandler(baseURL, key, tokenCfg))
+	mux.Handle(userInfoURL, UserInfoHandler(key, tokenCfg, users))
+	return nil
+}
+
+// ValidateOpenID tells if the clients can verify the id_tokens: they must be signed with a published,
+// asymmetric, key and the issuer must be an https URL, whose discovery document they can fetch.
+func ValidateOpenID(publisher KeyPublisher, tokenCfg TokenConfig) error {
+	if len(publisher.JWKS().Keys) == 0 {
+		return fmt.Errorf("%w: id tokens can't be signed with an HMAC secret", ErrInvalidOpenIDConfig)
+	}
+	issuer, err := url.Parse(tokenCfg.Issuer)
+	if err != nil || issuer.Scheme != "https" || issuer.Host == "" {
+		return fmt.Errorf("%w: issuer %q is not an https url", ErrInvalidOpenIDConfig, tokenCfg.Issuer)
+	}
+	return nil
+}
+
+// OpenIDConfigurationHandler handles the OpenID Connect discovery endpoint.
+// The issuer is the one of the tokens, so it must be set to the public URL of legitima.
+func OpenIDConfigurationHandler(baseURL string, publisher KeyPublisher, tokenCfg TokenConfig) http.Handler {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Cache-Control", "public, max-age=300")
+			sendJSON(r.Context(), w, http.StatusOK, openIDConfiguration(baseURL, publisher, tokenCfg))
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func openIDConfiguration(baseURL string, publisher KeyPublisher, tokenCfg TokenConfig) OIDCDiscovery {
+	var algs []string
+	for _, key := range publisher.JWKS().Keys {
+		if !contains(algs, key.Alg) {
+			algs = append(algs, key.Alg)
+		}
+	}
+	return OIDCDiscovery{
+		Issuer:                            tokenCfg.Issuer,
+		AuthorizationEndpoint:             baseURL + authorizeURL,
+		TokenEndpoint:                     baseURL + tokenURL,
+		UserInfoEndpoint:                  baseURL + userInfoURL,
+		JWKSURI:                           baseURL + jwksURL,
+		DeviceAuthorizationEndpoint:       baseURL + deviceAuthorizationURL,
+		ScopesSupported:                   []string{"openid", "email", "profile"},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               supportedGrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algs,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "email", "email_verified", "name", "picture"},
+	}
+}
+
+// UserInfoHandler handles the userinfo endpoint, returning the claims of the user of the access token.
+func UserInfoHandler(verifier Verifier, tokenCfg TokenConfig, users UserStorage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodPost:
+			userInfo(w, r, verifier, tokenCfg, users)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func userInfo(w http.ResponseWriter, r *http.Request, verifier Verifier, tokenCfg TokenConfig, users UserStorage) {
+	ctx := r.Context()
+
+	token, err := TokenFromHeader(r, verifier, tokenCfg)
+	if err == nil && token.IsService() {
+		err = ErrServiceToken
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		sendErr(ctx, w, err, http.StatusUnauthorized)
+		return
+	}
+	user, err := users.UserByEmail(token.Email)
+	if errors.Is(err, legitima.ErrNotFound) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		sendErr(ctx, w, err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, userClaims(user))
+}
+
+// userClaims returns the standard claims of the user.
+// The subject is the ID of the user, which does not change with the email.
+func userClaims(user *legitima.User) IDTokenClaims {
+	return IDTokenClaims{
+		Subject:       user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Name:          user.Name,
+		Picture:       user.Picture,
+	}
+}
+
+// generateIDToken generates an id_token of the user with the given email for the client.
+func generateIDToken(signer Signer, tokenCfg TokenConfig, clientID, nonce string, users UserStorage, email string) (string, error) {
+	user, err := users.UserByEmail(email)
+	if err != nil {
+		return "", fmt.Errorf("loading id token user: %w", err)
+	}
+	now := time.Now()
+	claims := userClaims(user)
+	claims.Issuer = tokenCfg.Issuer
+	claims.Audience = audience{clientID}
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(tokenCfg.TTL).Unix()
+	claims.Nonce = nonce
+	return signer.Sign(claims)
+}
+
+// hasScope tells if the space separated scope includes the given one.
+func hasScope(scope, s string) bool {
+	return contains(strings.Fields(scope), s)
+}
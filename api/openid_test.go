@@ -0,0 +1,218 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+	"github.com/golang-jwt/jwt"
+)
+
+func TestOpenIDConfiguration(t *testing.T) {
+	key := generateKey(t, "ES256")
+	tokenCfg := api.DefaultTokenConfig()
+	tokenCfg.Issuer = "https://legitima.example.com"
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil)
+	w := httptest.NewRecorder()
+	api.OpenIDConfigurationHandler("https://legitima.example.com/", key, tokenCfg).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var discovery api.OIDCDiscovery
+	err := json.NewDecoder(w.Body).Decode(&discovery)
+	if err != nil {
+		t.Fatalf("failed to decode discovery: %v", err)
+	}
+	want := api.OIDCDiscovery{
+		Issuer:                "https://legitima.example.com",
+		AuthorizationEndpoint: "https://legitima.example.com/oauth/authorize",
+		TokenEndpoint:         "https://legitima.example.com/oauth/token",
+		UserInfoEndpoint:      "https://legitima.example.com/userinfo",
+		JWKSURI:               "https://legitima.example.com/.well-known/jwks.json",
+	}
+	if discovery.Issuer != want.Issuer || discovery.AuthorizationEndpoint != want.AuthorizationEndpoint ||
+		discovery.TokenEndpoint != want.TokenEndpoint || discovery.UserInfoEndpoint != want.UserInfoEndpoint ||
+		discovery.JWKSURI != want.JWKSURI {
+		t.Fatalf("expected %+v, got %+v", want, discovery)
+	}
+	if !strings.Contains(strings.Join(discovery.GrantTypesSupported, " "), "refresh_token") {
+		t.Fatalf("expected the refresh_token grant, got %v", discovery.GrantTypesSupported)
+	}
+	if len(discovery.IDTokenSigningAlgValuesSupported) != 1 || discovery.IDTokenSigningAlgValuesSupported[0] != "ES256" {
+		t.Fatalf("expected ES256 signing, got %v", discovery.IDTokenSigningAlgValuesSupported)
+	}
+}
+
+func TestSetupOpenID(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    api.Key
+		issuer string
+		valid  bool
+	}{
+		{name: "asymmetric key", key: generateKey(t, "ES256"), issuer: "https://legitima.example.com", valid: true},
+		{name: "hmac key", key: newKey(t), issuer: "https://legitima.example.com"},
+		{name: "issuer not an url", key: generateKey(t, "ES256"), issuer: "legitima"},
+		{name: "http issuer", key: generateKey(t, "ES256"), issuer: "http://legitima.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenCfg := api.DefaultTokenConfig()
+			tokenCfg.Issuer = tt.issuer
+			mux := http.NewServeMux()
+			err := api.SetupOpenID(mux, "https://legitima.example.com", tt.key, tokenCfg, newMockStorage())
+			if tt.valid != (err == nil) {
+				t.Fatalf("expected valid %v, got %v", tt.valid, err)
+			}
+			if err != nil && !errors.Is(err, api.ErrInvalidOpenIDConfig) {
+				t.Fatalf("expected an invalid openid config, got %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+			if tt.valid != (w.Code == http.StatusOK) {
+				t.Fatalf("expected the discovery registered %v, got %d", tt.valid, w.Code)
+			}
+		})
+	}
+}
+
+func newOpenIDServer(t *testing.T) *authorizeServer {
+	t.Helper()
+	s := newAuthorizeServer(t)
+	s.key = generateKey(t, "ES256")
+	var err error
+	s.accessToken, err = api.GenerateToken(s.key, api.DefaultTokenConfig(), "jj@example.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	err = s.storage.SaveUser(legitima.Identity{
+		Subject:       "user-id",
+		Email:         "jj@example.com",
+		EmailVerified: true,
+		Name:          "JJ",
+		Picture:       "https://example.com/jj.png",
+	})
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	return s
+}
+
+func TestOpenID_IDToken(t *testing.T) {
+	s := newOpenIDServer(t)
+	query := spaQuery("verifier")
+	query.Set("scope", "openid email profile")
+	query.Set("nonce", "n-0S6_WzA2Mj")
+	code := s.approve(t, query)
+
+	w := s.token(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {spaRedirectURI},
+		"client_id":     {"spa"},
+		"code_verifier": {"verifier"},
+	}, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+
+	var claims api.IDTokenClaims
+	_, err = jwt.ParseWithClaims(res.IDToken, &claims, s.key.VerificationKey)
+	if err != nil {
+		t.Fatalf("failed to verify id token: %v", err)
+	}
+	want := api.IDTokenClaims{
+		Issuer:        "legitima",
+		Subject:       "user-id",
+		Audience:      claims.Audience,
+		ExpiresAt:     claims.ExpiresAt,
+		IssuedAt:      claims.IssuedAt,
+		Nonce:         "n-0S6_WzA2Mj",
+		Email:         "jj@example.com",
+		EmailVerified: true,
+		Name:          "JJ",
+		Picture:       "https://example.com/jj.png",
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "spa" {
+		t.Fatalf("expected audience spa, got %v", claims.Audience)
+	}
+	if claims.ExpiresAt <= claims.IssuedAt {
+		t.Fatalf("expected exp after iat, got %+v", claims)
+	}
+	if !reflect.DeepEqual(claims, want) {
+		t.Fatalf("expected %+v, got %+v", want, claims)
+	}
+}
+
+func TestOpenID_NoIDTokenWithoutScope(t *testing.T) {
+	s := newOpenIDServer(t)
+	code := s.approve(t, spaQuery("verifier"))
+
+	w := s.token(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {spaRedirectURI},
+		"client_id":     {"spa"},
+		"code_verifier": {"verifier"},
+	}, "", "")
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	if res.AccessToken == "" || res.IDToken != "" {
+		t.Fatalf("expected access token without id token, got %+v", res)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	s := newOpenIDServer(t)
+	handler := api.UserInfoHandler(s.key, api.DefaultTokenConfig(), s.storage)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, requestWithToken(s.accessToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var claims map[string]interface{}
+	err := json.NewDecoder(w.Body).Decode(&claims)
+	if err != nil {
+		t.Fatalf("failed to decode userinfo: %v", err)
+	}
+	want := map[string]interface{}{
+		"sub":            "user-id",
+		"email":          "jj@example.com",
+		"email_verified": true,
+		"name":           "JJ",
+		"picture":        "https://example.com/jj.png",
+	}
+	if len(claims) != len(want) {
+		t.Fatalf("expected %v, got %v", want, claims)
+	}
+	for k, v := range want {
+		if claims[k] != v {
+			t.Fatalf("expected %s %v, got %v", k, v, claims[k])
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/userinfo", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with WWW-Authenticate, got %d", w.Code)
+	}
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+	"github.com/google/uuid"
+)
+
+const orgsURL = "/orgs"
+
+// ErrInvalidOrganization is returned when creating an organization from an invalid configuration.
+var ErrInvalidOrganization = errors.New("invalid organization")
+
+// OrganizationStorage persists the organizations and their members.
+// The organizations are only read through the users that are members of them.
+type OrganizationStorage interface {
+	SaveOrganization(org legitima.Organization) error
+	SaveMembership(membership legitima.Membership) error
+	UserOrganization(email, orgID string) (*legitima.Organization, error)
+	UserOrganizations(email string) ([]legitima.Organization, error)
+	Memberships(orgID string) ([]legitima.Membership, error)
+}
+
+// OrganizationConfig is the configuration of a new organization.
+type OrganizationConfig struct {
+	Name string `json:"name"`
+	// Domain makes the users with emails of the domain join the organization. Only admins can set it.
+	Domain string `json:"domain,omitempty"`
+}
+
+// SetupOrganizations sets up the endpoints of the organizations of the authenticated user.
+func SetupOrganizations(mux *http.ServeMux, key Key, tokenCfg TokenConfig, storage Storage) {
+	handler := OrganizationsHandler(key, tokenCfg, storage)
+	mux.Handle(orgsURL, handler)
+	mux.Handle(orgsURL+"/", handler)
+}
+
+// OrganizationsHandler handles the endpoints of the organizations of the authenticated user:
+//
+//	GET  /orgs              lists the organizations of the user
+//	POST /orgs              creates an organization from a JSON OrganizationConfig, joined by the user
+//	GET  /orgs/{id}         returns an organization of the user
+//	GET  /orgs/{id}/members lists the members of an organization of the user
+//	POST /orgs/{id}/switch  issues new tokens of the user acting in one of their organizations
+func OrganizationsHandler(key Key, tokenCfg TokenConfig, storage Storage) http.Handler {
+	return RequireAuth(key, tokenCfg, storage, RequireOwnLogin())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, orgsURL), "/")
+		id, action, _ := strings.Cut(path, "/")
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			listOrganizations(w, r, storage)
+		case id == "" && r.Method == http.MethodPost:
+			createOrganization(w, r, storage)
+		case id != "" && action == "" && r.Method == http.MethodGet:
+			getOrganization(w, r, id, storage)
+		case id != "" && action == "members" && r.Method == http.MethodGet:
+			listMembers(w, r, id, storage)
+		case id != "" && action == "switch" && r.Method == http.MethodPost:
+			switchOrganization(w, r, id, key, tokenCfg, storage)
+		case id != "" && action != "" && action != "members" && action != "switch":
+			sendErr(ctx, w, errors.New("not found"), http.StatusNotFound)
+		default:
+			sendErr(ctx, w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func listOrganizations(w http.ResponseWriter, r *http.Request, storage OrganizationStorage) {
+	ctx := r.Context()
+	usr, _ := UserFromContext(ctx)
+
+	orgs, err := storage.UserOrganizations(usr.Email)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	if orgs == nil {
+		orgs = []legitima.Organization{}
+	}
+	sendJSON(ctx, w, http.StatusOK, orgs)
+}
+
+func createOrganization(w http.ResponseWriter, r *http.Request, storage OrganizationStorage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+	usr, _ := UserFromContext(ctx)
+
+	var cfg OrganizationConfig
+	err := json.NewDecoder(r.Body).Decode(&cfg)
+	if err != nil {
+		sendErr(ctx, w, fmt.Errorf("%w: %v", ErrInvalidOrganization, err), http.StatusBadRequest)
+		return
+	}
+	org := legitima.Organization{
+		ID:        uuid.New().String(),
+		Name:      strings.TrimSpace(cfg.Name),
+		Domain:    strings.ToLower(strings.TrimSpace(cfg.Domain)),
+		CreatedAt: time.Now(),
+	}
+	if org.Name == "" {
+		sendErr(ctx, w, fmt.Errorf("%w: missing name", ErrInvalidOrganization), http.StatusBadRequest)
+		return
+	}
+	// Anybody could claim the domain of a public email provider, joining all of its users.
+	if org.Domain != "" && !usr.HasRole(legitima.RoleAdmin) {
+		sendErr(ctx, w, fmt.Errorf("%w: only admins can set the domain", ErrForbidden), http.StatusForbidden)
+		return
+	}
+
+	err = storage.SaveOrganization(org)
+	if errors.Is(err, legitima.ErrAlreadyExists) {
+		sendErr(ctx, w, fmt.Errorf("domain %s taken: %w", org.Domain, err), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	err = storage.SaveMembership(legitima.Membership{OrgID: org.ID, Email: usr.Email, CreatedAt: org.CreatedAt})
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("organization created", "org_id", org.ID, "email", usr.Email)
+	sendJSON(ctx, w, http.StatusCreated, org)
+}
+
+// userOrganization returns the organization with the given ID if the user of the request is a member,
+// sending the error response otherwise.
+func userOrganization(w http.ResponseWriter, r *http.Request, id string, storage OrganizationStorage) (*legitima.Organization, bool) {
+	ctx := r.Context()
+	usr, _ := UserFromContext(ctx)
+
+	org, err := storage.UserOrganization(usr.Email, id)
+	if errors.Is(err, legitima.ErrNotFound) {
+		sendErr(ctx, w, err, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return org, true
+}
+
+func getOrganization(w http.ResponseWriter, r *http.Request, id string, storage OrganizationStorage) {
+	org, ok := userOrganization(w, r, id, storage)
+	if !ok {
+		return
+	}
+	sendJSON(r.Context(), w, http.StatusOK, org)
+}
+
+func listMembers(w http.ResponseWriter, r *http.Request, id string, storage OrganizationStorage) {
+	ctx := r.Context()
+	org, ok := userOrganization(w, r, id, storage)
+	if !ok {
+		return
+	}
+
+	members, err := storage.Memberships(org.ID)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	sendJSON(ctx, w, http.StatusOK, members)
+}
+
+// switchOrganization issues tokens acting in the organization, keeping the scope of the token.
+// The session of the token is rotated, so its previous refresh tokens can't be used anymore,
+// and tokens without session only get an access token. Browser sessions get the tokens in their cookies too.
+func switchOrganization(w http.ResponseWriter, r *http.Request, id string, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+	usr, _ := UserFromContext(ctx)
+	token, _ := TokenFromContext(ctx)
+
+	if token.Claims.Act != nil {
+		sendErr(ctx, w, fmt.Errorf("%w: delegated tokens can't switch organization", ErrForbidden), http.StatusForbidden)
+		return
+	}
+	org, ok := userOrganization(w, r, id, storage)
+	if !ok {
+		return
+	}
+
+	res, refreshToken, err := issueTokens(signer, tokenCfg, storage, legitima.RefreshToken{
+		FamilyID: token.Claims.SessionID,
+		Email:    usr.Email,
+		Scope:    token.Claims.Scope,
+		OrgID:    org.ID,
+	})
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	if token.Claims.SessionID == "" {
+		res.RefreshToken = ""
+	} else {
+		err = storage.RotateRefreshTokenFamily(token.Claims.SessionID, refreshToken)
+		if errors.Is(err, legitima.ErrNotFound) {
+			sendErr(ctx, w, fmt.Errorf("%w: the session has ended", ErrInvalidRefreshToken), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			sendErr(ctx, w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Info("organization switched", "org_id", org.ID, "email", usr.Email)
+	if r.Header.Get("Authorization") == "" {
+		setTokenCookies(w, tokenCfg, res)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, res)
+}
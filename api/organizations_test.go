@@ -0,0 +1,325 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+type orgsServer struct {
+	*usersServer
+}
+
+func newOrgsServer(t *testing.T) *orgsServer {
+	t.Helper()
+	s := newUsersServer(t)
+	s.handler = api.OrganizationsHandler(s.key, api.DefaultTokenConfig(), s.storage)
+	return &orgsServer{s}
+}
+
+func (s *orgsServer) create(t *testing.T, email, cfg string) legitima.Organization {
+	t.Helper()
+	var org legitima.Organization
+	s.post(t, "/orgs", email, cfg, &org)
+	return org
+}
+
+func TestOrganizations(t *testing.T) {
+	s := newOrgsServer(t)
+	org := s.create(t, "jj@gmail.com", `{"name": "Acme"}`)
+	if org.ID == "" || org.Name != "Acme" || org.Domain != "" {
+		t.Fatalf("unexpected organization: %+v", org)
+	}
+
+	w := s.do(http.MethodGet, "/orgs", "jj@gmail.com", nil)
+	var orgs []legitima.Organization
+	err := json.NewDecoder(w.Body).Decode(&orgs)
+	if err != nil {
+		t.Fatalf("failed to decode organizations: %v", err)
+	}
+	if len(orgs) != 1 || orgs[0].ID != org.ID {
+		t.Fatalf("expected the created organization, got %+v", orgs)
+	}
+	if w := s.do(http.MethodGet, "/orgs", "other@gmail.com", nil); strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("expected no organizations of other users, got %s", w.Body.String())
+	}
+
+	if w := s.do(http.MethodGet, "/orgs/"+org.ID, "jj@gmail.com", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	for _, path := range []string{"/orgs/" + org.ID, "/orgs/" + org.ID + "/members"} {
+		if w := s.do(http.MethodGet, path, "other@gmail.com", nil); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for %s of a non member, got %d", path, w.Code)
+		}
+	}
+
+	w = s.do(http.MethodGet, "/orgs/"+org.ID+"/members", "jj@gmail.com", nil)
+	var members []legitima.Membership
+	err = json.NewDecoder(w.Body).Decode(&members)
+	if err != nil {
+		t.Fatalf("failed to decode members: %v", err)
+	}
+	if len(members) != 1 || members[0].Email != "jj@gmail.com" {
+		t.Fatalf("expected the creator as member, got %+v", members)
+	}
+}
+
+// login starts a session of jj@gmail.com, used for its requests, and returns its tokens.
+func (s *orgsServer) login(t *testing.T) api.TokenResponse {
+	t.Helper()
+	seedRefreshToken(t, s.storage, "first", time.Now().Add(time.Hour))
+	w := httptest.NewRecorder()
+	api.RefreshHandler(s.key, api.DefaultTokenConfig(), s.storage).ServeHTTP(w, refreshRequest("first"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode refresh response: %v", err)
+	}
+	s.tokens["jj@gmail.com"] = res.AccessToken
+	return res
+}
+
+func TestOrganizations_Switch(t *testing.T) {
+	s := newOrgsServer(t)
+	org := s.create(t, "jj@gmail.com", `{"name": "Acme"}`)
+	session := s.login(t)
+
+	if w := s.do(http.MethodPost, "/orgs/"+org.ID+"/switch", "other@gmail.com", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 switching to the organization of other users, got %d", w.Code)
+	}
+
+	w := s.do(http.MethodPost, "/orgs/"+org.ID+"/switch", "jj@gmail.com", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), s.key, api.DefaultTokenConfig())
+	if err != nil {
+		t.Fatalf("failed to verify access token: %v", err)
+	}
+	if token.OrgID != org.ID || token.Email != "jj@gmail.com" {
+		t.Fatalf("expected a token of jj@gmail.com in %s, got %+v", org.ID, token)
+	}
+
+	// The refreshed tokens stay in the organization.
+	rec := httptest.NewRecorder()
+	api.RefreshHandler(s.key, api.DefaultTokenConfig(), s.storage).ServeHTTP(rec, refreshRequest(res.RefreshToken))
+	err = json.NewDecoder(rec.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode refresh response: %v", err)
+	}
+	token, err = api.TokenFromHeader(requestWithToken(res.AccessToken), s.key, api.DefaultTokenConfig())
+	if err != nil {
+		t.Fatalf("failed to verify refreshed token: %v", err)
+	}
+	if token.OrgID != org.ID || token.Claims.SessionID != "family" {
+		t.Fatalf("expected the refreshed token of the session in %s, got %+v", org.ID, token.Claims)
+	}
+
+	// The refresh token issued before the switch was rotated with the session.
+	rec = httptest.NewRecorder()
+	api.RefreshHandler(s.key, api.DefaultTokenConfig(), s.storage).ServeHTTP(rec, refreshRequest(session.RefreshToken))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the refresh token of before the switch to be used, got %d", rec.Code)
+	}
+}
+
+func TestOrganizations_SwitchWithoutSession(t *testing.T) {
+	s := newOrgsServer(t)
+	org := s.create(t, "jj@gmail.com", `{"name": "Acme"}`)
+
+	w := s.do(http.MethodPost, "/orgs/"+org.ID+"/switch", "jj@gmail.com", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	if res.AccessToken == "" || res.RefreshToken != "" {
+		t.Fatalf("expected an access token only, got %+v", res)
+	}
+}
+
+func TestOrganizations_SwitchEndedSession(t *testing.T) {
+	s := newOrgsServer(t)
+	org := s.create(t, "jj@gmail.com", `{"name": "Acme"}`)
+	s.login(t)
+	err := s.storage.RevokeRefreshTokenFamily("family")
+	if err != nil {
+		t.Fatalf("failed to revoke family: %v", err)
+	}
+
+	if w := s.do(http.MethodPost, "/orgs/"+org.ID+"/switch", "jj@gmail.com", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 switching in an ended session, got %d", w.Code)
+	}
+}
+
+func TestOrganizations_RefreshAfterLeaving(t *testing.T) {
+	s := newOrgsServer(t)
+	org := s.create(t, "jj@gmail.com", `{"name": "Acme"}`)
+	s.login(t)
+	w := s.do(http.MethodPost, "/orgs/"+org.ID+"/switch", "jj@gmail.com", nil)
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	s.storage.memberships[org.ID] = nil
+
+	rec := httptest.NewRecorder()
+	api.RefreshHandler(s.key, api.DefaultTokenConfig(), s.storage).ServeHTTP(rec, refreshRequest(res.RefreshToken))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	err = json.NewDecoder(rec.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode refresh response: %v", err)
+	}
+	token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), s.key, api.DefaultTokenConfig())
+	if err != nil {
+		t.Fatalf("failed to verify refreshed token: %v", err)
+	}
+	if token.OrgID != "" {
+		t.Fatalf("expected the refreshed token out of the organization left, got %q", token.OrgID)
+	}
+}
+
+func TestOrganizations_Domain(t *testing.T) {
+	s := newOrgsServer(t)
+	if w := s.do(http.MethodPost, "/orgs", "jj@gmail.com", strings.NewReader(`{"name": "Gmail", "domain": "gmail.com"}`)); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 setting a domain as non admin, got %d", w.Code)
+	}
+
+	org := s.create(t, "admin@gmail.com", `{"name": "Acme", "domain": "ACME.com"}`)
+	if org.Domain != "acme.com" {
+		t.Fatalf("expected the lower cased domain, got %q", org.Domain)
+	}
+	if w := s.do(http.MethodPost, "/orgs", "admin@gmail.com", strings.NewReader(`{"name": "Other", "domain": "acme.com"}`)); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a taken domain, got %d", w.Code)
+	}
+
+	err := s.storage.SaveUser(legitima.Identity{Email: "jojo@Acme.com"})
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	if _, err := s.storage.UserOrganization("jojo@Acme.com", org.ID); err != nil {
+		t.Fatalf("expected the user of the domain to join the organization: %v", err)
+	}
+}
+
+func TestOrganizations_Invalid(t *testing.T) {
+	for _, cfg := range []string{`{`, `{"name": " "}`} {
+		s := newOrgsServer(t)
+		if w := s.do(http.MethodPost, "/orgs", "jj@gmail.com", strings.NewReader(cfg)); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", cfg, w.Code)
+		}
+	}
+}
+
+// fakeOrganizations is an in memory api.OrganizationStorage.
+type fakeOrganizations struct {
+	mu          sync.Mutex
+	orgs        map[string]legitima.Organization
+	memberships map[string][]legitima.Membership
+}
+
+func newFakeOrganizations() *fakeOrganizations {
+	return &fakeOrganizations{
+		orgs:        map[string]legitima.Organization{},
+		memberships: map[string][]legitima.Membership{},
+	}
+}
+
+func (s *fakeOrganizations) SaveOrganization(org legitima.Organization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.orgs {
+		if o.ID == org.ID || (org.Domain != "" && o.Domain == org.Domain) {
+			return fmt.Errorf("save organization: %w", legitima.ErrAlreadyExists)
+		}
+	}
+	s.orgs[org.ID] = org
+	return nil
+}
+
+func (s *fakeOrganizations) SaveMembership(membership legitima.Membership) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orgs[membership.OrgID]; !ok {
+		return fmt.Errorf("save membership: %w", legitima.ErrNotFound)
+	}
+	s.join(membership)
+	return nil
+}
+
+// joinDomain makes the user with the email join the organizations of its domain.
+func (s *fakeOrganizations) joinDomain(email string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, org := range s.orgs {
+		if org.Domain != "" && org.Domain == legitima.EmailDomain(email) {
+			s.join(legitima.Membership{OrgID: org.ID, Email: email, CreatedAt: now})
+		}
+	}
+}
+
+// join adds the membership, if the user is not a member yet.
+func (s *fakeOrganizations) join(membership legitima.Membership) {
+	for _, m := range s.memberships[membership.OrgID] {
+		if m.Email == membership.Email {
+			return
+		}
+	}
+	s.memberships[membership.OrgID] = append(s.memberships[membership.OrgID], membership)
+}
+
+func (s *fakeOrganizations) UserOrganization(email, orgID string) (*legitima.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.memberships[orgID] {
+		if m.Email == email {
+			org := s.orgs[orgID]
+			return &org, nil
+		}
+	}
+	return nil, fmt.Errorf("user organization: %w", legitima.ErrNotFound)
+}
+
+func (s *fakeOrganizations) UserOrganizations(email string) ([]legitima.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var orgs []legitima.Organization
+	for orgID, members := range s.memberships {
+		for _, m := range members {
+			if m.Email == email {
+				orgs = append(orgs, s.orgs[orgID])
+			}
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Name < orgs[j].Name })
+	return orgs, nil
+}
+
+func (s *fakeOrganizations) Memberships(orgID string) ([]legitima.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]legitima.Membership(nil), s.memberships[orgID]...), nil
+}
@@ -12,16 +12,16 @@ import (
 const profileURL = "/profile"
 
 // SetupProfile sets up the profile page.
-func SetupProfile(mux *http.ServeMux, storage Storage) {
-	mux.Handle(profileURL, ProfileHandler(storage))
+func SetupProfile(mux *http.ServeMux, verifier Verifier, tokenCfg TokenConfig, storage Storage) {
+	mux.Handle(profileURL, RequireAuth(verifier, tokenCfg, storage, RequireOwnLogin())(ProfileHandler()))
 }
 
-// ProfileHandler handles the profile page.
-func ProfileHandler(storage Storage) http.Handler {
+// ProfileHandler handles the profile page of the user authenticated by RequireAuth.
+func ProfileHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			profile(w, r, storage)
+			profile(w, r)
 		default:
 			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
 		}
@@ -31,28 +31,14 @@ func ProfileHandler(storage Storage) http.Handler {
 //go:embed templates/profile.html
 var profileTemplateFS embed.FS
 
-func profile(w http.ResponseWriter, r *http.Request, storage Storage) {
-	authCookie, err := r.Cookie("Authorization")
-	if err != nil {
-		slog.Error("failed to get token from cookie", "error", err.Error())
+func profile(w http.ResponseWriter, r *http.Request) {
+	usr, ok := UserFromContext(r.Context())
+	if !ok {
+		slog.Error("no user in the request context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	receivedToken := authCookie.Value
-	r.Header.Set("Authorization", receivedToken)
-	token, err := TokenFromHeader(r)
-	if err != nil {
-		slog.Error("invalid token", "error", err.Error())
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
 
-	usr, err := storage.UserByEmail(token.Email)
-	if err != nil {
-		slog.Error("failed to get user", "error", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
 	tmpl, err := template.ParseFS(profileTemplateFS, "templates/profile.html")
 	if err != nil {
 		slog.Error("failed to parse template", "error", err.Error())
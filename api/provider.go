@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/birdie-ai/legitima"
+	"golang.org/x/oauth2"
+)
+
+// IdentityProvider authenticates users with an external identity provider.
+// The LoginState of the login attempt is given to every step of the flow,
+// so providers can use its PKCE verifier or nonce.
+type IdentityProvider interface {
+	// Name identifies the provider in the login and callback URLs.
+	Name() string
+	// AuthCodeURL returns the URL of the provider consent page.
+	AuthCodeURL(state string, login LoginState) string
+	// Exchange exchanges the authorization code for a token.
+	Exchange(ctx context.Context, code string, login LoginState) (*oauth2.Token, error)
+	// Identity fetches the profile of the authenticated user.
+	Identity(ctx context.Context, token *oauth2.Token, login LoginState) (legitima.Identity, error)
+}
+
+// Providers is the registry of the identity providers users can log in with.
+type Providers struct {
+	byName      map[string]IdentityProvider
+	names       []string
+	defaultName string
+	// allowedDomains are the email domains whose users can log in, any domain when empty.
+	allowedDomains []string
+}
+
+// NewProviders returns a new Providers instance.
+// The first provider is the default one, used when the login URL names none.
+func NewProviders(providers ...IdentityProvider) (*Providers, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("at least one identity provider is required")
+	}
+	p := &Providers{byName: map[string]IdentityProvider{}, defaultName: providers[0].Name()}
+	for _, provider := range providers {
+		name := provider.Name()
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid identity provider name %q", name)
+		}
+		if _, ok := p.byName[name]; ok {
+			return nil, fmt.Errorf("duplicated identity provider %q", name)
+		}
+		p.byName[name] = provider
+		p.names = append(p.names, name)
+	}
+	return p, nil
+}
+
+// Get returns the provider with the given name, or the default one if name is empty.
+func (p *Providers) Get(name string) (IdentityProvider, bool) {
+	if name == "" {
+		name = p.defaultName
+	}
+	provider, ok := p.byName[name]
+	return provider, ok
+}
+
+// Names returns the names of the providers, the default one first.
+func (p *Providers) Names() []string {
+	return p.names
+}
+
+// AllowEmailDomains restricts the login with any provider to the users whose email is in one of the domains.
+// Users of any domain can log in when no domain is given.
+func (p *Providers) AllowEmailDomains(domains ...string) {
+	p.allowedDomains = nil
+	for _, domain := range domains {
+		p.allowedDomains = append(p.allowedDomains, strings.ToLower(domain))
+	}
+}
+
+// EmailAllowed tells if the users with the given email can log in.
+func (p *Providers) EmailAllowed(email string) bool {
+	return len(p.allowedDomains) == 0 || contains(p.allowedDomains, legitima.EmailDomain(email))
+}
+
+// oauth2Provider implements the authorization code flow with PKCE for the providers based on oauth2.Config.
+type oauth2Provider struct {
+	name   string
+	config *oauth2.Config
+}
+
+// Name returns the name of the provider.
+func (p *oauth2Provider) Name() string {
+	return p.name
+}
+
+// AuthCodeURL returns the URL of the provider consent page, sending the PKCE challenge.
+func (p *oauth2Provider) AuthCodeURL(state string, login LoginState) string {
+	return p.config.AuthCodeURL(state, oauth2.AccessTypeOnline, oauth2.S256ChallengeOption(login.CodeVerifier))
+}
+
+// Exchange exchanges the authorization code for a token, sending the PKCE verifier.
+func (p *oauth2Provider) Exchange(ctx context.Context, code string, login LoginState) (*oauth2.Token, error) {
+	return p.config.Exchange(ctx, code, oauth2.VerifierOption(login.CodeVerifier))
+}
+
+// getJSON fetches url with the client and decodes the JSON response into v.
+func getJSON(client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+	"github.com/google/uuid"
+)
+
+const (
+	// grantRefreshToken is the grant type redeeming the refresh tokens of OAuth clients.
+	grantRefreshToken = "refresh_token"
+	refreshURL        = "/token/refresh"
+	// refreshCookie holds the refresh token of browser sessions.
+	refreshCookie = "Refresh"
+	// refreshCookiePath scopes the refresh cookie to the endpoints using it: refresh and logout.
+	refreshCookiePath = "/token"
+)
+
+// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens.
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// RefreshTokenStorage persists the refresh tokens.
+type RefreshTokenStorage interface {
+	SaveRefreshToken(token legitima.RefreshToken) error
+	RefreshTokenByHash(hash string) (*legitima.RefreshToken, error)
+	RotateRefreshToken(usedHash string, next legitima.RefreshToken) error
+	// RotateRefreshTokenFamily marks the usable refresh tokens of the family as used and saves the next one,
+	// returning legitima.ErrNotFound if the family has none left.
+	RotateRefreshTokenFamily(familyID string, next legitima.RefreshToken) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(email string) error
+}
+
+// TokenResponse is the response sent to the client when tokens are issued.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IDToken is issued when the openid scope is granted.
+	IDToken string `json:"id_token,omitempty"`
+	// IssuedTokenType is the type of the token issued by a token exchange.
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// SetupRefresh sets up the refresh token endpoint.
+func SetupRefresh(mux *http.ServeMux, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	mux.Handle(refreshURL, RefreshHandler(signer, tokenCfg, storage))
+}
+
+// RefreshHandler handles the refresh token endpoint.
+// The refresh token is read from the refresh_token form value or from the refresh cookie.
+func RefreshHandler(signer Signer, tokenCfg TokenConfig, storage Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			refresh(w, r, signer, tokenCfg, storage)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func refresh(w http.ResponseWriter, r *http.Request, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+
+	refreshToken := r.PostFormValue("refresh_token")
+	fromCookie := false
+	if refreshToken == "" {
+		cookie, err := r.Cookie(refreshCookie)
+		if err != nil {
+			sendErr(ctx, w, errors.New("missing refresh token"), http.StatusBadRequest)
+			return
+		}
+		refreshToken = cookie.Value
+		fromCookie = true
+	}
+
+	// The refresh tokens of OAuth clients are redeemed at the token endpoint, where the client authenticates.
+	res, err := redeemRefreshToken(ctx, signer, tokenCfg, storage, refreshToken, "")
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, legitima.ErrRefreshTokenReused) {
+		sendErr(ctx, w, err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if fromCookie {
+		setTokenCookies(w, tokenCfg, res)
+	}
+	sendJSON(ctx, w, http.StatusOK, res)
+}
+
+// refreshTokenGrant rotates a refresh token issued to the client, as defined in RFC 6749 6.
+// The session keeps its scope.
+func refreshTokenGrant(w http.ResponseWriter, r *http.Request, client *legitima.Client, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	refreshToken := r.PostFormValue("refresh_token")
+	if refreshToken == "" {
+		sendOAuthErr(ctx, w, "invalid_request", errors.New("missing refresh token"), http.StatusBadRequest)
+		return
+	}
+	res, err := redeemRefreshToken(ctx, signer, tokenCfg, storage, refreshToken, client.ID)
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, legitima.ErrRefreshTokenReused) {
+		sendOAuthErr(ctx, w, "invalid_grant", err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("refresh token redeemed", "client_id", client.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, res)
+}
+
+// redeemRefreshToken rotates the refresh token, returning the tokens of the next step of its session.
+// The session must have been authorized for the client with the given ID, empty for the own login of legitima.
+// Unknown, revoked, expired and other client's tokens are reported with ErrInvalidRefreshToken,
+// reused ones with legitima.ErrRefreshTokenReused once their family is revoked.
+func redeemRefreshToken(ctx context.Context, signer Signer, tokenCfg TokenConfig, storage Storage, refreshToken, clientID string) (TokenResponse, error) {
+	hash := hashToken(refreshToken)
+	stored, err := storage.RefreshTokenByHash(hash)
+	if errors.Is(err, legitima.ErrNotFound) {
+		return TokenResponse{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if stored.ClientID != clientID {
+		return TokenResponse{}, fmt.Errorf("%w: issued to another client", ErrInvalidRefreshToken)
+	}
+	// Reuse is checked first: a replayed token revokes its family even once it has expired.
+	if !stored.UsedAt.IsZero() {
+		return TokenResponse{}, revokeReusedFamily(ctx, storage, stored)
+	}
+	if stored.Revoked || time.Now().After(stored.ExpiresAt) {
+		return TokenResponse{}, ErrInvalidRefreshToken
+	}
+
+	// The user may have left the organization of the session since it was chosen.
+	session := *stored
+	if session.OrgID != "" {
+		_, err := storage.UserOrganization(session.Email, session.OrgID)
+		if errors.Is(err, legitima.ErrNotFound) {
+			session.OrgID = ""
+		} else if err != nil {
+			return TokenResponse{}, err
+		}
+	}
+
+	res, next, err := issueTokens(signer, tokenCfg, storage, session)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	err = storage.RotateRefreshToken(hash, next)
+	if errors.Is(err, legitima.ErrRefreshTokenReused) {
+		return TokenResponse{}, revokeReusedFamily(ctx, storage, stored)
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	return res, nil
+}
+
+// revokeReusedFamily revokes the family of a refresh token used twice:
+// it may have been stolen, so nobody can keep using the family.
+// It returns legitima.ErrRefreshTokenReused once the family is revoked.
+func revokeReusedFamily(ctx context.Context, storage RefreshTokenStorage, reused *legitima.RefreshToken) error {
+	log := slog.FromCtx(ctx)
+
+	log.Warn("refresh token reused, revoking its family", "family_id", reused.FamilyID, "email", reused.Email)
+	err := storage.RevokeRefreshTokenFamily(reused.FamilyID)
+	if err != nil {
+		return err
+	}
+	return legitima.ErrRefreshTokenReused
+}
+
+// issueTokens generates an access token with the current roles of the user, and the next refresh token of the session.
+// The session is the refresh token being rotated or, for new sessions, one with just the email, scope and organization.
+// The returned refresh token must be stored before the response is sent.
+func issueTokens(signer Signer, tokenCfg TokenConfig, roles RoleStorage, session legitima.RefreshToken) (TokenResponse, legitima.RefreshToken, error) {
+	familyID := session.FamilyID
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+	userRoles, err := roles.UserRoles(session.Email)
+	if err != nil {
+		return TokenResponse{}, legitima.RefreshToken{}, fmt.Errorf("loading roles: %w", err)
+	}
+	accessToken, err := generateToken(signer, tokenCfg, Claims{
+		Email:     session.Email,
+		ClientID:  session.ClientID,
+		SessionID: familyID,
+		Scope:     session.Scope,
+		Roles:     userRoles,
+		OrgID:     session.OrgID,
+	})
+	if err != nil {
+		return TokenResponse{}, legitima.RefreshToken{}, fmt.Errorf("generating access token: %w", err)
+	}
+
+	refreshToken, err := randomString(32)
+	if err != nil {
+		return TokenResponse{}, legitima.RefreshToken{}, err
+	}
+
+	res := TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokenCfg.TTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        session.Scope,
+	}
+	stored := legitima.RefreshToken{
+		Hash:      hashToken(refreshToken),
+		FamilyID:  familyID,
+		Email:     session.Email,
+		ClientID:  session.ClientID,
+		Scope:     session.Scope,
+		OrgID:     session.OrgID,
+		ExpiresAt: time.Now().Add(tokenCfg.RefreshTTL),
+	}
+	return res, stored, nil
+}
+
+// setTokenCookies stores the issued tokens in the browser.
+func setTokenCookies(w http.ResponseWriter, tokenCfg TokenConfig, res TokenResponse) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "Authorization",
+		Value:    "Bearer " + res.AccessToken,
+		HttpOnly: true,
+		Path:     "/",
+		MaxAge:   int(tokenCfg.TTL.Seconds()),
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    res.RefreshToken,
+		HttpOnly: true,
+		Path:     refreshCookiePath,
+		MaxAge:   int(tokenCfg.RefreshTTL.Seconds()),
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearTokenCookies removes the tokens from the browser.
+// The refresh cookie is also cleared from refreshURL, where it used to be scoped.
+func clearTokenCookies(w http.ResponseWriter) {
+	for _, c := range []struct{ name, path string }{
+		{"Authorization", "/"},
+		{refreshCookie, refreshCookiePath},
+		{refreshCookie, refreshURL},
+	} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.name,
+			Path:     c.path,
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+		})
+	}
+}
+
+// hashToken returns the hash under which an opaque token is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
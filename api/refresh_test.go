@@ -0,0 +1,261 @@
+package api_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+func seedRefreshToken(t *testing.T, storage *mockStorage, token string, expiresAt time.Time) {
+	t.Helper()
+	sum := sha256.Sum256([]byte(token))
+	err := storage.SaveRefreshToken(legitima.RefreshToken{
+		Hash:      hex.EncodeToString(sum[:]),
+		FamilyID:  "family",
+		Email:     "jj@gmail.com",
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("failed to save refresh token: %v", err)
+	}
+}
+
+func refreshRequest(token string) *http.Request {
+	form := url.Values{"refresh_token": {token}}
+	r := httptest.NewRequest(http.MethodPost, "/token/refresh", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestRefresh_Rotation(t *testing.T) {
+	storage := newMockStorage()
+	seedRefreshToken(t, storage, "first", time.Now().Add(time.Hour))
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+	h := api.RefreshHandler(key, cfg, storage)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, refreshRequest("first"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if res.RefreshToken == "" || res.RefreshToken == "first" {
+		t.Fatalf("expected a new refresh token, got %q", res.RefreshToken)
+	}
+	token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), key, cfg)
+	if err != nil {
+		t.Fatalf("failed to verify access token: %v", err)
+	}
+	if token.Email != "jj@gmail.com" {
+		t.Fatalf("expected email jj@gmail.com, got %s", token.Email)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, refreshRequest(res.RefreshToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for the rotated token, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestRefresh_ReuseRevokesFamily(t *testing.T) {
+	storage := newMockStorage()
+	seedRefreshToken(t, storage, "first", time.Now().Add(time.Hour))
+	h := api.RefreshHandler(newKey(t), api.DefaultTokenConfig(), storage)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, refreshRequest("first"))
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, refreshRequest("first"))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a reused token, got %d", w.Code)
+	}
+	if msg := errMessage(t, w); msg != legitima.ErrRefreshTokenReused.Error() {
+		t.Fatalf("expected %q, got %q", legitima.ErrRefreshTokenReused, msg)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, refreshRequest(res.RefreshToken))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a token of a revoked family, got %d", w.Code)
+	}
+}
+
+func TestRefresh_ExpiredReuseRevokesFamily(t *testing.T) {
+	storage := newMockStorage()
+	sum := sha256.Sum256([]byte("used"))
+	err := storage.SaveRefreshToken(legitima.RefreshToken{
+		Hash:      hex.EncodeToString(sum[:]),
+		FamilyID:  "family",
+		Email:     "jj@gmail.com",
+		ExpiresAt: time.Now().Add(-time.Hour),
+		UsedAt:    time.Now().Add(-2 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("failed to save refresh token: %v", err)
+	}
+	seedRefreshToken(t, storage, "current", time.Now().Add(time.Hour))
+	h := api.RefreshHandler(newKey(t), api.DefaultTokenConfig(), storage)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, refreshRequest("used"))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a reused expired token, got %d", w.Code)
+	}
+	if msg := errMessage(t, w); msg != legitima.ErrRefreshTokenReused.Error() {
+		t.Fatalf("expected %q, got %q", legitima.ErrRefreshTokenReused, msg)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, refreshRequest("current"))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a token of a revoked family, got %d", w.Code)
+	}
+}
+
+func TestRefresh_Invalid(t *testing.T) {
+	storage := newMockStorage()
+	seedRefreshToken(t, storage, "expired", time.Now().Add(-time.Hour))
+	h := api.RefreshHandler(newKey(t), api.DefaultTokenConfig(), storage)
+
+	for _, token := range []string{"expired", "unknown"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, refreshRequest(token))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for %s token, got %d", token, w.Code)
+		}
+		if msg := errMessage(t, w); msg != api.ErrInvalidRefreshToken.Error() {
+			t.Fatalf("expected %q, got %q", api.ErrInvalidRefreshToken, msg)
+		}
+	}
+}
+
+func TestRefresh_Cookie(t *testing.T) {
+	storage := newMockStorage()
+	seedRefreshToken(t, storage, "first", time.Now().Add(time.Hour))
+	h := api.RefreshHandler(newKey(t), api.DefaultTokenConfig(), storage)
+
+	r := httptest.NewRequest(http.MethodPost, "/token/refresh", nil)
+	r.AddCookie(&http.Cookie{Name: "Refresh", Value: "first"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	cookies := map[string]string{}
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	if !strings.HasPrefix(cookies["Authorization"], "Bearer ") {
+		t.Fatalf("expected Authorization cookie, got %v", cookies)
+	}
+	if cookies["Refresh"] == "" || cookies["Refresh"] == "first" {
+		t.Fatalf("expected rotated Refresh cookie, got %v", cookies)
+	}
+}
+
+// fakeRefreshTokens is an in memory api.RefreshTokenStorage.
+type fakeRefreshTokens struct {
+	mu            sync.Mutex
+	refreshTokens map[string]legitima.RefreshToken
+}
+
+func newFakeRefreshTokens() *fakeRefreshTokens {
+	return &fakeRefreshTokens{
+		refreshTokens: map[string]legitima.RefreshToken{},
+	}
+}
+
+func (s *fakeRefreshTokens) SaveRefreshToken(token legitima.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshTokens[token.Hash] = token
+	return nil
+}
+
+func (s *fakeRefreshTokens) RefreshTokenByHash(hash string) (*legitima.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.refreshTokens[hash]
+	if !ok {
+		return nil, fmt.Errorf("refresh token by hash: %w", legitima.ErrNotFound)
+	}
+	return &token, nil
+}
+
+func (s *fakeRefreshTokens) RotateRefreshToken(usedHash string, next legitima.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	used := s.refreshTokens[usedHash]
+	if !used.UsedAt.IsZero() {
+		return legitima.ErrRefreshTokenReused
+	}
+	used.UsedAt = time.Now()
+	s.refreshTokens[usedHash] = used
+	s.refreshTokens[next.Hash] = next
+	return nil
+}
+
+func (s *fakeRefreshTokens) RotateRefreshTokenFamily(familyID string, next legitima.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rotated := false
+	for hash, token := range s.refreshTokens {
+		if token.FamilyID == familyID && token.UsedAt.IsZero() && !token.Revoked && time.Now().Before(token.ExpiresAt) {
+			token.UsedAt = time.Now()
+			s.refreshTokens[hash] = token
+			rotated = true
+		}
+	}
+	if !rotated {
+		return fmt.Errorf("rotate refresh token family: %w", legitima.ErrNotFound)
+	}
+	s.refreshTokens[next.Hash] = next
+	return nil
+}
+
+func (s *fakeRefreshTokens) RevokeRefreshTokenFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, token := range s.refreshTokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+			s.refreshTokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (s *fakeRefreshTokens) RevokeUserRefreshTokens(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, token := range s.refreshTokens {
+		if token.Email == email {
+			token.Revoked = true
+			s.refreshTokens[hash] = token
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// codeExchangeURL is where applications exchange the login code for tokens.
+	codeExchangeURL = "/token/code"
+	// loginCodeTTL is how long an application can wait to exchange a login code.
+	loginCodeTTL = time.Minute
+)
+
+// Return URL errors
+var (
+	ErrInvalidReturnURL = errors.New("invalid return url")
+	ErrInvalidLoginCode = errors.New("invalid login code")
+	// ErrMissingReturnChallenge is returned when a login returning to another application has no S256 code challenge.
+	ErrMissingReturnChallenge = errors.New("returning to another application requires a S256 code_challenge")
+)
+
+// ReturnURLs validates the URLs users are sent back to after logging in.
+// Paths of legitima itself are always allowed, other URLs must have an allowed origin.
+type ReturnURLs struct {
+	origins map[string]bool
+}
+
+// NewReturnURLs returns a new ReturnURLs instance allowing the given origins, like https://app.example.com.
+func NewReturnURLs(origins ...string) (*ReturnURLs, error) {
+	r := &ReturnURLs{origins: map[string]bool{}}
+	for _, o := range origins {
+		u, err := url.Parse(o)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid return origin %q", o)
+		}
+		r.origins[origin(u)] = true
+	}
+	return r, nil
+}
+
+// Validate returns the URL users can be sent back to, or ErrInvalidReturnURL.
+// An empty returnTo is valid and means the profile page.
+func (r *ReturnURLs) Validate(returnTo string) (string, error) {
+	if returnTo == "" {
+		return "", nil
+	}
+	u, err := url.Parse(returnTo)
+	if err != nil {
+		return "", ErrInvalidReturnURL
+	}
+	if isLocalPath(returnTo, u) {
+		return returnTo, nil
+	}
+	if r == nil || (u.Scheme != "https" && u.Scheme != "http") || u.User != nil || !r.origins[origin(u)] {
+		return "", ErrInvalidReturnURL
+	}
+	return returnTo, nil
+}
+
+// isLocalPath tells if the URL is a path of legitima itself.
+// Browsers take //host and /\host as other hosts, so they are not local.
+func isLocalPath(raw string, u *url.URL) bool {
+	return u.Scheme == "" && u.Host == "" && strings.HasPrefix(raw, "/") &&
+		!strings.HasPrefix(raw, "//") && !strings.HasPrefix(raw, "/\\")
+}
+
+func origin(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// returnChallenge returns the PKCE challenge the application sent along with the validated returnTo.
+// The login code handed to other applications can only be exchanged with the verifier of the challenge,
+// so a code leaked from the return URL is useless to anyone else.
+func returnChallenge(r *http.Request, returnTo string) (string, error) {
+	u, err := url.Parse(returnTo)
+	if err != nil {
+		return "", ErrInvalidReturnURL
+	}
+	if returnTo == "" || isLocalPath(returnTo, u) {
+		return "", nil
+	}
+	query := r.URL.Query()
+	challenge := query.Get("code_challenge")
+	if challenge == "" || query.Get("code_challenge_method") != "S256" {
+		return "", ErrMissingReturnChallenge
+	}
+	return challenge, nil
+}
+
+// LoginCode is a one-time code handed to the application the user returns to,
+// so the tokens never travel in the URL.
+type LoginCode struct {
+	Email string
+	// ReturnTo must be presented again when exchanging the code.
+	ReturnTo string
+	// CodeChallenge is the S256 PKCE challenge whose verifier must be presented when exchanging the code.
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// LoginCodeStore keeps the login codes until they are exchanged.
+// Consume must return a given code at most once.
+type LoginCodeStore interface {
+	Save(code string, lc LoginCode) error
+	Consume(code string) (LoginCode, error)
+}
+
+// MemoryLoginCodeStore is a LoginCodeStore that keeps the codes in memory.
+type MemoryLoginCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]LoginCode
+}
+
+// NewMemoryLoginCodeStore returns a new MemoryLoginCodeStore instance.
+func NewMemoryLoginCodeStore() *MemoryLoginCodeStore {
+	return &MemoryLoginCodeStore{codes: map[string]LoginCode{}}
+}
+
+// Save stores the given code.
+func (s *MemoryLoginCodeStore) Save(code string, lc LoginCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range s.codes {
+		if now.After(v.ExpiresAt) {
+			delete(s.codes, k)
+		}
+	}
+	s.codes[code] = lc
+	return nil
+}
+
+// Consume removes the given code from the store and returns it.
+func (s *MemoryLoginCodeStore) Consume(code string) (LoginCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lc, ok := s.codes[code]
+	if !ok {
+		return LoginCode{}, ErrInvalidLoginCode
+	}
+	delete(s.codes, code)
+
+	if time.Now().After(lc.ExpiresAt) {
+		return LoginCode{}, ErrInvalidLoginCode
+	}
+	return lc, nil
+}
+
+// returnURL returns where the user goes after logging in, adding a login code to the URLs of other applications.
+func returnURL(codes LoginCodeStore, email string, login LoginState) (string, error) {
+	returnTo := login.ReturnTo
+	if returnTo == "" {
+		return profileURL, nil
+	}
+	u, err := url.Parse(returnTo)
+	if err != nil {
+		return "", err
+	}
+	if isLocalPath(returnTo, u) {
+		return returnTo, nil
+	}
+
+	code, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	err = codes.Save(code, LoginCode{
+		Email:         email,
+		ReturnTo:      returnTo,
+		CodeChallenge: login.ReturnChallenge,
+		ExpiresAt:     time.Now().Add(loginCodeTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("saving login code: %w", err)
+	}
+	query := u.Query()
+	query.Set("code", code)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// SetupCodeExchange sets up the endpoint exchanging login codes for tokens.
+func SetupCodeExchange(mux *http.ServeMux, codes LoginCodeStore, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	mux.Handle(codeExchangeURL, CodeExchangeHandler(codes, signer, tokenCfg, storage))
+}
+
+// CodeExchangeHandler handles the endpoint exchanging the code, return_to and code_verifier
+// form values of a login for its tokens.
+func CodeExchangeHandler(codes LoginCodeStore, signer Signer, tokenCfg TokenConfig, storage Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			exchangeCode(w, r, codes, signer, tokenCfg, storage)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func exchangeCode(w http.ResponseWriter, r *http.Request, codes LoginCodeStore, signer Signer, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	code := r.PostFormValue("code")
+	if code == "" {
+		sendErr(ctx, w, errors.New("missing code"), http.StatusBadRequest)
+		return
+	}
+	lc, err := codes.Consume(code)
+	if err != nil {
+		sendErr(ctx, w, ErrInvalidLoginCode, http.StatusBadRequest)
+		return
+	}
+	if r.PostFormValue("return_to") != lc.ReturnTo {
+		sendErr(ctx, w, ErrInvalidLoginCode, http.StatusBadRequest)
+		return
+	}
+	challenge := oauth2.S256ChallengeFromVerifier(r.PostFormValue("code_verifier"))
+	if lc.CodeChallenge == "" || subtle.ConstantTimeCompare([]byte(challenge), []byte(lc.CodeChallenge)) != 1 {
+		sendErr(ctx, w, ErrInvalidLoginCode, http.StatusBadRequest)
+		return
+	}
+
+	res, refreshToken, err := issueTokens(signer, tokenCfg, storage, legitima.RefreshToken{Email: lc.Email})
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	err = storage.SaveRefreshToken(refreshToken)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("login code exchanged", "email", lc.Email, "return_to", lc.ReturnTo)
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, res)
+}
@@ -0,0 +1,233 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima/api"
+	"golang.org/x/oauth2"
+)
+
+func TestReturnURLs_Validate(t *testing.T) {
+	returnURLs, err := api.NewReturnURLs("https://app.example.com", "http://localhost:3000")
+	if err != nil {
+		t.Fatalf("failed to create return urls: %v", err)
+	}
+
+	tests := []struct {
+		returnTo string
+		valid    bool
+	}{
+		{returnTo: "", valid: true},
+		{returnTo: "/profile", valid: true},
+		{returnTo: "https://app.example.com/dashboard?tab=1", valid: true},
+		{returnTo: "https://APP.example.com/", valid: true},
+		{returnTo: "http://localhost:3000/callback", valid: true},
+		{returnTo: "http://app.example.com/"},
+		{returnTo: "https://evil.com/"},
+		{returnTo: "https://app.example.com.evil.com/"},
+		{returnTo: "https://app.example.com@evil.com/"},
+		{returnTo: "https://app.example.com:8443/"},
+		{returnTo: "//evil.com/"},
+		{returnTo: "/\\evil.com/"},
+		{returnTo: "/\t/evil.com/"},
+		{returnTo: "javascript:alert(1)"},
+		{returnTo: "profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.returnTo, func(t *testing.T) {
+			_, err := returnURLs.Validate(tt.returnTo)
+			if valid := err == nil; valid != tt.valid {
+				t.Fatalf("expected valid %v, got error %v", tt.valid, err)
+			}
+		})
+	}
+
+	var none *api.ReturnURLs
+	if _, err := none.Validate("https://app.example.com/"); err == nil {
+		t.Fatal("expected other origins to be rejected without an allowlist")
+	}
+}
+
+func TestNewReturnURLs_Invalid(t *testing.T) {
+	for _, origin := range []string{"app.example.com", "ftp://app.example.com", "https://app.example.com/path"} {
+		if _, err := api.NewReturnURLs(origin); err == nil {
+			t.Fatalf("expected error for origin %q", origin)
+		}
+	}
+}
+
+func TestLogin_ReturnTo_OpenRedirect(t *testing.T) {
+	returnURLs, err := api.NewReturnURLs("https://app.example.com")
+	if err != nil {
+		t.Fatalf("failed to create return urls: %v", err)
+	}
+	h := api.LoginHandler(newProviders(t, newTestIssuer(t)), api.NewMemoryStateStore(), returnURLs)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login?return_to="+url.QueryEscape("https://evil.com/"), nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if msg := errMessage(t, w); msg != api.ErrInvalidReturnURL.Error() {
+		t.Fatalf("expected %q, got %q", api.ErrInvalidReturnURL, msg)
+	}
+}
+
+func TestCallback_ReturnTo(t *testing.T) {
+	const returnTo = "https://app.example.com/home?tab=1"
+	issuer := newTestIssuer(t)
+	providers, err := api.NewProviders(issuer.provider(t, "oidc"))
+	if err != nil {
+		t.Fatalf("failed to create providers: %v", err)
+	}
+	states := api.NewMemoryStateStore()
+	const verifier = "application-verifier"
+	err = states.Save("state", api.LoginState{
+		Provider:        "oidc",
+		Nonce:           "nonce",
+		ReturnTo:        returnTo,
+		ReturnChallenge: oauth2.S256ChallengeFromVerifier(verifier),
+		ExpiresAt:       time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+	codes := api.NewMemoryLoginCodeStore()
+	storage := newMockStorage()
+	key := newKey(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/callback/oidc?state=state&code=code", nil)
+	req.AddCookie(&http.Cookie{Name: "oauth_state", Value: "state"})
+	w := httptest.NewRecorder()
+	api.CallbackHandler(providers, states, codes, key, api.DefaultTokenConfig(), storage).ServeHTTP(w, req)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d: %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse location: %v", err)
+	}
+	if location.Host != "app.example.com" || location.Query().Get("tab") != "1" {
+		t.Fatalf("expected redirect to %s, got %s", returnTo, location)
+	}
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatal("expected login code in the redirect url")
+	}
+	if strings.Contains(location.String(), "access_token") {
+		t.Fatal("expected no token in the redirect url")
+	}
+
+	exchange := func(code, returnTo, verifier string) *httptest.ResponseRecorder {
+		form := url.Values{"code": {code}, "return_to": {returnTo}, "code_verifier": {verifier}}
+		req := httptest.NewRequest(http.MethodPost, "/token/code", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		api.CodeExchangeHandler(codes, key, api.DefaultTokenConfig(), storage).ServeHTTP(w, req)
+		return w
+	}
+
+	w = exchange(code, returnTo, verifier)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.TokenResponse
+	err = json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), key, api.DefaultTokenConfig())
+	if err != nil {
+		t.Fatalf("failed to verify access token: %v", err)
+	}
+	if token.Email != "jj@example.com" {
+		t.Fatalf("expected token of jj@example.com, got %s", token.Email)
+	}
+
+	if w := exchange(code, returnTo, verifier); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected replayed code to fail with 400, got %d", w.Code)
+	}
+}
+
+func TestLogin_ReturnTo_RequiresChallenge(t *testing.T) {
+	returnURLs, err := api.NewReturnURLs("https://app.example.com")
+	if err != nil {
+		t.Fatalf("failed to create return urls: %v", err)
+	}
+	h := api.LoginHandler(newProviders(t, newTestIssuer(t)), api.NewMemoryStateStore(), returnURLs)
+	returnTo := url.QueryEscape("https://app.example.com/")
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{query: "return_to=" + returnTo, code: http.StatusBadRequest},
+		{query: "return_to=" + returnTo + "&code_challenge=challenge&code_challenge_method=plain", code: http.StatusBadRequest},
+		{query: "return_to=" + returnTo + "&code_challenge=challenge&code_challenge_method=S256", code: http.StatusFound},
+		{query: "return_to=%2Fprofile", code: http.StatusFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login?"+tt.query, nil))
+		if w.Code != tt.code {
+			t.Fatalf("expected %d for %s, got %d", tt.code, tt.query, w.Code)
+		}
+	}
+}
+
+func TestCodeExchange_InvalidVerifier(t *testing.T) {
+	for _, verifier := range []string{"", "other-verifier"} {
+		codes := api.NewMemoryLoginCodeStore()
+		err := codes.Save("code", api.LoginCode{
+			Email:         "jj@example.com",
+			ReturnTo:      "https://app.example.com/",
+			CodeChallenge: oauth2.S256ChallengeFromVerifier("application-verifier"),
+			ExpiresAt:     time.Now().Add(time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("failed to save code: %v", err)
+		}
+
+		form := url.Values{"code": {"code"}, "return_to": {"https://app.example.com/"}, "code_verifier": {verifier}}
+		req := httptest.NewRequest(http.MethodPost, "/token/code", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		api.CodeExchangeHandler(codes, newKey(t), api.DefaultTokenConfig(), newMockStorage()).ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for verifier %q, got %d", verifier, w.Code)
+		}
+		if msg := errMessage(t, w); msg != api.ErrInvalidLoginCode.Error() {
+			t.Fatalf("expected %q, got %q", api.ErrInvalidLoginCode, msg)
+		}
+	}
+}
+
+func TestCodeExchange_ReturnToMismatch(t *testing.T) {
+	codes := api.NewMemoryLoginCodeStore()
+	err := codes.Save("code", api.LoginCode{Email: "jj@example.com", ReturnTo: "https://app.example.com/", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to save code: %v", err)
+	}
+
+	form := url.Values{"code": {"code"}, "return_to": {"https://evil.com/"}}
+	req := httptest.NewRequest(http.MethodPost, "/token/code", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	api.CodeExchangeHandler(codes, newKey(t), api.DefaultTokenConfig(), newMockStorage()).ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if msg := errMessage(t, w); msg != api.ErrInvalidLoginCode.Error() {
+		t.Fatalf("expected %q, got %q", api.ErrInvalidLoginCode, msg)
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+)
+
+const (
+	logoutURL      = "/token/logout"
+	adminRevokeURL = "/admin/revoke"
+)
+
+// ErrForbidden is returned when the caller is not allowed to use an endpoint.
+var ErrForbidden = errors.New("forbidden")
+
+// RevocationChecker tells if a token was revoked, either by its jti or
+// because all the tokens of its user issued until a given time were revoked.
+type RevocationChecker interface {
+	IsTokenRevoked(jti, email string, issuedAt time.Time) (bool, error)
+}
+
+// RevocationStorage persists the revoked tokens.
+// Revocations are kept until the tokens they refer to are expired.
+type RevocationStorage interface {
+	RevocationChecker
+	RevokeToken(jti string, expiresAt time.Time) error
+	RevokeUserTokens(email string, revokedAt, expiresAt time.Time) error
+	DeleteExpiredRevocations(now time.Time) (int64, error)
+}
+
+// SetupLogout sets up the logout endpoint.
+func SetupLogout(mux *http.ServeMux, verifier Verifier, tokenCfg TokenConfig, storage Storage) {
+	mux.Handle(logoutURL, LogoutHandler(verifier, tokenCfg, storage))
+}
+
+// LogoutHandler handles the logout endpoint.
+func LogoutHandler(verifier Verifier, tokenCfg TokenConfig, storage Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			logout(w, r, verifier, tokenCfg, storage)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// logout revokes the token of the request and the refresh tokens of its session.
+// The session is also revoked from the refresh cookie, so it ends even if the access token expired.
+// Browsers are redirected to the home page, API clients just get a 204.
+func logout(w http.ResponseWriter, r *http.Request, verifier Verifier, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+	fromHeader := r.Header.Get("Authorization") != ""
+
+	clearTokenCookies(w)
+
+	token, err := tokenFromRequest(r, verifier, tokenCfg)
+	if err == nil {
+		err = storage.RevokeToken(token.Claims.Id, time.Unix(token.Claims.ExpiresAt, 0))
+		if err != nil {
+			sendErr(ctx, w, err, http.StatusInternalServerError)
+			return
+		}
+		if token.Claims.SessionID != "" {
+			err = storage.RevokeRefreshTokenFamily(token.Claims.SessionID)
+			if err != nil {
+				sendErr(ctx, w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+		log.Info("logged out", "email", token.Email)
+	}
+
+	if cookie, err := r.Cookie(refreshCookie); err == nil {
+		session, err := storage.RefreshTokenByHash(hashToken(cookie.Value))
+		if err != nil && !errors.Is(err, legitima.ErrNotFound) {
+			sendErr(ctx, w, err, http.StatusInternalServerError)
+			return
+		}
+		if session != nil {
+			err = storage.RevokeRefreshTokenFamily(session.FamilyID)
+			if err != nil {
+				sendErr(ctx, w, err, http.StatusInternalServerError)
+				return
+			}
+			log.Info("logged out session", "email", session.Email)
+		}
+	}
+
+	if fromHeader {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// SetupAdmin sets up the admin endpoints, only usable by the users with the admin role.
+func SetupAdmin(mux *http.ServeMux, verifier Verifier, tokenCfg TokenConfig, storage Storage) {
+	mux.Handle(adminRevokeURL, AdminRevokeHandler(verifier, tokenCfg, storage))
+}
+
+// AdminRevokeHandler handles the endpoint revoking all the tokens of the user given by the email form value.
+func AdminRevokeHandler(verifier Verifier, tokenCfg TokenConfig, storage Storage) http.Handler {
+	return RequireAuth(verifier, tokenCfg, storage, RequireOwnLogin())(RequireRole(legitima.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			adminRevoke(w, r, tokenCfg, storage)
+		default:
+			sendErr(r.Context(), w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		}
+	})))
+}
+
+func adminRevoke(w http.ResponseWriter, r *http.Request, tokenCfg TokenConfig, storage Storage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	usr, _ := UserFromContext(ctx)
+
+	email := r.PostFormValue("email")
+	if email == "" {
+		sendErr(ctx, w, errors.New("missing email"), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	// Every token issued until now is expired once the longest lived one is.
+	err := storage.RevokeUserTokens(email, now, now.Add(tokenCfg.TTL+tokenCfg.Leeway))
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	err = storage.RevokeUserRefreshTokens(email)
+	if err != nil {
+		sendErr(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("revoked user tokens", "email", email, "admin", usr.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunRevocationCleanup deletes the expired revocations periodically.
+// It blocks until the context is cancelled.
+func RunRevocationCleanup(ctx context.Context, storage RevocationStorage, interval time.Duration) {
+	log := slog.FromCtx(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := storage.DeleteExpiredRevocations(time.Now())
+		if err != nil {
+			log.Error("failed to delete expired revocations", "error", err.Error())
+			continue
+		}
+		log.Debug("deleted expired revocations", "count", n)
+	}
+}
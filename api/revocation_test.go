@@ -0,0 +1,215 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+func TestLogout(t *testing.T) {
+	storage := newMockStorage()
+	seedRefreshToken(t, storage, "first", time.Now().Add(time.Hour))
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+	cfg.Revocations = storage
+
+	w := httptest.NewRecorder()
+	api.RefreshHandler(key, cfg, storage).ServeHTTP(w, refreshRequest("first"))
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/token/logout", nil)
+	r.Header.Set("Authorization", "Bearer "+res.AccessToken)
+	w = httptest.NewRecorder()
+	api.LogoutHandler(key, cfg, storage).ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			t.Fatalf("expected cookie %s to be cleared", c.Name)
+		}
+	}
+
+	_, err = api.TokenFromHeader(requestWithToken(res.AccessToken), key, cfg)
+	if !errors.Is(err, api.ErrTokenRevoked) {
+		t.Fatalf("expected %v, got %v", api.ErrTokenRevoked, err)
+	}
+
+	w = httptest.NewRecorder()
+	api.RefreshHandler(key, cfg, storage).ServeHTTP(w, refreshRequest(res.RefreshToken))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 refreshing a logged out session, got %d", w.Code)
+	}
+}
+
+func TestLogout_Cookie(t *testing.T) {
+	storage := newMockStorage()
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+	cfg.Revocations = storage
+
+	token, err := api.GenerateToken(key, cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/token/logout", nil)
+	r.AddCookie(&http.Cookie{Name: "Authorization", Value: "Bearer " + token})
+	w := httptest.NewRecorder()
+	api.LogoutHandler(key, cfg, storage).ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d", w.Code)
+	}
+
+	_, err = api.TokenFromHeader(requestWithToken(token), key, cfg)
+	if !errors.Is(err, api.ErrTokenRevoked) {
+		t.Fatalf("expected %v, got %v", api.ErrTokenRevoked, err)
+	}
+}
+
+func TestLogout_ExpiredAccessToken(t *testing.T) {
+	storage := newMockStorage()
+	seedRefreshToken(t, storage, "first", time.Now().Add(time.Hour))
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+	cfg.Revocations = storage
+
+	expiredCfg := cfg
+	expiredCfg.TTL = -time.Hour
+	token, err := api.GenerateToken(key, expiredCfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/token/logout", nil)
+	r.AddCookie(&http.Cookie{Name: "Authorization", Value: "Bearer " + token})
+	r.AddCookie(&http.Cookie{Name: "Refresh", Value: "first"})
+	w := httptest.NewRecorder()
+	api.LogoutHandler(key, cfg, storage).ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	api.RefreshHandler(key, cfg, storage).ServeHTTP(w, refreshRequest("first"))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 refreshing a logged out session, got %d", w.Code)
+	}
+}
+
+func TestAdminRevoke(t *testing.T) {
+	storage := newMockStorage()
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+	cfg.Revocations = storage
+	h := api.AdminRevokeHandler(key, cfg, storage)
+	err := api.BootstrapAdmins(storage, []string{"admin@gmail.com"})
+	if err != nil {
+		t.Fatalf("failed to bootstrap admins: %v", err)
+	}
+	for _, email := range []string{"jj@gmail.com", "admin@gmail.com"} {
+		err := storage.SaveUser(legitima.Identity{Email: email})
+		if err != nil {
+			t.Fatalf("failed to save user: %v", err)
+		}
+	}
+
+	userToken, err := api.GenerateToken(key, cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	adminToken, err := api.GenerateToken(key, cfg, "admin@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	revoke := func(token string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {"jj@gmail.com"}}
+		r := httptest.NewRequest(http.MethodPost, "/admin/revoke", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := revoke(userToken); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a non admin, got %d", w.Code)
+	}
+	if w := revoke(adminToken); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+
+	_, err = api.TokenFromHeader(requestWithToken(userToken), key, cfg)
+	if !errors.Is(err, api.ErrTokenRevoked) {
+		t.Fatalf("expected %v, got %v", api.ErrTokenRevoked, err)
+	}
+	_, err = api.TokenFromHeader(requestWithToken(adminToken), key, cfg)
+	if err != nil {
+		t.Fatalf("expected admin token to be valid: %v", err)
+	}
+}
+
+// fakeRevocations is an in memory api.RevocationStorage.
+type fakeRevocations struct {
+	mu            sync.Mutex
+	revokedTokens map[string]time.Time
+	revokedUsers  map[string]time.Time
+}
+
+func newFakeRevocations() *fakeRevocations {
+	return &fakeRevocations{
+		revokedTokens: map[string]time.Time{},
+		revokedUsers:  map[string]time.Time{},
+	}
+}
+
+func (s *fakeRevocations) RevokeToken(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedTokens[jti] = expiresAt
+	return nil
+}
+
+func (s *fakeRevocations) RevokeUserTokens(email string, revokedAt, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedUsers[email] = revokedAt
+	return nil
+}
+
+func (s *fakeRevocations) IsTokenRevoked(jti, email string, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.revokedTokens[jti]; ok {
+		return true, nil
+	}
+	revokedAt, ok := s.revokedUsers[email]
+	return ok && issuedAt.Before(revokedAt), nil
+}
+
+func (s *fakeRevocations) DeleteExpiredRevocations(now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for jti, expiresAt := range s.revokedTokens {
+		if expiresAt.Before(now) {
+			delete(s.revokedTokens, jti)
+			n++
+		}
+	}
+	return n, nil
+}
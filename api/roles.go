@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+)
+
+// RoleStorage persists the roles and the users they are granted to, identified by their email.
+type RoleStorage interface {
+	SaveRole(role legitima.Role) error
+	Roles() ([]legitima.Role, error)
+	DeleteRole(name string) error
+	GrantRole(email, role string) error
+	RevokeRole(email, role string) error
+	UserRoles(email string) ([]string, error)
+}
+
+// BootstrapAdmins creates the admin role, if missing, and grants it to the given emails.
+// The users don't need to have logged in before.
+func BootstrapAdmins(storage RoleStorage, emails []string) error {
+	err := storage.SaveRole(legitima.Role{
+		Name:        legitima.RoleAdmin,
+		Description: "Manages legitima",
+		CreatedAt:   time.Now(),
+	})
+	if err != nil && !errors.Is(err, legitima.ErrAlreadyExists) {
+		return fmt.Errorf("creating admin role: %w", err)
+	}
+	for _, email := range emails {
+		err = storage.GrantRole(email, legitima.RoleAdmin)
+		if err != nil {
+			return fmt.Errorf("granting admin role to %s: %w", email, err)
+		}
+	}
+	return nil
+}
+
+// RequireRole returns a middleware that only lets through the users with the given role.
+// It must run after RequireAuth, which loads the user with their current roles.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			usr, ok := UserFromContext(ctx)
+			if !ok || !usr.HasRole(role) {
+				sendErr(ctx, w, fmt.Errorf("%w: missing role %s", ErrForbidden, role), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+func TestBootstrapAdmins(t *testing.T) {
+	storage := newMockStorage()
+	for i := 0; i < 2; i++ {
+		err := api.BootstrapAdmins(storage, []string{"admin@gmail.com", "other@gmail.com"})
+		if err != nil {
+			t.Fatalf("failed to bootstrap admins: %v", err)
+		}
+	}
+	for _, email := range []string{"admin@gmail.com", "other@gmail.com"} {
+		roles, err := storage.UserRoles(email)
+		if err != nil {
+			t.Fatalf("failed to get user roles: %v", err)
+		}
+		if len(roles) != 1 || roles[0] != legitima.RoleAdmin {
+			t.Fatalf("expected %s to be an admin, got %v", email, roles)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	storage := newMockStorage()
+	err := storage.SaveUser(legitima.Identity{Email: "jj@gmail.com"})
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	err = storage.SaveRole(legitima.Role{Name: "support", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("failed to save role: %v", err)
+	}
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+	h := api.RequireAuth(key, cfg, storage)(api.RequireRole("support")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	token, err := api.GenerateToken(key, cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	status := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, requestWithToken(token))
+		return w.Code
+	}
+
+	if got := status(); got != http.StatusForbidden {
+		t.Fatalf("expected 403 without the role, got %d", got)
+	}
+	err = storage.GrantRole("jj@gmail.com", "support")
+	if err != nil {
+		t.Fatalf("failed to grant role: %v", err)
+	}
+	if got := status(); got != http.StatusOK {
+		t.Fatalf("expected 200 with the role, got %d", got)
+	}
+	// The stored roles are checked, so revoking a role doesn't wait for the token to expire.
+	err = storage.RevokeRole("jj@gmail.com", "support")
+	if err != nil {
+		t.Fatalf("failed to revoke role: %v", err)
+	}
+	if got := status(); got != http.StatusForbidden {
+		t.Fatalf("expected 403 after revoking the role, got %d", got)
+	}
+}
+
+func TestRoles_Tokens(t *testing.T) {
+	storage := newMockStorage()
+	seedRefreshToken(t, storage, "first", time.Now().Add(time.Hour))
+	err := api.BootstrapAdmins(storage, []string{"jj@gmail.com"})
+	if err != nil {
+		t.Fatalf("failed to bootstrap admins: %v", err)
+	}
+	key := newKey(t)
+	cfg := api.DefaultTokenConfig()
+
+	w := httptest.NewRecorder()
+	api.RefreshHandler(key, cfg, storage).ServeHTTP(w, refreshRequest("first"))
+	var res api.TokenResponse
+	err = json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), key, cfg)
+	if err != nil {
+		t.Fatalf("failed to verify access token: %v", err)
+	}
+	if !token.HasRole(legitima.RoleAdmin) || token.HasRole("support") {
+		t.Fatalf("expected the token to carry the admin role, got %v", token.Claims.Roles)
+	}
+}
+
+// fakeRoles is an in memory api.RoleStorage.
+type fakeRoles struct {
+	mu        sync.Mutex
+	roles     map[string]legitima.Role
+	userRoles map[string][]string
+}
+
+func newFakeRoles() *fakeRoles {
+	return &fakeRoles{
+		roles:     map[string]legitima.Role{},
+		userRoles: map[string][]string{},
+	}
+}
+
+func (s *fakeRoles) SaveRole(role legitima.Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roles[role.Name]; ok {
+		return fmt.Errorf("save role: %w", legitima.ErrAlreadyExists)
+	}
+	s.roles[role.Name] = role
+	return nil
+}
+
+func (s *fakeRoles) Roles() ([]legitima.Role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var roles []legitima.Role
+	for _, role := range s.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (s *fakeRoles) DeleteRole(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roles[name]; !ok {
+		return fmt.Errorf("delete role: %w", legitima.ErrNotFound)
+	}
+	delete(s.roles, name)
+	for email, roles := range s.userRoles {
+		s.userRoles[email] = without(roles, name)
+	}
+	return nil
+}
+
+func (s *fakeRoles) GrantRole(email, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roles[role]; !ok {
+		return fmt.Errorf("grant role: %w", legitima.ErrNotFound)
+	}
+	roles := without(s.userRoles[email], role)
+	roles = append(roles, role)
+	sort.Strings(roles)
+	s.userRoles[email] = roles
+	return nil
+}
+
+func (s *fakeRoles) RevokeRole(email, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roles := without(s.userRoles[email], role)
+	if len(roles) == len(s.userRoles[email]) {
+		return fmt.Errorf("revoke role: %w", legitima.ErrNotFound)
+	}
+	s.userRoles[email] = roles
+	return nil
+}
+
+func (s *fakeRoles) UserRoles(email string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.userRoles[email]...), nil
+}
+
+// without returns the values except the given one.
+func without(values []string, value string) []string {
+	var res []string
+	for _, v := range values {
+		if v != value {
+			res = append(res, v)
+		}
+	}
+	return res
+}
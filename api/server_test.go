@@ -0,0 +1,64 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+)
+
+// usersServer serves a handler to jj@gmail.com, other@gmail.com and admin@gmail.com, the latter being an admin,
+// each one logged in with their own access token. The handler is set by the tests of each feature.
+type usersServer struct {
+	handler http.Handler
+	key     api.Key
+	storage *mockStorage
+	tokens  map[string]string
+}
+
+func newUsersServer(t *testing.T) *usersServer {
+	t.Helper()
+	s := &usersServer{key: newKey(t), storage: newMockStorage(), tokens: map[string]string{}}
+	err := api.BootstrapAdmins(s.storage, []string{"admin@gmail.com"})
+	if err != nil {
+		t.Fatalf("failed to bootstrap admins: %v", err)
+	}
+	for _, email := range []string{"jj@gmail.com", "other@gmail.com", "admin@gmail.com"} {
+		err := s.storage.SaveUser(legitima.Identity{Email: email})
+		if err != nil {
+			t.Fatalf("failed to save user: %v", err)
+		}
+		s.tokens[email], err = api.GenerateToken(s.key, api.DefaultTokenConfig(), email)
+		if err != nil {
+			t.Fatalf("failed to generate token: %v", err)
+		}
+	}
+	return s
+}
+
+// do sends the request as the user with the given email.
+func (s *usersServer) do(method, path, email string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, body)
+	r.Header.Set("Authorization", "Bearer "+s.tokens[email])
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+// post creates a resource as the user with the given email, decoding it into created.
+func (s *usersServer) post(t *testing.T, path, email, body string, created interface{}) {
+	t.Helper()
+	w := s.do(http.MethodPost, path, email, strings.NewReader(body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	err := json.NewDecoder(w.Body).Decode(created)
+	if err != nil {
+		t.Fatalf("failed to decode %s: %v", path, err)
+	}
+}
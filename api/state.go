@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// stateCookie binds the OAuth state to the browser that started the login.
+	stateCookie = "oauth_state"
+	// stateTTL is how long a login attempt can wait for the provider callback.
+	stateTTL = 10 * time.Minute
+)
+
+// State errors
+var (
+	ErrInvalidState = errors.New("invalid state")
+	ErrStateExpired = errors.New("state expired")
+)
+
+// LoginState holds the data of a pending login attempt.
+type LoginState struct {
+	// Provider is the name of the identity provider the user is logging in with.
+	Provider string
+	// Nonce binds the id_token of OpenID Connect providers to the login attempt.
+	Nonce string
+	// CodeVerifier is the PKCE verifier sent on the code exchange.
+	CodeVerifier string
+	// ReturnTo is the validated URL the user goes to after logging in.
+	ReturnTo string
+	// ReturnChallenge is the S256 PKCE challenge of the application at ReturnTo,
+	// whose verifier is required to exchange the login code.
+	ReturnChallenge string
+	ExpiresAt       time.Time
+}
+
+// StateStore keeps the pending login attempts until the provider calls back.
+// Consume must return a given state at most once.
+type StateStore interface {
+	Save(state string, ls LoginState) error
+	Consume(state string) (LoginState, error)
+}
+
+// MemoryStateStore is a StateStore that keeps the states in memory.
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	states map[string]LoginState
+}
+
+// NewMemoryStateStore returns a new MemoryStateStore instance.
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{states: map[string]LoginState{}}
+}
+
+// Save stores the given state.
+func (s *MemoryStateStore) Save(state string, ls LoginState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range s.states {
+		if now.After(v.ExpiresAt) {
+			delete(s.states, k)
+		}
+	}
+	s.states[state] = ls
+	return nil
+}
+
+// Consume removes the given state from the store and returns it.
+func (s *MemoryStateStore) Consume(state string) (LoginState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ls, ok := s.states[state]
+	if !ok {
+		return LoginState{}, ErrInvalidState
+	}
+	delete(s.states, state)
+
+	if time.Now().After(ls.ExpiresAt) {
+		return LoginState{}, ErrStateExpired
+	}
+	return ls, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("reading random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newState creates a new login attempt, saves it and binds it to the browser.
+func newState(w http.ResponseWriter, states StateStore, ls LoginState) (string, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	ls.ExpiresAt = time.Now().Add(stateTTL)
+	err = states.Save(state, ls)
+	if err != nil {
+		return "", fmt.Errorf("saving state: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     callbackURL,
+		MaxAge:   int(stateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return state, nil
+}
+
+// verifyState checks the received state against the browser cookie and consumes it.
+func verifyState(w http.ResponseWriter, r *http.Request, states StateStore, state string) (LoginState, error) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Path:     callbackURL,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		return LoginState{}, ErrInvalidState
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return LoginState{}, ErrInvalidState
+	}
+	return states.Consume(state)
+}
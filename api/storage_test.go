@@ -0,0 +1,74 @@
+package api_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+)
+
+// mockStorage is an in memory api.Storage. It stores the users itself and embeds the fakes
+// of the other features, each one kept next to the tests of its feature.
+type mockStorage struct {
+	mu         sync.Mutex
+	users      map[string]legitima.User
+	identities map[string]legitima.Identity
+	*fakeRefreshTokens
+	*fakeRevocations
+	*fakeAuthorizationCodes
+	*fakeClients
+	*fakeDeviceCodes
+	*fakeRoles
+	*fakeOrganizations
+}
+
+func newMockStorage() *mockStorage {
+	return &mockStorage{
+		users:                  map[string]legitima.User{},
+		identities:             map[string]legitima.Identity{},
+		fakeRefreshTokens:      newFakeRefreshTokens(),
+		fakeRevocations:        newFakeRevocations(),
+		fakeAuthorizationCodes: newFakeAuthorizationCodes(),
+		fakeClients:            newFakeClients(),
+		fakeDeviceCodes:        newFakeDeviceCodes(),
+		fakeRoles:              newFakeRoles(),
+		fakeOrganizations:      newFakeOrganizations(),
+	}
+}
+
+func (s *mockStorage) SaveUser(identity legitima.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if identity.Provider != "" {
+		for email, linked := range s.identities {
+			sameIdentity := linked.Provider == identity.Provider && linked.Subject == identity.Subject
+			if (email == identity.Email) != sameIdentity {
+				return fmt.Errorf("save user: %w", legitima.ErrIdentityConflict)
+			}
+		}
+		s.identities[identity.Email] = identity
+	} else if _, ok := s.identities[identity.Email]; ok {
+		return fmt.Errorf("save user: %w", legitima.ErrIdentityConflict)
+	}
+	s.users[identity.Email] = legitima.User{
+		ID:            identity.Subject,
+		Name:          identity.Name,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Picture:       identity.Picture,
+	}
+	s.joinDomain(identity.Email, time.Now())
+	return nil
+}
+
+func (s *mockStorage) UserByEmail(email string) (*legitima.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usr, ok := s.users[email]
+	if !ok {
+		return nil, fmt.Errorf("user by email: %w", legitima.ErrNotFound)
+	}
+	usr.Roles, _ = s.UserRoles(email)
+	return &usr, nil
+}
@@ -2,34 +2,154 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"golang.org/x/exp/slog"
 )
 
+// Token validation errors
+var (
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+	ErrInvalidAudience  = errors.New("invalid token audience")
+	ErrInvalidIssuer    = errors.New("invalid token issuer")
+	ErrTokenRevoked     = errors.New("token revoked")
+	ErrServiceToken     = errors.New("service tokens have no user")
+	ErrClientToken      = errors.New("tokens issued to clients can't be used here")
+)
+
+// Claims are the claims carried by the tokens issued by legitima.
+// The subject of user tokens is their email, the one of service tokens is the ID of their client.
+type Claims struct {
+	jwt.StandardClaims
+	Email string `json:"email,omitempty"`
+	// ClientID is the client a service token was issued to, or the OAuth client a user authorized.
+	// The tokens of the own login of legitima have none.
+	ClientID string `json:"client_id,omitempty"`
+	// SessionID identifies the login the token was issued for, shared with its refresh tokens.
+	SessionID string `json:"sid,omitempty"`
+	// Scope is the space separated list of scopes granted to the token.
+	// The tokens of the own login of legitima have no scopes.
+	Scope string `json:"scope,omitempty"`
+	// Roles are the roles of the user when the token was issued.
+	Roles []string `json:"roles,omitempty"`
+	// OrgID is the organization the user is acting in, if they chose one.
+	OrgID string `json:"org_id,omitempty"`
+	// Act is the client acting on behalf of the subject of an exchanged token.
+	Act *Actor `json:"act,omitempty"`
+}
+
+// Actor identifies the client acting on behalf of another party, as defined in RFC 8693 4.1.
+// Tokens exchanged more than once keep the previous actors nested.
+type Actor struct {
+	Subject string `json:"sub"`
+	Act     *Actor `json:"act,omitempty"`
+}
+
 // Token is the token decoded from the Authorization header.
+// User tokens have the email of their user, service tokens the ID of their client instead.
 type Token struct {
-	Email string `json:"email"`
+	Email    string `json:"email,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// OrgID is the active organization of the user.
+	OrgID  string `json:"org_id,omitempty"`
+	Claims Claims `json:"claims"`
 }
 
-// JWTSecretKey TODO: improve this
-// JWTSecretKey is the secret key used to sign the JWT.
-const JWTSecretKey = "secret"
+// IsService tells if the token was issued to a client on its own behalf, with the client credentials grant.
+func (t Token) IsService() bool {
+	return t.Email == ""
+}
+
+// IsOwnLogin tells if the token was issued to a user logged in legitima itself, not to an OAuth client.
+func (t Token) IsOwnLogin() bool {
+	return t.Email != "" && t.ClientID == ""
+}
+
+// Scopes returns the scopes granted to the token.
+func (t Token) Scopes() []string {
+	return strings.Fields(t.Claims.Scope)
+}
+
+// HasScope tells if the given scope was granted to the token.
+func (t Token) HasScope(scope string) bool {
+	return hasScope(t.Claims.Scope, scope)
+}
 
-// GenerateToken TODO: (jojo) improve this
-// GenerateToken generates a JWT token for the given email.
-func GenerateToken(email string) (string, error) {
-	claims := jwt.MapClaims{
-		"email": email,
+// HasRole tells if the user of the token had the given role when the token was issued.
+func (t Token) HasRole(role string) bool {
+	return contains(t.Claims.Roles, role)
+}
+
+// TokenConfig configures the tokens issued and accepted by legitima.
+type TokenConfig struct {
+	// Issuer is the iss claim of the issued tokens.
+	Issuer string
+	// Audience is the aud claim of the issued tokens.
+	Audience string
+	// TTL is how long an issued token is valid.
+	TTL time.Duration
+	// RefreshTTL is how long an issued refresh token is valid.
+	RefreshTTL time.Duration
+	// Leeway is the clock skew tolerated when validating the time based claims.
+	Leeway time.Duration
+	// Revocations, if set, is consulted to reject revoked tokens.
+	Revocations RevocationChecker
+}
+
+// DefaultTokenConfig returns the TokenConfig used when nothing else is configured.
+func DefaultTokenConfig() TokenConfig {
+	return TokenConfig{
+		Issuer:     "legitima",
+		Audience:   "legitima",
+		TTL:        time.Hour,
+		RefreshTTL: 30 * 24 * time.Hour,
+		Leeway:     time.Minute,
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(JWTSecretKey))
+}
+
+// GenerateToken generates a JWT token for the given email, granted the given scopes.
+func GenerateToken(signer Signer, cfg TokenConfig, email string, scopes ...string) (string, error) {
+	return GenerateOrgToken(signer, cfg, email, "", scopes...)
+}
+
+// GenerateOrgToken generates a JWT token for the given email acting in the organization with the given ID,
+// granted the given scopes.
+func GenerateOrgToken(signer Signer, cfg TokenConfig, email, orgID string, scopes ...string) (string, error) {
+	return generateToken(signer, cfg, Claims{Email: email, OrgID: orgID, Scope: strings.Join(scopes, " ")})
+}
+
+// generateServiceToken generates a JWT token for the client with the given ID, granted the given space separated scope.
+func generateServiceToken(signer Signer, cfg TokenConfig, clientID, scope string) (string, error) {
+	return generateToken(signer, cfg, Claims{ClientID: clientID, Scope: scope})
+}
+
+// generateToken fills the registered claims and signs the token.
+func generateToken(signer Signer, cfg TokenConfig, claims Claims) (string, error) {
+	now := time.Now()
+	subject := claims.Email
+	if subject == "" {
+		subject = claims.ClientID
+	}
+	claims.StandardClaims = jwt.StandardClaims{
+		Id:        uuid.New().String(),
+		Issuer:    cfg.Issuer,
+		Subject:   subject,
+		Audience:  cfg.Audience,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(cfg.TTL).Unix(),
+	}
+	return signer.Sign(claims)
 }
 
 // TokenFromHeader parses the token from the Authorization header and validates it.
-func TokenFromHeader(r *http.Request) (*Token, error) {
+func TokenFromHeader(r *http.Request, verifier Verifier, cfg TokenConfig) (*Token, error) {
 	tokenHeader := r.Header.Get("Authorization")
 	if tokenHeader == "" {
 		slog.Debug("no authorization header")
@@ -42,31 +162,77 @@ func TokenFromHeader(r *http.Request) (*Token, error) {
 		return nil, errors.New("invalid authorization header")
 	}
 
-	token, err := jwt.Parse(tokenParts[1], func(token *jwt.Token) (interface{}, error) {
-		if token.Method != jwt.SigningMethodHS256 {
-			slog.Debug("invalid signing method")
-			return nil, errors.New("invalid signing method")
-		}
-		return []byte(JWTSecretKey), nil
-	})
+	return parseToken(tokenParts[1], verifier, cfg)
+}
+
+// parseToken verifies the signature of the token, validates its claims and checks its revocation.
+func parseToken(tokenString string, verifier Verifier, cfg TokenConfig) (*Token, error) {
+	// The registered claims are validated below, so the leeway can be applied.
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	var claims Claims
+	token, err := parser.ParseWithClaims(tokenString, &claims, verifier.VerificationKey)
 	if err != nil {
 		slog.Debug("error parsing token", "error", err.Error())
+		var vErr *jwt.ValidationError
+		if errors.As(err, &vErr) && vErr.Inner != nil {
+			return nil, vErr.Inner
+		}
 		return nil, err
 	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
+	if !token.Valid {
 		slog.Debug("invalid token", "token", token)
 		return nil, errors.New("invalid token")
 	}
 
-	email, ok := claims["email"].(string)
-	if !ok {
+	err = validateClaims(claims, cfg, time.Now())
+	if err != nil {
+		slog.Debug("invalid claims", "error", err.Error())
+		return nil, err
+	}
+
+	if claims.Email == "" && (claims.ClientID == "" || claims.Subject != claims.ClientID) {
 		slog.Debug("invalid email claim")
 		return nil, errors.New("invalid email claim")
 	}
+
+	if cfg.Revocations != nil {
+		revoked, err := cfg.Revocations.IsTokenRevoked(claims.Id, claims.Email, time.Unix(claims.IssuedAt, 0))
+		if err != nil {
+			return nil, fmt.Errorf("checking token revocation: %w", err)
+		}
+		if revoked {
+			slog.Debug("revoked token", "jti", claims.Id)
+			return nil, ErrTokenRevoked
+		}
+	}
+
 	var t Token
-	t.Email = email
+	t.Email = claims.Email
+	t.ClientID = claims.ClientID
+	t.OrgID = claims.OrgID
+	t.Claims = claims
 
 	return &t, nil
 }
+
+func validateClaims(claims Claims, cfg TokenConfig, now time.Time) error {
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("%w: missing exp claim", ErrTokenExpired)
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(cfg.Leeway)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(cfg.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+	if claims.IssuedAt != 0 && now.Add(cfg.Leeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return ErrTokenNotYetValid
+	}
+	if cfg.Issuer != "" && claims.Issuer != cfg.Issuer {
+		return ErrInvalidIssuer
+	}
+	if cfg.Audience != "" && claims.Audience != cfg.Audience {
+		return ErrInvalidAudience
+	}
+	return nil
+}
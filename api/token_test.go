@@ -1,27 +1,180 @@
 package api_test
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/birdie-ai/legitima/api"
+	"github.com/golang-jwt/jwt"
 )
 
+func requestWithToken(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func newKey(t *testing.T) *api.HMACKey {
+	t.Helper()
+	key, err := api.NewHMACKey([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	return key
+}
+
 // TestToken is responsible for testing the token generation and validation in the same flow.
 func TestToken(t *testing.T) {
 	email := "jj@gmail.com"
-	token, err := api.GenerateToken(email)
+	cfg := api.DefaultTokenConfig()
+	key := newKey(t)
+	token, err := api.GenerateToken(key, cfg, email)
 	if err != nil {
 		t.Fatalf("failed to generate token: %v", err)
 	}
-	r := httptest.NewRequest(http.MethodGet, "/profile", nil)
-	r.Header.Set("Authorization", "Bearer "+token)
-	tokenFromHeader, err := api.TokenFromHeader(r)
+	tokenFromHeader, err := api.TokenFromHeader(requestWithToken(token), key, cfg)
 	if err != nil {
 		t.Fatalf("failed to get token from header: %v", err)
 	}
 	if tokenFromHeader.Email != email {
 		t.Fatalf("expected email %s, got %s", email, tokenFromHeader.Email)
 	}
+
+	claims := tokenFromHeader.Claims
+	if claims.Issuer != cfg.Issuer || claims.Audience != cfg.Audience || claims.Subject != email {
+		t.Fatalf("unexpected registered claims: %+v", claims.StandardClaims)
+	}
+	if claims.Id == "" {
+		t.Fatal("expected jti claim")
+	}
+	if got := time.Unix(claims.ExpiresAt, 0).Sub(time.Unix(claims.IssuedAt, 0)); got != cfg.TTL {
+		t.Fatalf("expected lifetime %v, got %v", cfg.TTL, got)
+	}
+}
+
+func TestToken_Scopes(t *testing.T) {
+	cfg := api.DefaultTokenConfig()
+	key := newKey(t)
+	token, err := api.GenerateToken(key, cfg, "jj@gmail.com", "jobs:read", "openid")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	parsed, err := api.TokenFromHeader(requestWithToken(token), key, cfg)
+	if err != nil {
+		t.Fatalf("failed to get token from header: %v", err)
+	}
+	if parsed.Claims.Scope != "jobs:read openid" || len(parsed.Scopes()) != 2 {
+		t.Fatalf("unexpected scopes: %q", parsed.Claims.Scope)
+	}
+	if !parsed.HasScope("jobs:read") || parsed.HasScope("jobs:write") || parsed.HasScope("jobs") {
+		t.Fatalf("unexpected scope checks for %q", parsed.Claims.Scope)
+	}
+
+	token, err = api.GenerateToken(key, cfg, "jj@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	parsed, err = api.TokenFromHeader(requestWithToken(token), key, cfg)
+	if err != nil {
+		t.Fatalf("failed to get token from header: %v", err)
+	}
+	if parsed.HasScope("openid") || len(parsed.Scopes()) != 0 {
+		t.Fatalf("expected no scopes, got %q", parsed.Claims.Scope)
+	}
+}
+
+func TestGenerateOrgToken(t *testing.T) {
+	cfg := api.DefaultTokenConfig()
+	key := newKey(t)
+	token, err := api.GenerateOrgToken(key, cfg, "jj@gmail.com", "acme", "jobs:read")
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	parsed, err := api.TokenFromHeader(requestWithToken(token), key, cfg)
+	if err != nil {
+		t.Fatalf("failed to get token from header: %v", err)
+	}
+	if parsed.OrgID != "acme" || parsed.Claims.OrgID != "acme" || !parsed.HasScope("jobs:read") {
+		t.Fatalf("expected a token in acme, got %+v", parsed)
+	}
+}
+
+func TestToken_Validation(t *testing.T) {
+	cfg := api.DefaultTokenConfig()
+	cfg.Leeway = 30 * time.Second
+	now := time.Now()
+	key := newKey(t)
+
+	sign := func(claims api.Claims) string {
+		token, err := key.Sign(claims)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return token
+	}
+	claims := func(mutate func(c *api.Claims)) api.Claims {
+		c := api.Claims{
+			StandardClaims: jwt.StandardClaims{
+				Issuer:    cfg.Issuer,
+				Audience:  cfg.Audience,
+				IssuedAt:  now.Unix(),
+				NotBefore: now.Unix(),
+				ExpiresAt: now.Add(time.Hour).Unix(),
+			},
+			Email: "jj@gmail.com",
+		}
+		mutate(&c)
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		claims  api.Claims
+		wantErr error
+	}{
+		{
+			name:    "expired",
+			claims:  claims(func(c *api.Claims) { c.ExpiresAt = now.Add(-time.Minute).Unix() }),
+			wantErr: api.ErrTokenExpired,
+		},
+		{
+			name:    "missing exp",
+			claims:  claims(func(c *api.Claims) { c.ExpiresAt = 0 }),
+			wantErr: api.ErrTokenExpired,
+		},
+		{
+			name:   "expired within leeway",
+			claims: claims(func(c *api.Claims) { c.ExpiresAt = now.Add(-10 * time.Second).Unix() }),
+		},
+		{
+			name:    "not yet valid",
+			claims:  claims(func(c *api.Claims) { c.NotBefore = now.Add(time.Minute).Unix() }),
+			wantErr: api.ErrTokenNotYetValid,
+		},
+		{
+			name:   "not yet valid within leeway",
+			claims: claims(func(c *api.Claims) { c.NotBefore = now.Add(10 * time.Second).Unix() }),
+		},
+		{
+			name:    "wrong audience",
+			claims:  claims(func(c *api.Claims) { c.Audience = "other" }),
+			wantErr: api.ErrInvalidAudience,
+		},
+		{
+			name:    "wrong issuer",
+			claims:  claims(func(c *api.Claims) { c.Issuer = "other" }),
+			wantErr: api.ErrInvalidIssuer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := api.TokenFromHeader(requestWithToken(sign(tt.claims)), key, cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
 }
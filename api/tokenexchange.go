@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+)
+
+const (
+	// grantTokenExchange is the grant type of the token exchange.
+	grantTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// tokenTypeAccessToken is the only token type accepted and issued by the token exchange.
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenExchangeGrant trades the token of a user for a narrower one, so the client can call another service
+// on behalf of the user, as defined in RFC 8693. The client is recorded as the actor of the new token.
+// The subject token is either a legitima token or one previously exchanged for the client itself,
+// and the new token is always for the audience the client names.
+func tokenExchangeGrant(w http.ResponseWriter, r *http.Request, client *legitima.Client, key Key, tokenCfg TokenConfig, clients ClientStorage) {
+	ctx := r.Context()
+	log := slog.FromCtx(ctx)
+
+	if client.Public() {
+		sendOAuthErr(ctx, w, "unauthorized_client", errors.New("public clients can't exchange tokens"), http.StatusBadRequest)
+		return
+	}
+	subjectToken := r.PostFormValue("subject_token")
+	switch {
+	case subjectToken == "":
+		sendOAuthErr(ctx, w, "invalid_request", errors.New("missing subject token"), http.StatusBadRequest)
+		return
+	case r.PostFormValue("subject_token_type") != tokenTypeAccessToken:
+		sendOAuthErr(ctx, w, "invalid_request", errors.New("unsupported subject token type"), http.StatusBadRequest)
+		return
+	case r.PostFormValue("requested_token_type") != "" && r.PostFormValue("requested_token_type") != tokenTypeAccessToken:
+		sendOAuthErr(ctx, w, "invalid_request", errors.New("unsupported requested token type"), http.StatusBadRequest)
+		return
+	case r.PostFormValue("actor_token") != "":
+		sendOAuthErr(ctx, w, "invalid_request", errors.New("actor tokens are not supported, the client is the actor"), http.StatusBadRequest)
+		return
+	}
+
+	subject, err := parseToken(subjectToken, key, tokenCfg)
+	if err != nil {
+		subjectCfg := tokenCfg
+		subjectCfg.Audience = client.ID
+		subject, err = parseToken(subjectToken, key, subjectCfg)
+	}
+	if err != nil {
+		sendOAuthErr(ctx, w, "invalid_grant", fmt.Errorf("invalid subject token: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	// The new token can't have more scopes than the subject token, nor than the client.
+	// A subject token without scope can only be exchanged for a token without scope.
+	scope := strings.Join(strings.Fields(r.PostFormValue("scope")), " ")
+	if scope == "" {
+		scope = subject.Claims.Scope
+	}
+	subjectScopes := legitima.Client{Scopes: subject.Scopes()}
+	if !client.AllowsScope(scope) || !subjectScopes.AllowsScope(scope) {
+		sendOAuthErr(ctx, w, "invalid_scope", fmt.Errorf("scope %q not allowed", scope), http.StatusBadRequest)
+		return
+	}
+
+	// The audience is the client ID of the service the token is for. It is required, so exchanged
+	// tokens are never accepted by legitima itself.
+	audiences := r.PostForm["audience"]
+	if len(audiences) != 1 {
+		sendOAuthErr(ctx, w, "invalid_target", errors.New("exactly one audience is required"), http.StatusBadRequest)
+		return
+	}
+	_, err = clients.ClientByID(audiences[0])
+	if errors.Is(err, legitima.ErrNotFound) {
+		sendOAuthErr(ctx, w, "invalid_target", fmt.Errorf("unknown audience %q", audiences[0]), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", err, http.StatusInternalServerError)
+		return
+	}
+	tokenCfg.Audience = audiences[0]
+
+	// The new token can't outlive the subject token, which may be accepted within the leeway after expiring.
+	remaining := time.Until(time.Unix(subject.Claims.ExpiresAt, 0)).Truncate(time.Second)
+	if remaining <= 0 {
+		sendOAuthErr(ctx, w, "invalid_grant", errors.New("subject token expired"), http.StatusBadRequest)
+		return
+	}
+	if remaining < tokenCfg.TTL {
+		tokenCfg.TTL = remaining
+	}
+
+	accessToken, err := generateToken(key, tokenCfg, Claims{
+		Email:     subject.Email,
+		ClientID:  subject.ClientID,
+		SessionID: subject.Claims.SessionID,
+		Scope:     scope,
+		Roles:     subject.Claims.Roles,
+		OrgID:     subject.OrgID,
+		Act:       &Actor{Subject: client.ID, Act: subject.Claims.Act},
+	})
+	if err != nil {
+		sendOAuthErr(ctx, w, "server_error", fmt.Errorf("generating access token: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("token exchanged", "client_id", client.ID, "subject", subject.Claims.Subject, "audience", tokenCfg.Audience)
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(ctx, w, http.StatusOK, TokenResponse{
+		AccessToken:     accessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(tokenCfg.TTL.Seconds()),
+		Scope:           scope,
+		IssuedTokenType: tokenTypeAccessToken,
+	})
+}
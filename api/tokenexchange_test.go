@@ -0,0 +1,223 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima/api"
+)
+
+// exchange trades the subject token as the gateway, returning the issued token.
+func (s *authorizeServer) exchange(t *testing.T, subject string, form url.Values) api.TokenResponse {
+	t.Helper()
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:token-exchange")
+	form.Set("subject_token", subject)
+	form.Set("subject_token_type", "urn:ietf:params:oauth:token-type:access_token")
+	w := s.token(form, "gateway", "gateway-secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res api.TokenResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	return res
+}
+
+// subjectToken returns a token of the user for legitima, granted the given scopes.
+func (s *authorizeServer) subjectToken(t *testing.T, cfg api.TokenConfig, scopes ...string) string {
+	t.Helper()
+	token, err := api.GenerateToken(s.key, cfg, "jj@example.com", scopes...)
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	return token
+}
+
+func TestTokenExchange(t *testing.T) {
+	s := newAuthorizeServer(t)
+	subject := s.subjectToken(t, api.DefaultTokenConfig(), "jobs:read", "jobs:write")
+	res := s.exchange(t, subject, url.Values{"audience": {"worker"}, "scope": {"jobs:read"}})
+	if res.RefreshToken != "" || res.Scope != "jobs:read" || res.IssuedTokenType != "urn:ietf:params:oauth:token-type:access_token" {
+		t.Fatalf("unexpected token response: %+v", res)
+	}
+	if res.ExpiresIn <= 0 || res.ExpiresIn > 3600 {
+		t.Fatalf("expected the token to expire with the subject token, got %d", res.ExpiresIn)
+	}
+
+	if _, err := api.TokenFromHeader(requestWithToken(res.AccessToken), s.key, api.DefaultTokenConfig()); err == nil {
+		t.Fatal("expected the exchanged token to be rejected outside of its audience")
+	}
+	cfg := api.DefaultTokenConfig()
+	cfg.Audience = "worker"
+	token, err := api.TokenFromHeader(requestWithToken(res.AccessToken), s.key, cfg)
+	if err != nil {
+		t.Fatalf("failed to verify exchanged token: %v", err)
+	}
+	if token.Email != "jj@example.com" || token.Claims.Scope != "jobs:read" {
+		t.Fatalf("expected a token of the user narrowed to jobs:read, got %+v", token)
+	}
+	if token.Claims.Act == nil || token.Claims.Act.Subject != "gateway" || token.Claims.Act.Act != nil {
+		t.Fatalf("expected the gateway as actor, got %+v", token.Claims.Act)
+	}
+}
+
+func TestTokenExchange_Delegation(t *testing.T) {
+	s := newAuthorizeServer(t)
+	subject := s.subjectToken(t, api.DefaultTokenConfig(), "jobs:read", "jobs:write")
+	// The gateway gets a token for itself first, then exchanges it for the worker.
+	first := s.exchange(t, subject, url.Values{"audience": {"gateway"}, "scope": {"jobs:read"}})
+	second := s.exchange(t, first.AccessToken, url.Values{"audience": {"worker"}})
+
+	cfg := api.DefaultTokenConfig()
+	cfg.Audience = "worker"
+	token, err := api.TokenFromHeader(requestWithToken(second.AccessToken), s.key, cfg)
+	if err != nil {
+		t.Fatalf("failed to verify exchanged token: %v", err)
+	}
+	act := token.Claims.Act
+	if act == nil || act.Subject != "gateway" || act.Act == nil || act.Act.Subject != "gateway" {
+		t.Fatalf("expected the previous actor to be nested, got %+v", act)
+	}
+
+	form := url.Values{
+		"grant_type":         {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"subject_token":      {first.AccessToken},
+		"subject_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"audience":           {"worker"},
+		"scope":              {"jobs:write"},
+	}
+	w := s.token(form, "gateway", "gateway-secret")
+	if got := oauthErrCode(t, w); got != "invalid_scope" {
+		t.Fatalf("expected the scope of the subject token to not be widened, got %q", got)
+	}
+}
+
+func TestTokenExchange_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		form     url.Values
+		user     string
+		password string
+		status   int
+		code     string
+	}{
+		{
+			name: "invalid subject token",
+			form: url.Values{"subject_token": {"invalid"}},
+			user: "gateway", password: "gateway-secret",
+			status: http.StatusBadRequest, code: "invalid_grant",
+		},
+		{
+			name: "missing subject token",
+			form: url.Values{"subject_token": {""}},
+			user: "gateway", password: "gateway-secret",
+			status: http.StatusBadRequest, code: "invalid_request",
+		},
+		{
+			name: "unsupported subject token type",
+			form: url.Values{"subject_token_type": {"urn:ietf:params:oauth:token-type:id_token"}},
+			user: "gateway", password: "gateway-secret",
+			status: http.StatusBadRequest, code: "invalid_request",
+		},
+		{
+			name: "missing audience",
+			form: url.Values{"audience": {}},
+			user: "gateway", password: "gateway-secret",
+			status: http.StatusBadRequest, code: "invalid_target",
+		},
+		{
+			name: "many audiences",
+			form: url.Values{"audience": {"worker", "gateway"}},
+			user: "gateway", password: "gateway-secret",
+			status: http.StatusBadRequest, code: "invalid_target",
+		},
+		{
+			name: "scope of a subject without scopes",
+			form: url.Values{"scope": {"jobs:read"}},
+			user: "gateway", password: "gateway-secret",
+			status: http.StatusBadRequest, code: "invalid_scope",
+		},
+		{
+			name: "unknown audience",
+			form: url.Values{"audience": {"unknown"}},
+			user: "gateway", password: "gateway-secret",
+			status: http.StatusBadRequest, code: "invalid_target",
+		},
+		{
+			name: "scope not allowed",
+			form: url.Values{"scope": {"jobs:read admin"}},
+			user: "gateway", password: "gateway-secret",
+			status: http.StatusBadRequest, code: "invalid_scope",
+		},
+		{
+			name:   "public client",
+			form:   url.Values{"client_id": {"spa"}},
+			status: http.StatusBadRequest, code: "unauthorized_client",
+		},
+		{
+			name: "grant not allowed",
+			user: "worker", password: "worker-secret",
+			status: http.StatusBadRequest, code: "unauthorized_client",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAuthorizeServer(t)
+			form := url.Values{
+				"grant_type":         {"urn:ietf:params:oauth:grant-type:token-exchange"},
+				"subject_token":      {s.accessToken},
+				"subject_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+				"audience":           {"worker"},
+			}
+			for k, v := range tt.form {
+				form[k] = v
+			}
+			w := s.token(form, tt.user, tt.password)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if got := oauthErrCode(t, w); got != tt.code {
+				t.Fatalf("expected %q, got %q", tt.code, got)
+			}
+		})
+	}
+}
+
+func TestTokenExchange_SubjectOfAnotherService(t *testing.T) {
+	s := newAuthorizeServer(t)
+	cfg := api.DefaultTokenConfig()
+	cfg.Audience = "worker"
+	// A token for the worker can't be exchanged by the gateway.
+	form := url.Values{
+		"grant_type":         {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"subject_token":      {s.subjectToken(t, cfg, "jobs:read")},
+		"subject_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"audience":           {"worker"},
+	}
+	w := s.token(form, "gateway", "gateway-secret")
+	if got := oauthErrCode(t, w); got != "invalid_grant" {
+		t.Fatalf("expected invalid_grant, got %q", got)
+	}
+}
+
+func TestTokenExchange_ExpiredWithinLeeway(t *testing.T) {
+	s := newAuthorizeServer(t)
+	cfg := api.DefaultTokenConfig()
+	cfg.TTL = -time.Second
+	form := url.Values{
+		"grant_type":         {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"subject_token":      {s.subjectToken(t, cfg, "jobs:read")},
+		"subject_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"audience":           {"worker"},
+	}
+	w := s.token(form, "gateway", "gateway-secret")
+	if got := oauthErrCode(t, w); got != "invalid_grant" {
+		t.Fatalf("expected invalid_grant for a subject token expired within the leeway, got %q", got)
+	}
+}
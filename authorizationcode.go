@@ -0,0 +1,21 @@
+package legitima
+
+import "time"
+
+// AuthorizationCode is an OAuth authorization code issued to a client.
+// Only the hash of the code is stored.
+type AuthorizationCode struct {
+	Hash        string `json:"-"`
+	ClientID    string `json:"client_id"`
+	Email       string `json:"email"`
+	RedirectURI string `json:"redirect_uri"`
+	// RedirectURIProvided tells if the redirect URI was in the authorization request,
+	// in which case the token request must repeat it (RFC 6749 4.1.3).
+	RedirectURIProvided bool   `json:"redirect_uri_provided"`
+	Scope               string `json:"scope"`
+	// CodeChallenge is the S256 PKCE challenge, empty if the client sent none.
+	CodeChallenge string `json:"code_challenge"`
+	// Nonce is included in the id_token issued for the code.
+	Nonce     string    `json:"nonce"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,58 @@
+package legitima
+
+import (
+	"strings"
+	"time"
+)
+
+// Client is an application using legitima as its OAuth authorization server.
+type Client struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// SecretHash is the hash of the client secret, empty for public clients.
+	SecretHash   string   `json:"-"`
+	RedirectURIs []string `json:"redirect_uris"`
+	// GrantTypes are the OAuth grant types the client can use.
+	GrantTypes []string `json:"grant_types"`
+	// Scopes are the scopes the client can request.
+	Scopes []string `json:"scopes"`
+	// TokenTTL shortens how long the access tokens issued to the client are valid, if not zero.
+	// Longer TTLs are capped to the TTL of the service.
+	TokenTTL  time.Duration `json:"-"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// Public tells if the client has no secret, like browser and mobile apps.
+// Public clients must use PKCE.
+func (c Client) Public() bool {
+	return c.SecretHash == ""
+}
+
+// HasRedirectURI tells if the URI is one of the registered redirect URIs of the client.
+func (c Client) HasRedirectURI(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+// AllowsGrantType tells if the client can use the given grant type.
+func (c Client) AllowsGrantType(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+// AllowsScope tells if the client can request every scope of the space separated list.
+func (c Client) AllowsScope(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !contains(c.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
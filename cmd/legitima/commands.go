@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/birdie-ai/golibs/slog"
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/api"
+	"github.com/birdie-ai/legitima/mysql"
+)
+
+const usage = `usage: legitima [command]
+
+Without a command the service is started.
+
+Commands:
+  keys rotate [--force]        activates the next signing key and generates a new one
+  clients list                 lists the OAuth clients
+  clients create [flags]       registers an OAuth client, printing its secret
+  clients rotate-secret <id>   replaces the secret of an OAuth client
+  clients delete <id>          deletes an OAuth client
+  roles list                   lists the roles
+  roles create <name> [desc]   creates a role
+  roles delete <name>          deletes a role, revoking it from its users
+  roles grant <email> <role>   grants a role to a user
+  roles revoke <email> <role>  revokes a role from a user
+
+Run legitima clients create -h for the client flags.
+`
+
+func runCommand(cfg *Config, args []string) {
+	switch {
+	case len(args) >= 2 && args[0] == "keys" && args[1] == "rotate":
+		rotateKeys(cfg, args[2:])
+	case len(args) == 2 && args[0] == "clients" && args[1] == "list":
+		listClients(cfg)
+	case len(args) >= 2 && args[0] == "clients" && args[1] == "create":
+		createClient(cfg, args[2:])
+	case len(args) == 3 && args[0] == "clients" && args[1] == "rotate-secret":
+		rotateClientSecret(cfg, args[2])
+	case len(args) == 3 && args[0] == "clients" && args[1] == "delete":
+		deleteClient(cfg, args[2])
+	case len(args) == 2 && args[0] == "roles" && args[1] == "list":
+		listRoles(cfg)
+	case (len(args) == 3 || len(args) == 4) && args[0] == "roles" && args[1] == "create":
+		createRole(cfg, args[2:])
+	case len(args) == 3 && args[0] == "roles" && args[1] == "delete":
+		deleteRole(cfg, args[2])
+	case len(args) == 4 && args[0] == "roles" && args[1] == "grant":
+		grantRole(cfg, args[2], args[3])
+	case len(args) == 4 && args[0] == "roles" && args[1] == "revoke":
+		revokeRole(cfg, args[2], args[3])
+	case args[0] == "help" || args[0] == "--help" || args[0] == "-h":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func rotateKeys(cfg *Config, args []string) {
+	flags := flag.NewFlagSet("keys rotate", flag.ExitOnError)
+	force := flags.Bool("force", false, "rotate even if the tokens signed by the retiring key are still valid")
+	_ = flags.Parse(args)
+
+	ttl, err := time.ParseDuration(cfg.TokenTTL)
+	if err != nil {
+		slog.Fatal("invalid token ttl", "error", err.Error())
+	}
+	storage := mysql.NewStorage(openDB(cfg))
+	keyRing, err := api.NewKeyRing(storage, cfg.KeyAlgorithm)
+	if err != nil {
+		slog.Fatal("failed to load signing keys", "error", err.Error())
+	}
+	// Rotating retires the retiring key, whose tokens are valid for the TTL and leeway after it stopped signing.
+	minAge := ttl + api.DefaultTokenConfig().Leeway
+	if age := time.Since(keyRing.ActivatedAt()); age < minAge && !*force {
+		slog.Fatal("active signing key is too recent, use --force to invalidate the tokens of the retiring key", "age", age, "min_age", minAge)
+	}
+	err = keyRing.Rotate()
+	if err != nil {
+		slog.Fatal("failed to rotate signing keys", "error", err.Error())
+	}
+	fmt.Printf("active signing key: %s\n", keyRing.ActiveID())
+}
+
+func listClients(cfg *Config) {
+	storage := mysql.NewStorage(openDB(cfg))
+	clients, err := storage.Clients()
+	if err != nil {
+		slog.Fatal("failed to list clients", "error", err.Error())
+	}
+	res := []api.ClientResponse{}
+	for _, client := range clients {
+		res = append(res, api.NewClientResponse(client, ""))
+	}
+	printJSON(res)
+}
+
+func createClient(cfg *Config, args []string) {
+	flags := flag.NewFlagSet("clients create", flag.ExitOnError)
+	id := flags.String("id", "", "client id, generated when empty")
+	name := flags.String("name", "", "name shown to the users")
+	public := flags.Bool("public", false, "create a public client, without secret, for browser and mobile apps")
+	redirectURIs := flags.String("redirect-uris", "", "comma separated list of redirect URIs")
+	grantTypes := flags.String("grant-types", "", "comma separated list of grant types, authorization_code by default")
+	scopes := flags.String("scopes", "", "comma separated list of scopes, openid, email and profile by default")
+	tokenTTL := flags.Duration("token-ttl", 0, "TTL of the access tokens issued to the client, the service TTL by default")
+	_ = flags.Parse(args)
+
+	storage := mysql.NewStorage(openDB(cfg))
+	client, secret, err := api.CreateClient(storage, api.ClientConfig{
+		ID:           *id,
+		Name:         *name,
+		Public:       *public,
+		RedirectURIs: splitList(*redirectURIs),
+		GrantTypes:   splitList(*grantTypes),
+		Scopes:       splitList(*scopes),
+		TokenTTL:     int64(*tokenTTL / time.Second),
+	})
+	if err != nil {
+		slog.Fatal("failed to create client", "error", err.Error())
+	}
+	printJSON(api.NewClientResponse(*client, secret))
+}
+
+func rotateClientSecret(cfg *Config, id string) {
+	storage := mysql.NewStorage(openDB(cfg))
+	secret, err := api.RotateClientSecret(storage, id)
+	if err != nil {
+		slog.Fatal("failed to rotate client secret", "error", err.Error())
+	}
+	fmt.Printf("client_secret: %s\n", secret)
+}
+
+func deleteClient(cfg *Config, id string) {
+	storage := mysql.NewStorage(openDB(cfg))
+	err := storage.DeleteClient(id)
+	if err != nil {
+		slog.Fatal("failed to delete client", "error", err.Error())
+	}
+	fmt.Printf("deleted client: %s\n", id)
+}
+
+func listRoles(cfg *Config) {
+	storage := mysql.NewStorage(openDB(cfg))
+	roles, err := storage.Roles()
+	if err != nil {
+		slog.Fatal("failed to list roles", "error", err.Error())
+	}
+	if roles == nil {
+		roles = []legitima.Role{}
+	}
+	printJSON(roles)
+}
+
+// createRole creates the role named by the first argument, described by the optional second one.
+func createRole(cfg *Config, args []string) {
+	name, description := args[0], ""
+	if len(args) > 1 {
+		description = args[1]
+	}
+	storage := mysql.NewStorage(openDB(cfg))
+	err := storage.SaveRole(legitima.Role{Name: name, Description: description, CreatedAt: time.Now()})
+	if err != nil {
+		slog.Fatal("failed to create role", "error", err.Error())
+	}
+	fmt.Printf("created role: %s\n", name)
+}
+
+func deleteRole(cfg *Config, name string) {
+	storage := mysql.NewStorage(openDB(cfg))
+	err := storage.DeleteRole(name)
+	if err != nil {
+		slog.Fatal("failed to delete role", "error", err.Error())
+	}
+	fmt.Printf("deleted role: %s\n", name)
+}
+
+// grantRole grants the role to the user, whose tokens carry it once refreshed.
+func grantRole(cfg *Config, email, role string) {
+	storage := mysql.NewStorage(openDB(cfg))
+	err := storage.GrantRole(email, role)
+	if err != nil {
+		slog.Fatal("failed to grant role", "error", err.Error())
+	}
+	fmt.Printf("granted role %s to %s\n", role, email)
+}
+
+func revokeRole(cfg *Config, email, role string) {
+	storage := mysql.NewStorage(openDB(cfg))
+	err := storage.RevokeRole(email, role)
+	if err != nil {
+		slog.Fatal("failed to revoke role", "error", err.Error())
+	}
+	fmt.Printf("revoked role %s from %s\n", role, email)
+}
+
+func printJSON(v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		slog.Fatal("failed to encode output", "error", err.Error())
+	}
+	fmt.Println(string(b))
+}
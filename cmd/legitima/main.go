@@ -2,25 +2,67 @@
 // For details on how to configure it just run:
 //
 //	legitima --help
+//
+// The signing keys stored in the database can be rotated with:
+//
+//	legitima keys rotate
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/birdie-ai/golibs/slog"
 	"github.com/birdie-ai/legitima/api"
 	"github.com/birdie-ai/legitima/mysql"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/github"
 )
 
 // Config holds the configuration for the service.
 type Config struct {
-	ClientID     string
-	ClientSecret string
-	PORT         string
+	ClientID      string
+	ClientSecret  string
+	PORT          string
+	TokenIssuer   string
+	TokenAudience string
+	TokenTTL      string
+	RefreshTTL    string
+	JWTSecret     string
+	JWTSecretFile string
+	KeyAlgorithm  string
+	KeyRotation   string
+	MySQLURL      string
+	// AdminEmails is a comma separated list of the emails granted the admin role on startup.
+	AdminEmails string
+	// IntrospectionClients is a comma separated list of client_id:client_secret pairs.
+	IntrospectionClients string
+	// GitHubClientID and GitHubClientSecret enable the GitHub login when set.
+	GitHubClientID     string
+	GitHubClientSecret string
+	// AllowedEmailDomains is a comma separated list of the email domains whose users can log in with any provider.
+	AllowedEmailDomains string
+	// GoogleHostedDomains is a comma separated list of the Google Workspace domains whose users can log in.
+	GoogleHostedDomains string
+	// MicrosoftClientID and MicrosoftClientSecret enable the Microsoft login when set.
+	MicrosoftClientID     string
+	MicrosoftClientSecret string
+	// MicrosoftTenant is common, organizations or the ID of a single tenant.
+	MicrosoftTenant string
+	// MicrosoftAllowedTenants is a comma separated list of the tenant IDs whose users can log in.
+	MicrosoftAllowedTenants string
+	// BaseURL is the public URL of the service, used to build the provider callback URLs.
+	BaseURL string
+	// ReturnOrigins is a comma separated list of the origins users can return to after logging in.
+	ReturnOrigins string
+	// OIDCProviders is a comma separated list of OpenID Connect provider names,
+	// each configured by the LEGITIMA_OIDC_<NAME>_ISSUER, _CLIENT_ID and _CLIENT_SECRET variables.
+	OIDCProviders string
 }
 
 func main() {
@@ -39,38 +81,128 @@ func main() {
 		ClientSecret: os.Getenv("LEGITIMA_GOOGLE_CLIENT_SECRET"),
 		PORT:         getEnvWithDefault("PORT", "8080"),
 	}
+	cfg.GitHubClientID = os.Getenv("LEGITIMA_GITHUB_CLIENT_ID")
+	cfg.GitHubClientSecret = os.Getenv("LEGITIMA_GITHUB_CLIENT_SECRET")
+	cfg.AllowedEmailDomains = os.Getenv("LEGITIMA_ALLOWED_EMAIL_DOMAINS")
+	cfg.GoogleHostedDomains = os.Getenv("LEGITIMA_GOOGLE_HOSTED_DOMAINS")
+	cfg.MicrosoftClientID = os.Getenv("LEGITIMA_MICROSOFT_CLIENT_ID")
+	cfg.MicrosoftClientSecret = os.Getenv("LEGITIMA_MICROSOFT_CLIENT_SECRET")
+	cfg.MicrosoftTenant = getEnvWithDefault("LEGITIMA_MICROSOFT_TENANT", "organizations")
+	cfg.MicrosoftAllowedTenants = os.Getenv("LEGITIMA_MICROSOFT_ALLOWED_TENANTS")
+	cfg.BaseURL = getEnvWithDefault("LEGITIMA_BASE_URL", "https://legitima-431f346ecb86.herokuapp.com")
+	cfg.OIDCProviders = os.Getenv("LEGITIMA_OIDC_PROVIDERS")
+	cfg.ReturnOrigins = os.Getenv("LEGITIMA_RETURN_ORIGINS")
+	cfg.TokenIssuer = getEnvWithDefault("LEGITIMA_TOKEN_ISSUER", strings.TrimSuffix(cfg.BaseURL, "/"))
+	cfg.TokenAudience = getEnvWithDefault("LEGITIMA_TOKEN_AUDIENCE", "legitima")
+	cfg.TokenTTL = getEnvWithDefault("LEGITIMA_TOKEN_TTL", "1h")
+	cfg.RefreshTTL = getEnvWithDefault("LEGITIMA_REFRESH_TOKEN_TTL", "720h")
+	cfg.JWTSecret = os.Getenv("LEGITIMA_JWT_SECRET")
+	cfg.JWTSecretFile = os.Getenv("LEGITIMA_JWT_SECRET_FILE")
+	cfg.KeyAlgorithm = getEnvWithDefault("LEGITIMA_KEY_ALGORITHM", "ES256")
+	cfg.KeyRotation = os.Getenv("LEGITIMA_KEY_ROTATION")
+	cfg.MySQLURL = os.Getenv("LEGITIMA_MYSQL_URL")
+	cfg.AdminEmails = os.Getenv("LEGITIMA_ADMIN_EMAILS")
+	cfg.IntrospectionClients = os.Getenv("LEGITIMA_INTROSPECTION_CLIENTS")
 
+	if len(os.Args) > 1 {
+		runCommand(cfg, os.Args[1:])
+		return
+	}
+	serve(cfg)
+}
+
+func serve(cfg *Config) {
 	if cfg.ClientID == "" || cfg.ClientSecret == "" {
 		slog.Fatal("missing google auth client id or secret")
 	}
 
-	googleOAuthConfig := oauth2.Config{
-		ClientID:     cfg.ClientID,
-		ClientSecret: cfg.ClientSecret,
-		Endpoint:     google.Endpoint,
-		RedirectURL:  "https://legitima-431f346ecb86.herokuapp.com/callback",
-		Scopes: []string{"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile"},
+	tokenCfg := api.DefaultTokenConfig()
+	tokenCfg.Issuer = cfg.TokenIssuer
+	tokenCfg.Audience = cfg.TokenAudience
+	var err error
+	tokenCfg.TTL, err = time.ParseDuration(cfg.TokenTTL)
+	if err != nil {
+		slog.Fatal("invalid token ttl", "error", err.Error())
+	}
+	tokenCfg.RefreshTTL, err = time.ParseDuration(cfg.RefreshTTL)
+	if err != nil {
+		slog.Fatal("invalid refresh token ttl", "error", err.Error())
 	}
 
-	dbConfig := mysql.Config{
-		URL:             os.Getenv("LEGITIMA_MYSQL_URL"),
-		MaxOpenConns:    10,
-		MaxIdleConns:    5,
-		ConnMaxIdleTime: 5 * time.Minute,
+	db := openDB(cfg)
+	storage := mysql.NewStorage(db)
+	tokenCfg.Revocations = storage
+	err = api.BootstrapAdmins(storage, splitList(cfg.AdminEmails))
+	if err != nil {
+		slog.Fatal("failed to bootstrap admins", "error", err.Error())
 	}
+	go api.RunRevocationCleanup(context.Background(), storage, time.Hour)
+	go api.RunDeviceCodeCleanup(context.Background(), storage, time.Hour)
 
-	db, err := mysql.OpenDB(dbConfig)
+	var key api.Key
+	if cfg.JWTSecret != "" || cfg.JWTSecretFile != "" || cfg.KeyRotation == "" {
+		key, err = api.LoadKey(api.KeyConfig{
+			Secret: cfg.JWTSecret,
+			File:   cfg.JWTSecretFile,
+		})
+		if err != nil {
+			slog.Fatal("failed to load signing key", "error", err.Error())
+		}
+	} else {
+		rotation, err := time.ParseDuration(cfg.KeyRotation)
+		if err != nil {
+			slog.Fatal("invalid key rotation period", "error", err.Error())
+		}
+		if rotation <= tokenCfg.TTL+tokenCfg.Leeway {
+			slog.Fatal("key rotation period must be longer than the token ttl and leeway", "rotation", rotation, "ttl", tokenCfg.TTL)
+		}
+		keyRing, err := api.NewKeyRing(storage, cfg.KeyAlgorithm)
+		if err != nil {
+			slog.Fatal("failed to load signing keys", "error", err.Error())
+		}
+		go keyRing.Run(context.Background(), rotation, tokenCfg.TTL+tokenCfg.Leeway)
+		key = keyRing
+	}
+
+	introspectionClients, err := api.ParseStaticClients(cfg.IntrospectionClients)
 	if err != nil {
-		slog.Fatal("failed to open db", "error", err.Error())
+		slog.Fatal("invalid introspection clients", "error", err.Error())
 	}
 
-	storage := mysql.NewStorage(db)
+	identityProviders, err := newIdentityProviders(context.Background(), cfg)
+	if err != nil {
+		slog.Fatal("failed to configure identity providers", "error", err.Error())
+	}
+	providers, err := api.NewProviders(identityProviders...)
+	if err != nil {
+		slog.Fatal("invalid identity providers", "error", err.Error())
+	}
+	providers.AllowEmailDomains(splitList(cfg.AllowedEmailDomains)...)
+
+	returnURLs, err := api.NewReturnURLs(splitList(cfg.ReturnOrigins)...)
+	if err != nil {
+		slog.Fatal("invalid return origins", "error", err.Error())
+	}
+	codes := api.NewMemoryLoginCodeStore()
 
 	mux := http.NewServeMux()
-	api.SetupAuth(mux, &googleOAuthConfig, storage)
-	mux.HandleFunc("/", api.HomeHandler)
-	api.SetupProfile(mux, storage)
+	api.SetupAuth(mux, providers, api.NewMemoryStateStore(), returnURLs, codes, key, tokenCfg, storage)
+	api.SetupCodeExchange(mux, codes, key, tokenCfg, storage)
+	mux.Handle("/", api.HomeHandler(providers))
+	api.SetupProfile(mux, key, tokenCfg, storage)
+	api.SetupJWKS(mux, key)
+	api.SetupRefresh(mux, key, tokenCfg, storage)
+	api.SetupLogout(mux, key, tokenCfg, storage)
+	api.SetupAdmin(mux, key, tokenCfg, storage)
+	api.SetupIntrospection(mux, key, tokenCfg, introspectionClients, storage)
+	api.SetupAdminClients(mux, key, tokenCfg, storage)
+	api.SetupAuthorizationServer(mux, key, tokenCfg, storage)
+	err = api.SetupOpenID(mux, cfg.BaseURL, key, tokenCfg, storage)
+	if err != nil {
+		slog.Fatal("failed to set up openid connect", "error", err.Error())
+	}
+	api.SetupDevice(mux, cfg.BaseURL, key, tokenCfg, storage)
+	api.SetupOrganizations(mux, key, tokenCfg, storage)
 
 	svr := &http.Server{
 		Addr:         ":" + cfg.PORT,
@@ -86,6 +218,88 @@ func main() {
 	}
 }
 
+// newIdentityProviders returns the configured identity providers, Google first.
+func newIdentityProviders(ctx context.Context, cfg *Config) ([]api.IdentityProvider, error) {
+	google, err := api.NewGoogleProvider(ctx, api.GoogleConfig{
+		ClientID:      cfg.ClientID,
+		ClientSecret:  cfg.ClientSecret,
+		RedirectURL:   cfg.BaseURL + "/callback/google",
+		HostedDomains: splitList(cfg.GoogleHostedDomains),
+	})
+	if err != nil {
+		return nil, err
+	}
+	providers := []api.IdentityProvider{google}
+
+	if cfg.GitHubClientID != "" && cfg.GitHubClientSecret != "" {
+		providers = append(providers, api.NewGitHubProvider(&oauth2.Config{
+			ClientID:     cfg.GitHubClientID,
+			ClientSecret: cfg.GitHubClientSecret,
+			Endpoint:     github.Endpoint,
+			RedirectURL:  cfg.BaseURL + "/callback/github",
+			Scopes:       []string{"read:user", "user:email"},
+		}))
+	}
+
+	if cfg.MicrosoftClientID != "" && cfg.MicrosoftClientSecret != "" {
+		microsoft, err := api.NewMicrosoftProvider(ctx, api.MicrosoftConfig{
+			Tenant:         cfg.MicrosoftTenant,
+			ClientID:       cfg.MicrosoftClientID,
+			ClientSecret:   cfg.MicrosoftClientSecret,
+			RedirectURL:    cfg.BaseURL + "/callback/microsoft",
+			AllowedTenants: splitList(cfg.MicrosoftAllowedTenants),
+		})
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, microsoft)
+	}
+
+	for _, name := range splitList(cfg.OIDCProviders) {
+		prefix := "LEGITIMA_OIDC_" + strings.ToUpper(name) + "_"
+		provider, err := api.NewOIDCProvider(ctx, api.OIDCConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  cfg.BaseURL + "/callback/" + name,
+			Scopes:       splitList(os.Getenv(prefix + "SCOPES")),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("configuring %s: %w", name, err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+func openDB(cfg *Config) *sql.DB {
+	dbConfig := mysql.Config{
+		URL:             cfg.MySQLURL,
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxIdleTime: 5 * time.Minute,
+	}
+
+	db, err := mysql.OpenDB(dbConfig)
+	if err != nil {
+		slog.Fatal("failed to open db", "error", err.Error())
+	}
+	return db
+}
+
+// splitList splits a comma separated list, ignoring empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnvWithDefault(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -0,0 +1,31 @@
+package legitima
+
+import "time"
+
+// DeviceCodeStatus is the state of a device authorization.
+type DeviceCodeStatus string
+
+// Device code statuses
+const (
+	// DeviceCodePending is waiting for the user to enter the user code.
+	DeviceCodePending DeviceCodeStatus = "pending"
+	// DeviceCodeApproved can be exchanged for tokens by the device.
+	DeviceCodeApproved DeviceCodeStatus = "approved"
+	// DeviceCodeDenied was rejected by the user.
+	DeviceCodeDenied DeviceCodeStatus = "denied"
+)
+
+// DeviceCode is an OAuth device authorization, as defined in RFC 8628.
+// Only the hash of the device code is stored, the user code is typed by the user on another device.
+type DeviceCode struct {
+	Hash     string           `json:"-"`
+	UserCode string           `json:"user_code"`
+	ClientID string           `json:"client_id"`
+	Scope    string           `json:"scope"`
+	Status   DeviceCodeStatus `json:"status"`
+	// Email is the user that approved the authorization.
+	Email string `json:"email"`
+	// LastPolledAt is when the device last asked for the tokens, zero if it never did.
+	LastPolledAt time.Time `json:"last_polled_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
@@ -0,0 +1,16 @@
+package legitima
+
+import "errors"
+
+// Storage errors
+var (
+	// ErrNotFound is returned when the requested entity does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when saving an entity whose ID is taken.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrRefreshTokenReused is returned when rotating a refresh token that was already used.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrIdentityConflict is returned when saving a user whose email is linked to another identity,
+	// or whose identity is linked to another email.
+	ErrIdentityConflict = errors.New("identity conflict")
+)
@@ -0,0 +1,13 @@
+package legitima
+
+// Identity is the provider-neutral profile of a user authenticated by an identity provider.
+type Identity struct {
+	// Provider is the name of the identity provider, e.g. google.
+	Provider string `json:"provider"`
+	// Subject is the ID of the user at the identity provider.
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+}
@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+)
+
+// AuthorizationCode represents an authorization code in the database.
+type AuthorizationCode struct {
+	Hash                string `db:"code_hash"`
+	ClientID            string `db:"client_id"`
+	Email               string `db:"email"`
+	RedirectURI         string `db:"redirect_uri"`
+	RedirectURIProvided bool   `db:"redirect_uri_provided"`
+	Scope               string `db:"scope"`
+	CodeChallenge       string `db:"code_challenge"`
+	Nonce               string `db:"nonce"`
+	ExpiresAt           int64  `db:"expires_at"`
+}
+
+// Convert a database authorization code to a legitima authorization code.
+func (cDB *AuthorizationCode) Convert() legitima.AuthorizationCode {
+	return legitima.AuthorizationCode{
+		Hash:                cDB.Hash,
+		ClientID:            cDB.ClientID,
+		Email:               cDB.Email,
+		RedirectURI:         cDB.RedirectURI,
+		RedirectURIProvided: cDB.RedirectURIProvided,
+		Scope:               cDB.Scope,
+		CodeChallenge:       cDB.CodeChallenge,
+		Nonce:               cDB.Nonce,
+		ExpiresAt:           time.Unix(cDB.ExpiresAt, 0),
+	}
+}
+
+// SaveAuthorizationCode saves an authorization code to the database.
+func (s *Storage) SaveAuthorizationCode(code legitima.AuthorizationCode) error {
+	_, err := s.db.Exec(`INSERT INTO authorization_codes
+		(code_hash, client_id, email, redirect_uri, redirect_uri_provided, scope, code_challenge, nonce, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+		code.Hash, code.ClientID, code.Email, code.RedirectURI, code.RedirectURIProvided, code.Scope, code.CodeChallenge,
+		code.Nonce, code.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("save authorization code: %w", err)
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes an authorization code from the database and returns it,
+// so each code can be exchanged only once. Expired codes are returned as well.
+func (s *Storage) ConsumeAuthorizationCode(hash string) (_ *legitima.AuthorizationCode, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("consume authorization code: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var code AuthorizationCode
+	err = tx.QueryRow(`SELECT code_hash, client_id, email, redirect_uri, redirect_uri_provided, scope, code_challenge, nonce,
+		UNIX_TIMESTAMP(expires_at) FROM authorization_codes WHERE code_hash = ? FOR UPDATE`, hash).
+		Scan(&code.Hash, &code.ClientID, &code.Email, &code.RedirectURI, &code.RedirectURIProvided, &code.Scope,
+			&code.CodeChallenge, &code.Nonce, &code.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("consume authorization code: %w", legitima.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume authorization code: %w", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM authorization_codes WHERE code_hash = ?`, hash)
+	if err != nil {
+		return nil, fmt.Errorf("consume authorization code: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("consume authorization code: %w", err)
+	}
+
+	lCode := code.Convert()
+	return &lCode, nil
+}
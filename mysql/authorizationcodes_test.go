@@ -0,0 +1,52 @@
+//go:build integration
+// +build integration
+
+package mysql_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/mysql"
+)
+
+func TestConsumeAuthorizationCode(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	code := legitima.AuthorizationCode{
+		Hash:                "hash",
+		ClientID:            "app",
+		Email:               "jojo@gmail.com",
+		RedirectURI:         "https://app.example.com/callback",
+		RedirectURIProvided: true,
+		Scope:               "profile",
+		CodeChallenge:       "challenge",
+		Nonce:               "nonce",
+		ExpiresAt:           time.Now().Add(time.Minute),
+	}
+	err := storage.SaveAuthorizationCode(code)
+	if err != nil {
+		t.Fatalf("failed to save authorization code: %v", err)
+	}
+
+	got, err := storage.ConsumeAuthorizationCode(code.Hash)
+	if err != nil {
+		t.Fatalf("failed to consume authorization code: %v", err)
+	}
+	if got.ClientID != code.ClientID || got.RedirectURI != code.RedirectURI || got.RedirectURIProvided != code.RedirectURIProvided || got.CodeChallenge != code.CodeChallenge || got.Nonce != code.Nonce {
+		t.Fatalf("expected %+v, got %+v", code, got)
+	}
+	if got.ExpiresAt.Unix() != code.ExpiresAt.Unix() {
+		t.Fatalf("expected expires at %v, got %v", code.ExpiresAt, got.ExpiresAt)
+	}
+
+	_, err = storage.ConsumeAuthorizationCode(code.Hash)
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected %v, got %v", legitima.ErrNotFound, err)
+	}
+}
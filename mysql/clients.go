@@ -0,0 +1,140 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/go-sql-driver/mysql"
+)
+
+// errDuplicateEntry is the MySQL error number of duplicate unique keys.
+const errDuplicateEntry = 1062
+
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry
+}
+
+// Client represents an OAuth client in the database.
+// The lists are stored space separated, as none of their values can have spaces.
+type Client struct {
+	ID              string `db:"id"`
+	Name            string `db:"name"`
+	SecretHash      string `db:"secret_hash"`
+	RedirectURIs    string `db:"redirect_uris"`
+	GrantTypes      string `db:"grant_types"`
+	Scopes          string `db:"scopes"`
+	TokenTTLSeconds int64  `db:"token_ttl_seconds"`
+	CreatedAt       int64  `db:"created_at"`
+}
+
+// Convert a database client to a legitima client.
+func (cDB *Client) Convert() legitima.Client {
+	return legitima.Client{
+		ID:           cDB.ID,
+		Name:         cDB.Name,
+		SecretHash:   cDB.SecretHash,
+		RedirectURIs: strings.Fields(cDB.RedirectURIs),
+		GrantTypes:   strings.Fields(cDB.GrantTypes),
+		Scopes:       strings.Fields(cDB.Scopes),
+		TokenTTL:     time.Duration(cDB.TokenTTLSeconds) * time.Second,
+		CreatedAt:    time.Unix(cDB.CreatedAt, 0),
+	}
+}
+
+const selectClients = `SELECT id, name, secret_hash, redirect_uris, grant_types, scopes, token_ttl_seconds, UNIX_TIMESTAMP(created_at)
+	FROM clients`
+
+func scanClient(row interface{ Scan(...interface{}) error }) (Client, error) {
+	var c Client
+	err := row.Scan(&c.ID, &c.Name, &c.SecretHash, &c.RedirectURIs, &c.GrantTypes, &c.Scopes, &c.TokenTTLSeconds, &c.CreatedAt)
+	return c, err
+}
+
+// SaveClient saves a new client to the database.
+func (s *Storage) SaveClient(client legitima.Client) error {
+	_, err := s.db.Exec(`INSERT INTO clients (id, name, secret_hash, redirect_uris, grant_types, scopes, token_ttl_seconds, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+		client.ID, client.Name, client.SecretHash,
+		strings.Join(client.RedirectURIs, " "), strings.Join(client.GrantTypes, " "), strings.Join(client.Scopes, " "),
+		int64(client.TokenTTL/time.Second), client.CreatedAt.Unix())
+	if isDuplicateEntry(err) {
+		return fmt.Errorf("save client: %w", legitima.ErrAlreadyExists)
+	}
+	if err != nil {
+		return fmt.Errorf("save client: %w", err)
+	}
+	return nil
+}
+
+// ClientByID returns a client from the database filtered by ID.
+func (s *Storage) ClientByID(id string) (*legitima.Client, error) {
+	client, err := scanClient(s.db.QueryRow(selectClients+` WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("client by id: %w", legitima.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("client by id: %w", err)
+	}
+
+	lClient := client.Convert()
+	return &lClient, nil
+}
+
+// Clients returns every client, ordered by ID.
+func (s *Storage) Clients() ([]legitima.Client, error) {
+	rows, err := s.db.Query(selectClients + ` ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("clients: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var clients []legitima.Client
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("clients: scanning: %w", err)
+		}
+		clients = append(clients, client.Convert())
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("clients: %w", err)
+	}
+	return clients, nil
+}
+
+// UpdateClientSecret replaces the secret hash of a client.
+func (s *Storage) UpdateClientSecret(id, secretHash string) error {
+	res, err := s.db.Exec(`UPDATE clients SET secret_hash = ? WHERE id = ?`, secretHash, id)
+	if err != nil {
+		return fmt.Errorf("update client secret: %w", err)
+	}
+	return expectAffected(res, "update client secret")
+}
+
+// DeleteClient deletes a client from the database.
+func (s *Storage) DeleteClient(id string) error {
+	res, err := s.db.Exec(`DELETE FROM clients WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete client: %w", err)
+	}
+	return expectAffected(res, "delete client")
+}
+
+// expectAffected returns legitima.ErrNotFound if the statement changed no rows.
+func expectAffected(res sql.Result, op string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, legitima.ErrNotFound)
+	}
+	return nil
+}
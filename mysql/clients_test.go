@@ -0,0 +1,73 @@
+//go:build integration
+// +build integration
+
+package mysql_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/mysql"
+)
+
+func TestClients(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	client := legitima.Client{
+		ID:           "app",
+		Name:         "The App",
+		SecretHash:   "hash",
+		RedirectURIs: []string{"https://app.example.com/callback", "https://app.example.com/other"},
+		GrantTypes:   []string{"authorization_code"},
+		Scopes:       []string{"openid", "email"},
+		TokenTTL:     10 * time.Minute,
+		CreatedAt:    time.Unix(time.Now().Unix(), 0),
+	}
+	err := storage.SaveClient(client)
+	if err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+	err = storage.SaveClient(client)
+	if !errors.Is(err, legitima.ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists, got %v", err)
+	}
+
+	got, err := storage.ClientByID(client.ID)
+	if err != nil {
+		t.Fatalf("failed to get client by id: %v", err)
+	}
+	if !reflect.DeepEqual(*got, client) {
+		t.Fatalf("expected %+v, got %+v", client, *got)
+	}
+
+	err = storage.UpdateClientSecret(client.ID, "other")
+	if err != nil {
+		t.Fatalf("failed to update client secret: %v", err)
+	}
+	clients, err := storage.Clients()
+	if err != nil {
+		t.Fatalf("failed to list clients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].SecretHash != "other" {
+		t.Fatalf("expected the client with the new secret, got %+v", clients)
+	}
+
+	err = storage.DeleteClient(client.ID)
+	if err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+	_, err = storage.ClientByID(client.ID)
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	err = storage.DeleteClient(client.ID)
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound deleting twice, got %v", err)
+	}
+}
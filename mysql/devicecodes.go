@@ -0,0 +1,145 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+)
+
+// DeviceCode represents a device authorization in the database.
+type DeviceCode struct {
+	Hash         string        `db:"device_code_hash"`
+	UserCode     string        `db:"user_code"`
+	ClientID     string        `db:"client_id"`
+	Scope        string        `db:"scope"`
+	Status       string        `db:"status"`
+	Email        string        `db:"email"`
+	LastPolledAt sql.NullInt64 `db:"last_polled_at"`
+	ExpiresAt    int64         `db:"expires_at"`
+}
+
+// Convert a database device code to a legitima device code.
+func (cDB *DeviceCode) Convert() legitima.DeviceCode {
+	code := legitima.DeviceCode{
+		Hash:      cDB.Hash,
+		UserCode:  cDB.UserCode,
+		ClientID:  cDB.ClientID,
+		Scope:     cDB.Scope,
+		Status:    legitima.DeviceCodeStatus(cDB.Status),
+		Email:     cDB.Email,
+		ExpiresAt: time.Unix(cDB.ExpiresAt, 0),
+	}
+	if cDB.LastPolledAt.Valid {
+		code.LastPolledAt = time.Unix(cDB.LastPolledAt.Int64, 0)
+	}
+	return code
+}
+
+const selectDeviceCodes = `SELECT device_code_hash, user_code, client_id, scope, status, email,
+	UNIX_TIMESTAMP(last_polled_at), UNIX_TIMESTAMP(expires_at) FROM device_codes`
+
+func scanDeviceCode(row interface{ Scan(...interface{}) error }) (DeviceCode, error) {
+	var c DeviceCode
+	err := row.Scan(&c.Hash, &c.UserCode, &c.ClientID, &c.Scope, &c.Status, &c.Email, &c.LastPolledAt, &c.ExpiresAt)
+	return c, err
+}
+
+// SaveDeviceCode saves a new device authorization to the database.
+func (s *Storage) SaveDeviceCode(code legitima.DeviceCode) error {
+	_, err := s.db.Exec(`INSERT INTO device_codes (device_code_hash, user_code, client_id, scope, status, expires_at)
+		VALUES (?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+		code.Hash, code.UserCode, code.ClientID, code.Scope, code.Status, code.ExpiresAt.Unix())
+	if isDuplicateEntry(err) {
+		return fmt.Errorf("save device code: %w", legitima.ErrAlreadyExists)
+	}
+	if err != nil {
+		return fmt.Errorf("save device code: %w", err)
+	}
+	return nil
+}
+
+// DeviceCodeByUserCode returns a device authorization from the database filtered by user code.
+func (s *Storage) DeviceCodeByUserCode(userCode string) (*legitima.DeviceCode, error) {
+	code, err := scanDeviceCode(s.db.QueryRow(selectDeviceCodes+` WHERE user_code = ?`, userCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("device code by user code: %w", legitima.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("device code by user code: %w", err)
+	}
+
+	lCode := code.Convert()
+	return &lCode, nil
+}
+
+// DecideDeviceCode approves or denies a pending device authorization on behalf of the user with the given email.
+// Authorizations that are no longer pending are reported with legitima.ErrNotFound.
+func (s *Storage) DecideDeviceCode(userCode string, status legitima.DeviceCodeStatus, email string) error {
+	res, err := s.db.Exec(`UPDATE device_codes SET status = ?, email = ? WHERE user_code = ? AND status = ?`,
+		status, email, userCode, legitima.DeviceCodePending)
+	if err != nil {
+		return fmt.Errorf("decide device code: %w", err)
+	}
+	return expectAffected(res, "decide device code")
+}
+
+// PollDeviceCode records a poll of the device and returns the device authorization as it was before it.
+// Approved, denied and expired authorizations are deleted, so the tokens are issued only once.
+// Polls of a client other than the one the authorization was issued to change nothing.
+func (s *Storage) PollDeviceCode(hash, clientID string, now time.Time) (_ *legitima.DeviceCode, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("poll device code: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	code, err := scanDeviceCode(tx.QueryRow(selectDeviceCodes+` WHERE device_code_hash = ? FOR UPDATE`, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("poll device code: %w", legitima.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("poll device code: %w", err)
+	}
+
+	lCode := code.Convert()
+	if lCode.ClientID != clientID {
+		err = tx.Commit()
+		if err != nil {
+			return nil, fmt.Errorf("poll device code: %w", err)
+		}
+		return &lCode, nil
+	}
+	if lCode.Status == legitima.DeviceCodePending && now.Before(lCode.ExpiresAt) {
+		_, err = tx.Exec(`UPDATE device_codes SET last_polled_at = FROM_UNIXTIME(?) WHERE device_code_hash = ?`, now.Unix(), hash)
+	} else {
+		_, err = tx.Exec(`DELETE FROM device_codes WHERE device_code_hash = ?`, hash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("poll device code: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("poll device code: %w", err)
+	}
+	return &lCode, nil
+}
+
+// DeleteExpiredDeviceCodes deletes the device authorizations expired at the given time.
+func (s *Storage) DeleteExpiredDeviceCodes(now time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM device_codes WHERE expires_at < FROM_UNIXTIME(?)`, now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("delete expired device codes: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete expired device codes: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,119 @@
+//go:build integration
+// +build integration
+
+package mysql_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/mysql"
+)
+
+func TestDeviceCodes(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	code := legitima.DeviceCode{
+		Hash:      "hash",
+		UserCode:  "BCDF-GHJK",
+		ClientID:  "cli",
+		Scope:     "openid",
+		Status:    legitima.DeviceCodePending,
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	err := storage.SaveDeviceCode(code)
+	if err != nil {
+		t.Fatalf("failed to save device code: %v", err)
+	}
+	err = storage.SaveDeviceCode(legitima.DeviceCode{Hash: "other", UserCode: code.UserCode, ExpiresAt: code.ExpiresAt})
+	if !errors.Is(err, legitima.ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists for a taken user code, got %v", err)
+	}
+
+	now := time.Now()
+	polled, err := storage.PollDeviceCode(code.Hash, code.ClientID, now)
+	if err != nil {
+		t.Fatalf("failed to poll device code: %v", err)
+	}
+	if polled.Status != legitima.DeviceCodePending || !polled.LastPolledAt.IsZero() {
+		t.Fatalf("expected a pending code never polled, got %+v", polled)
+	}
+
+	err = storage.DecideDeviceCode(code.UserCode, legitima.DeviceCodeApproved, "jojo@gmail.com")
+	if err != nil {
+		t.Fatalf("failed to approve device code: %v", err)
+	}
+	err = storage.DecideDeviceCode(code.UserCode, legitima.DeviceCodeDenied, "jojo@gmail.com")
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound deciding twice, got %v", err)
+	}
+
+	got, err := storage.DeviceCodeByUserCode(code.UserCode)
+	if err != nil {
+		t.Fatalf("failed to get device code: %v", err)
+	}
+	if got.Status != legitima.DeviceCodeApproved || got.Email != "jojo@gmail.com" || got.LastPolledAt.Unix() != now.Unix() {
+		t.Fatalf("expected an approved code polled at %v, got %+v", now, got)
+	}
+
+	polled, err = storage.PollDeviceCode(code.Hash, "other", time.Now())
+	if err != nil {
+		t.Fatalf("failed to poll device code: %v", err)
+	}
+	if polled.ClientID != code.ClientID {
+		t.Fatalf("expected the code of %s, got %+v", code.ClientID, polled)
+	}
+
+	polled, err = storage.PollDeviceCode(code.Hash, code.ClientID, time.Now())
+	if err != nil {
+		t.Fatalf("failed to poll device code: %v", err)
+	}
+	if polled.Status != legitima.DeviceCodeApproved {
+		t.Fatalf("expected an approved code, got %+v", polled)
+	}
+	_, err = storage.PollDeviceCode(code.Hash, code.ClientID, time.Now())
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected the approved code to be consumed, got %v", err)
+	}
+}
+
+func TestDeleteExpiredDeviceCodes(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	now := time.Now()
+	for i, expiresAt := range []time.Time{now.Add(-time.Hour), now.Add(time.Hour)} {
+		err := storage.SaveDeviceCode(legitima.DeviceCode{
+			Hash:      fmt.Sprintf("hash-%d", i),
+			UserCode:  fmt.Sprintf("BCDF-GHJ%d", i),
+			ClientID:  "cli",
+			Status:    legitima.DeviceCodePending,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("failed to save device code: %v", err)
+		}
+	}
+
+	n, err := storage.DeleteExpiredDeviceCodes(now)
+	if err != nil {
+		t.Fatalf("failed to delete expired device codes: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 deleted device code, got %d", n)
+	}
+	if _, err := storage.DeviceCodeByUserCode("BCDF-GHJ0"); !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected the expired code to be deleted, got %v", err)
+	}
+	if _, err := storage.DeviceCodeByUserCode("BCDF-GHJ1"); err != nil {
+		t.Fatalf("expected the pending code to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id VARCHAR(255) PRIMARY KEY,
+    state VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    activated_at DATETIME NULL,
+    INDEX signing_keys_state (state)
+);
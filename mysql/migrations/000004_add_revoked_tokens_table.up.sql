@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(255) PRIMARY KEY,
+    expires_at DATETIME NOT NULL,
+    INDEX revoked_tokens_expires_at (expires_at)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_revocations (
+    email VARCHAR(255) PRIMARY KEY,
+    revoked_before DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    INDEX user_revocations_expires_at (expires_at)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS authorization_codes (
+    code_hash CHAR(64) PRIMARY KEY,
+    client_id VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    redirect_uri VARCHAR(2048) NOT NULL,
+    scope VARCHAR(1024) NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128) NOT NULL DEFAULT '',
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE authorization_codes ADD COLUMN nonce VARCHAR(255) NOT NULL DEFAULT '';
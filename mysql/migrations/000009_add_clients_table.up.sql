@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS clients (
+    id VARCHAR(255) PRIMARY KEY,
+    name TEXT NOT NULL,
+    secret_hash CHAR(64) NOT NULL DEFAULT '',
+    redirect_uris TEXT NOT NULL,
+    grant_types VARCHAR(1024) NOT NULL DEFAULT '',
+    scopes VARCHAR(1024) NOT NULL DEFAULT '',
+    token_ttl_seconds BIGINT NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
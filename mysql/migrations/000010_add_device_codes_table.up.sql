@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS device_codes (
+    device_code_hash CHAR(64) PRIMARY KEY,
+    user_code VARCHAR(16) NOT NULL UNIQUE,
+    client_id VARCHAR(255) NOT NULL,
+    scope VARCHAR(1024) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    last_polled_at DATETIME NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE refresh_tokens ADD COLUMN scope VARCHAR(1024) NOT NULL DEFAULT '';
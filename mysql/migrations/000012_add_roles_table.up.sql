@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(255) PRIMARY KEY,
+    description TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_roles (
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (email, role),
+    FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE
+);
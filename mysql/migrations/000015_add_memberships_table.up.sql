@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS memberships (
+    org_id VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, email),
+    INDEX memberships_email (email),
+    FOREIGN KEY (org_id) REFERENCES organizations (id) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+ALTER TABLE refresh_tokens ADD COLUMN org_id VARCHAR(255) NOT NULL DEFAULT '';
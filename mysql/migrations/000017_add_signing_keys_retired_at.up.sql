@@ -0,0 +1 @@
+ALTER TABLE signing_keys ADD COLUMN retired_at DATETIME NULL;
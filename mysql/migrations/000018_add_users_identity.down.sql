@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP INDEX users_provider_subject,
+    DROP COLUMN provider,
+    DROP COLUMN subject;
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN provider VARCHAR(64) NULL,
+    ADD COLUMN subject VARCHAR(255) NULL,
+    ADD UNIQUE INDEX users_provider_subject (provider, subject);
@@ -0,0 +1 @@
+ALTER TABLE authorization_codes ADD COLUMN redirect_uri_provided BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,134 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+)
+
+// Organization represents an organization in the database.
+type Organization struct {
+	ID        string         `db:"id"`
+	Name      string         `db:"name"`
+	Domain    sql.NullString `db:"domain"`
+	CreatedAt int64          `db:"created_at"`
+}
+
+// Convert a database organization to a legitima organization.
+func (oDB *Organization) Convert() legitima.Organization {
+	return legitima.Organization{
+		ID:        oDB.ID,
+		Name:      oDB.Name,
+		Domain:    oDB.Domain.String,
+		CreatedAt: time.Unix(oDB.CreatedAt, 0),
+	}
+}
+
+// SaveOrganization saves a new organization to the database.
+// It returns legitima.ErrAlreadyExists if its ID or domain are taken.
+func (s *Storage) SaveOrganization(org legitima.Organization) error {
+	// Empty domains are stored as NULL, so they don't collide.
+	domain := sql.NullString{String: org.Domain, Valid: org.Domain != ""}
+	_, err := s.db.Exec(`INSERT INTO organizations (id, name, domain, created_at) VALUES (?, ?, ?, FROM_UNIXTIME(?))`,
+		org.ID, org.Name, domain, org.CreatedAt.Unix())
+	if isDuplicateEntry(err) {
+		return fmt.Errorf("save organization: %w", legitima.ErrAlreadyExists)
+	}
+	if err != nil {
+		return fmt.Errorf("save organization: %w", err)
+	}
+	return nil
+}
+
+// SaveMembership makes the user with the email a member of the organization.
+// Joining twice is not an error. It returns legitima.ErrNotFound if the organization does not exist.
+func (s *Storage) SaveMembership(membership legitima.Membership) error {
+	_, err := s.db.Exec(`INSERT INTO memberships (org_id, email, created_at) VALUES (?, ?, FROM_UNIXTIME(?))`,
+		membership.OrgID, membership.Email, membership.CreatedAt.Unix())
+	if isDuplicateEntry(err) {
+		return nil
+	}
+	if isMissingReference(err) {
+		return fmt.Errorf("save membership: %w", legitima.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("save membership: %w", err)
+	}
+	return nil
+}
+
+// UserOrganization returns the organization with the given ID, only if the user with the email is a member.
+func (s *Storage) UserOrganization(email, orgID string) (*legitima.Organization, error) {
+	var org Organization
+	err := s.db.QueryRow(`SELECT o.id, o.name, o.domain, UNIX_TIMESTAMP(o.created_at)
+		FROM organizations o JOIN memberships m ON m.org_id = o.id
+		WHERE m.email = ? AND o.id = ?`, email, orgID).
+		Scan(&org.ID, &org.Name, &org.Domain, &org.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user organization: %w", legitima.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("user organization: %w", err)
+	}
+
+	lOrg := org.Convert()
+	return &lOrg, nil
+}
+
+// UserOrganizations returns the organizations the user with the email is a member of, ordered by name.
+func (s *Storage) UserOrganizations(email string) ([]legitima.Organization, error) {
+	rows, err := s.db.Query(`SELECT o.id, o.name, o.domain, UNIX_TIMESTAMP(o.created_at)
+		FROM organizations o JOIN memberships m ON m.org_id = o.id
+		WHERE m.email = ? ORDER BY o.name, o.id`, email)
+	if err != nil {
+		return nil, fmt.Errorf("user organizations: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var orgs []legitima.Organization
+	for rows.Next() {
+		var org Organization
+		err := rows.Scan(&org.ID, &org.Name, &org.Domain, &org.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("user organizations: scanning: %w", err)
+		}
+		orgs = append(orgs, org.Convert())
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("user organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+// Memberships returns the members of the organization, ordered by email.
+func (s *Storage) Memberships(orgID string) ([]legitima.Membership, error) {
+	rows, err := s.db.Query(`SELECT org_id, email, UNIX_TIMESTAMP(created_at) FROM memberships
+		WHERE org_id = ? ORDER BY email`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("memberships: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var memberships []legitima.Membership
+	for rows.Next() {
+		var m legitima.Membership
+		var createdAt int64
+		err := rows.Scan(&m.OrgID, &m.Email, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("memberships: scanning: %w", err)
+		}
+		m.CreatedAt = time.Unix(createdAt, 0)
+		memberships = append(memberships, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("memberships: %w", err)
+	}
+	return memberships, nil
+}
@@ -0,0 +1,77 @@
+//go:build integration
+// +build integration
+
+package mysql_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/mysql"
+)
+
+func TestOrganizations(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	acme := legitima.Organization{ID: "acme", Name: "Acme", Domain: "acme.com", CreatedAt: time.Now()}
+	for _, org := range []legitima.Organization{acme, {ID: "other", Name: "Other", CreatedAt: time.Now()}, {ID: "empty", Name: "Empty", CreatedAt: time.Now()}} {
+		err := storage.SaveOrganization(org)
+		if err != nil {
+			t.Fatalf("failed to save organization: %v", err)
+		}
+	}
+	err := storage.SaveOrganization(legitima.Organization{ID: "acme2", Name: "Acme", Domain: "acme.com", CreatedAt: time.Now()})
+	if !errors.Is(err, legitima.ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists for a taken domain, got %v", err)
+	}
+
+	// Users of the domain join the organization when they are saved.
+	email := "jojo@ACME.com"
+	for i := 0; i < 2; i++ {
+		err = storage.SaveUser(legitima.Identity{Name: "Jojo", Subject: "123", Email: email})
+		if err != nil {
+			t.Fatalf("failed to save user: %v", err)
+		}
+	}
+	err = storage.SaveMembership(legitima.Membership{OrgID: "other", Email: email, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("failed to save membership: %v", err)
+	}
+	err = storage.SaveMembership(legitima.Membership{OrgID: "unknown", Email: email, CreatedAt: time.Now()})
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound joining an unknown organization, got %v", err)
+	}
+
+	orgs, err := storage.UserOrganizations(email)
+	if err != nil {
+		t.Fatalf("failed to list user organizations: %v", err)
+	}
+	if len(orgs) != 2 || orgs[0].ID != "acme" || orgs[0].Domain != "acme.com" || orgs[1].ID != "other" {
+		t.Fatalf("unexpected organizations: %+v", orgs)
+	}
+
+	org, err := storage.UserOrganization(email, "acme")
+	if err != nil {
+		t.Fatalf("failed to get user organization: %v", err)
+	}
+	if org.Name != acme.Name || org.CreatedAt.Unix() != acme.CreatedAt.Unix() {
+		t.Fatalf("expected %+v, got %+v", acme, org)
+	}
+	_, err = storage.UserOrganization(email, "empty")
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an organization of other users, got %v", err)
+	}
+
+	members, err := storage.Memberships("acme")
+	if err != nil {
+		t.Fatalf("failed to list memberships: %v", err)
+	}
+	if len(members) != 1 || members[0].Email != email {
+		t.Fatalf("unexpected members: %+v", members)
+	}
+}
@@ -0,0 +1,167 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+)
+
+// RefreshToken represents a refresh token in the database.
+type RefreshToken struct {
+	Hash      string        `db:"token_hash"`
+	FamilyID  string        `db:"family_id"`
+	Email     string        `db:"email"`
+	ClientID  string        `db:"client_id"`
+	Scope     string        `db:"scope"`
+	OrgID     string        `db:"org_id"`
+	ExpiresAt int64         `db:"expires_at"`
+	UsedAt    sql.NullInt64 `db:"used_at"`
+	Revoked   bool          `db:"revoked"`
+}
+
+// Convert a database refresh token to a legitima refresh token.
+func (tDB *RefreshToken) Convert() legitima.RefreshToken {
+	token := legitima.RefreshToken{
+		Hash:      tDB.Hash,
+		FamilyID:  tDB.FamilyID,
+		Email:     tDB.Email,
+		ClientID:  tDB.ClientID,
+		Scope:     tDB.Scope,
+		OrgID:     tDB.OrgID,
+		ExpiresAt: time.Unix(tDB.ExpiresAt, 0),
+		Revoked:   tDB.Revoked,
+	}
+	if tDB.UsedAt.Valid {
+		token.UsedAt = time.Unix(tDB.UsedAt.Int64, 0)
+	}
+	return token
+}
+
+// SaveRefreshToken saves a refresh token to the database.
+func (s *Storage) SaveRefreshToken(token legitima.RefreshToken) error {
+	_, err := s.db.Exec(`INSERT INTO refresh_tokens (token_hash, family_id, email, client_id, scope, org_id, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+		token.Hash, token.FamilyID, token.Email, token.ClientID, token.Scope, token.OrgID, token.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("save refresh token: %w", err)
+	}
+	return nil
+}
+
+// RefreshTokenByHash returns a refresh token from the database filtered by its hash.
+func (s *Storage) RefreshTokenByHash(hash string) (*legitima.RefreshToken, error) {
+	var token RefreshToken
+	err := s.db.QueryRow(`SELECT token_hash, family_id, email, client_id, scope, org_id, UNIX_TIMESTAMP(expires_at),
+		UNIX_TIMESTAMP(used_at), revoked FROM refresh_tokens WHERE token_hash = ?`, hash).
+		Scan(&token.Hash, &token.FamilyID, &token.Email, &token.ClientID, &token.Scope, &token.OrgID, &token.ExpiresAt,
+			&token.UsedAt, &token.Revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("refresh token by hash: %w", legitima.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("refresh token by hash: %w", err)
+	}
+
+	lToken := token.Convert()
+	return &lToken, nil
+}
+
+// RotateRefreshToken marks the used refresh token and saves the next one of the same family.
+// It returns legitima.ErrRefreshTokenReused if the token was already used.
+func (s *Storage) RotateRefreshToken(usedHash string, next legitima.RefreshToken) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("rotate refresh token: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.Exec(`UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = ? AND used_at IS NULL`, usedHash)
+	if err != nil {
+		return fmt.Errorf("rotate refresh token: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rotate refresh token: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("rotate refresh token: %w", legitima.ErrRefreshTokenReused)
+	}
+
+	_, err = tx.Exec(`INSERT INTO refresh_tokens (token_hash, family_id, email, client_id, scope, org_id, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+		next.Hash, next.FamilyID, next.Email, next.ClientID, next.Scope, next.OrgID, next.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("rotate refresh token: save next token: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("rotate refresh token: %w", err)
+	}
+	return nil
+}
+
+// RotateRefreshTokenFamily marks the usable refresh tokens of the family as used and saves the next one.
+// It returns legitima.ErrNotFound if the family has no usable token left.
+func (s *Storage) RotateRefreshTokenFamily(familyID string, next legitima.RefreshToken) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("rotate refresh token family: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.Exec(`UPDATE refresh_tokens SET used_at = NOW()
+		WHERE family_id = ? AND used_at IS NULL AND revoked = FALSE AND expires_at > NOW()`, familyID)
+	if err != nil {
+		return fmt.Errorf("rotate refresh token family: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rotate refresh token family: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("rotate refresh token family: %w", legitima.ErrNotFound)
+	}
+
+	_, err = tx.Exec(`INSERT INTO refresh_tokens (token_hash, family_id, email, client_id, scope, org_id, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+		next.Hash, next.FamilyID, next.Email, next.ClientID, next.Scope, next.OrgID, next.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("rotate refresh token family: save next token: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("rotate refresh token family: %w", err)
+	}
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token of the given family.
+func (s *Storage) RevokeRefreshTokenFamily(familyID string) error {
+	_, err := s.db.Exec(`UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = ?`, familyID)
+	if err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of the given user.
+func (s *Storage) RevokeUserRefreshTokens(email string) error {
+	_, err := s.db.Exec(`UPDATE refresh_tokens SET revoked = TRUE WHERE email = ?`, email)
+	if err != nil {
+		return fmt.Errorf("revoke user refresh tokens: %w", err)
+	}
+	return nil
+}
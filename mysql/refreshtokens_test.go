@@ -0,0 +1,133 @@
+//go:build integration
+// +build integration
+
+package mysql_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/mysql"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	first := legitima.RefreshToken{
+		Hash:      "first",
+		FamilyID:  "family",
+		Email:     "jojo@gmail.com",
+		ClientID:  "app",
+		Scope:     "openid email",
+		OrgID:     "org",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	err := storage.SaveRefreshToken(first)
+	if err != nil {
+		t.Fatalf("failed to save refresh token: %v", err)
+	}
+
+	second := first
+	second.Hash = "second"
+	err = storage.RotateRefreshToken(first.Hash, second)
+	if err != nil {
+		t.Fatalf("failed to rotate refresh token: %v", err)
+	}
+
+	used, err := storage.RefreshTokenByHash(first.Hash)
+	if err != nil {
+		t.Fatalf("failed to get refresh token: %v", err)
+	}
+	if used.UsedAt.IsZero() {
+		t.Fatal("expected the rotated token to be used")
+	}
+	if used.ExpiresAt.Unix() != first.ExpiresAt.Unix() {
+		t.Fatalf("expected expires at %v, got %v", first.ExpiresAt, used.ExpiresAt)
+	}
+	next, err := storage.RefreshTokenByHash(second.Hash)
+	if err != nil {
+		t.Fatalf("failed to get refresh token: %v", err)
+	}
+	if next.ClientID != first.ClientID || next.Scope != first.Scope || next.OrgID != first.OrgID {
+		t.Fatalf("expected scope %q of client %q and org %q, got %+v", first.Scope, first.ClientID, first.OrgID, next)
+	}
+
+	third := first
+	third.Hash = "third"
+	err = storage.RotateRefreshToken(first.Hash, third)
+	if !errors.Is(err, legitima.ErrRefreshTokenReused) {
+		t.Fatalf("expected %v, got %v", legitima.ErrRefreshTokenReused, err)
+	}
+	_, err = storage.RefreshTokenByHash(third.Hash)
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected %v, got %v", legitima.ErrNotFound, err)
+	}
+
+	err = storage.RevokeRefreshTokenFamily(first.FamilyID)
+	if err != nil {
+		t.Fatalf("failed to revoke refresh token family: %v", err)
+	}
+	revoked, err := storage.RefreshTokenByHash(second.Hash)
+	if err != nil {
+		t.Fatalf("failed to get refresh token: %v", err)
+	}
+	if !revoked.Revoked {
+		t.Fatal("expected the whole family to be revoked")
+	}
+}
+
+func TestRotateRefreshTokenFamily(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	first := legitima.RefreshToken{
+		Hash:      "first",
+		FamilyID:  "family",
+		Email:     "jojo@gmail.com",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	err := storage.SaveRefreshToken(first)
+	if err != nil {
+		t.Fatalf("failed to save refresh token: %v", err)
+	}
+
+	next := first
+	next.Hash = "next"
+	next.OrgID = "org"
+	err = storage.RotateRefreshTokenFamily(first.FamilyID, next)
+	if err != nil {
+		t.Fatalf("failed to rotate refresh token family: %v", err)
+	}
+	used, err := storage.RefreshTokenByHash(first.Hash)
+	if err != nil {
+		t.Fatalf("failed to get refresh token: %v", err)
+	}
+	if used.UsedAt.IsZero() {
+		t.Fatal("expected the previous token of the family to be used")
+	}
+	got, err := storage.RefreshTokenByHash(next.Hash)
+	if err != nil {
+		t.Fatalf("failed to get refresh token: %v", err)
+	}
+	if got.OrgID != next.OrgID || !got.UsedAt.IsZero() {
+		t.Fatalf("expected the next token in %q, got %+v", next.OrgID, got)
+	}
+
+	err = storage.RevokeRefreshTokenFamily(first.FamilyID)
+	if err != nil {
+		t.Fatalf("failed to revoke refresh token family: %v", err)
+	}
+	third := first
+	third.Hash = "third"
+	err = storage.RotateRefreshTokenFamily(first.FamilyID, third)
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound rotating a revoked family, got %v", err)
+	}
+}
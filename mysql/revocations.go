@@ -0,0 +1,61 @@
+package mysql
+
+import (
+	"fmt"
+	"time"
+)
+
+// RevokeToken revokes the token with the given jti until it expires.
+func (s *Storage) RevokeToken(jti string, expiresAt time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, FROM_UNIXTIME(?))
+		ON DUPLICATE KEY UPDATE expires_at = VALUES(expires_at)`, jti, expiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserTokens revokes all the tokens of the given user issued until revokedAt.
+// The revocation is kept until expiresAt, when all those tokens are expired.
+func (s *Storage) RevokeUserTokens(email string, revokedAt, expiresAt time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO user_revocations (email, revoked_before, expires_at)
+		VALUES (?, FROM_UNIXTIME(?), FROM_UNIXTIME(?))
+		ON DUPLICATE KEY UPDATE revoked_before = VALUES(revoked_before), expires_at = VALUES(expires_at)`,
+		email, revokedAt.Unix(), expiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("revoke user tokens: %w", err)
+	}
+	return nil
+}
+
+// IsTokenRevoked tells if the token was revoked by its jti or by a revocation of all the tokens of its user.
+// Times have a precision of seconds, so a token issued in the second of a user revocation stays valid:
+// otherwise logging in right after being revoked would issue already revoked tokens.
+func (s *Storage) IsTokenRevoked(jti, email string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRow(`SELECT
+		EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?) OR
+		EXISTS(SELECT 1 FROM user_revocations WHERE email = ? AND revoked_before > FROM_UNIXTIME(?))`,
+		jti, email, issuedAt.Unix()).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("is token revoked: %w", err)
+	}
+	return revoked, nil
+}
+
+// DeleteExpiredRevocations deletes the revocations expired at the given time.
+func (s *Storage) DeleteExpiredRevocations(now time.Time) (int64, error) {
+	var deleted int64
+	for _, table := range []string{"revoked_tokens", "user_revocations"} {
+		res, err := s.db.Exec(`DELETE FROM `+table+` WHERE expires_at < FROM_UNIXTIME(?)`, now.Unix())
+		if err != nil {
+			return deleted, fmt.Errorf("delete expired revocations: %s: %w", table, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, fmt.Errorf("delete expired revocations: %s: %w", table, err)
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
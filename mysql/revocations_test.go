@@ -0,0 +1,61 @@
+//go:build integration
+// +build integration
+
+package mysql_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima/mysql"
+)
+
+func TestRevocations(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+	now := time.Now()
+
+	err := storage.RevokeToken("jti", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to revoke token: %v", err)
+	}
+	err = storage.RevokeUserTokens("jojo@gmail.com", now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to revoke user tokens: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		jti      string
+		email    string
+		issuedAt time.Time
+		want     bool
+	}{
+		{name: "revoked jti", jti: "jti", email: "other@gmail.com", issuedAt: now, want: true},
+		{name: "issued before user revocation", jti: "other", email: "jojo@gmail.com", issuedAt: now.Add(-time.Minute), want: true},
+		{name: "issued after user revocation", jti: "other", email: "jojo@gmail.com", issuedAt: now.Add(time.Minute)},
+		{name: "issued the second of user revocation", jti: "other", email: "jojo@gmail.com", issuedAt: now},
+		{name: "not revoked", jti: "other", email: "other@gmail.com", issuedAt: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storage.IsTokenRevoked(tt.jti, tt.email, tt.issuedAt)
+			if err != nil {
+				t.Fatalf("failed to check revocation: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	n, err := storage.DeleteExpiredRevocations(now.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatalf("failed to delete expired revocations: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 deleted revocations, got %d", n)
+	}
+}
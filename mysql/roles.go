@@ -0,0 +1,132 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/go-sql-driver/mysql"
+)
+
+// errNoReferencedRow is the MySQL error number of foreign keys referencing missing rows.
+const errNoReferencedRow = 1452
+
+func isMissingReference(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == errNoReferencedRow
+}
+
+// Role represents a role in the database.
+type Role struct {
+	Name        string `db:"name"`
+	Description string `db:"description"`
+	CreatedAt   int64  `db:"created_at"`
+}
+
+// Convert a database role to a legitima role.
+func (rDB *Role) Convert() legitima.Role {
+	return legitima.Role{
+		Name:        rDB.Name,
+		Description: rDB.Description,
+		CreatedAt:   time.Unix(rDB.CreatedAt, 0),
+	}
+}
+
+// SaveRole saves a new role to the database.
+func (s *Storage) SaveRole(role legitima.Role) error {
+	_, err := s.db.Exec(`INSERT INTO roles (name, description, created_at) VALUES (?, ?, FROM_UNIXTIME(?))`,
+		role.Name, role.Description, role.CreatedAt.Unix())
+	if isDuplicateEntry(err) {
+		return fmt.Errorf("save role: %w", legitima.ErrAlreadyExists)
+	}
+	if err != nil {
+		return fmt.Errorf("save role: %w", err)
+	}
+	return nil
+}
+
+// Roles returns every role, ordered by name.
+func (s *Storage) Roles() ([]legitima.Role, error) {
+	rows, err := s.db.Query(`SELECT name, description, UNIX_TIMESTAMP(created_at) FROM roles ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("roles: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var roles []legitima.Role
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(&role.Name, &role.Description, &role.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("roles: scanning: %w", err)
+		}
+		roles = append(roles, role.Convert())
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("roles: %w", err)
+	}
+	return roles, nil
+}
+
+// DeleteRole deletes a role from the database, revoking it from every user.
+func (s *Storage) DeleteRole(name string) error {
+	res, err := s.db.Exec(`DELETE FROM roles WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("delete role: %w", err)
+	}
+	return expectAffected(res, "delete role")
+}
+
+// GrantRole grants a role to the user with the given email, who may not have logged in yet.
+// Granting a role twice is not an error. It returns legitima.ErrNotFound if the role does not exist.
+func (s *Storage) GrantRole(email, role string) error {
+	_, err := s.db.Exec(`INSERT INTO user_roles (email, role) VALUES (?, ?)`, email, role)
+	if isDuplicateEntry(err) {
+		return nil
+	}
+	if isMissingReference(err) {
+		return fmt.Errorf("grant role: %w", legitima.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("grant role: %w", err)
+	}
+	return nil
+}
+
+// RevokeRole revokes a role from the user with the given email.
+// It returns legitima.ErrNotFound if the user did not have the role.
+func (s *Storage) RevokeRole(email, role string) error {
+	res, err := s.db.Exec(`DELETE FROM user_roles WHERE email = ? AND role = ?`, email, role)
+	if err != nil {
+		return fmt.Errorf("revoke role: %w", err)
+	}
+	return expectAffected(res, "revoke role")
+}
+
+// UserRoles returns the names of the roles granted to the user with the given email, ordered by name.
+func (s *Storage) UserRoles(email string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT role FROM user_roles WHERE email = ? ORDER BY role`, email)
+	if err != nil {
+		return nil, fmt.Errorf("user roles: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, fmt.Errorf("user roles: scanning: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("user roles: %w", err)
+	}
+	return roles, nil
+}
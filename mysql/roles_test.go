@@ -0,0 +1,84 @@
+//go:build integration
+// +build integration
+
+package mysql_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/mysql"
+)
+
+func TestRoles(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	for _, name := range []string{legitima.RoleAdmin, "support"} {
+		err := storage.SaveRole(legitima.Role{Name: name, CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatalf("failed to save role: %v", err)
+		}
+	}
+	err := storage.SaveRole(legitima.Role{Name: "support", CreatedAt: time.Now()})
+	if !errors.Is(err, legitima.ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists, got %v", err)
+	}
+	roles, err := storage.Roles()
+	if err != nil {
+		t.Fatalf("failed to list roles: %v", err)
+	}
+	if len(roles) != 2 || roles[0].Name != legitima.RoleAdmin {
+		t.Fatalf("unexpected roles: %+v", roles)
+	}
+
+	email := "jojo@example.com"
+	for _, role := range []string{"support", legitima.RoleAdmin, "support"} {
+		err = storage.GrantRole(email, role)
+		if err != nil {
+			t.Fatalf("failed to grant role %s: %v", role, err)
+		}
+	}
+	err = storage.GrantRole(email, "unknown")
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound granting an unknown role, got %v", err)
+	}
+
+	err = storage.SaveUser(legitima.Identity{Name: "Jojo", Subject: "123", Email: email})
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	usr, err := storage.UserByEmail(email)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if want := []string{legitima.RoleAdmin, "support"}; !reflect.DeepEqual(usr.Roles, want) {
+		t.Fatalf("expected roles %v, got %v", want, usr.Roles)
+	}
+
+	err = storage.RevokeRole(email, legitima.RoleAdmin)
+	if err != nil {
+		t.Fatalf("failed to revoke role: %v", err)
+	}
+	err = storage.RevokeRole(email, legitima.RoleAdmin)
+	if !errors.Is(err, legitima.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound revoking twice, got %v", err)
+	}
+
+	err = storage.DeleteRole("support")
+	if err != nil {
+		t.Fatalf("failed to delete role: %v", err)
+	}
+	got, err := storage.UserRoles(email)
+	if err != nil {
+		t.Fatalf("failed to get user roles: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected the deleted role to be revoked, got %v", got)
+	}
+}
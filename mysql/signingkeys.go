@@ -0,0 +1,134 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+)
+
+// SigningKey represents a signing key in the database.
+// The private key is stored in plaintext PEM, so access to the signing_keys
+// table must be restricted like access to the key itself.
+type SigningKey struct {
+	ID          string        `db:"id"`
+	State       string        `db:"state"`
+	PrivateKey  string        `db:"private_key"`
+	CreatedAt   int64         `db:"created_at"`
+	ActivatedAt sql.NullInt64 `db:"activated_at"`
+	RetiredAt   sql.NullInt64 `db:"retired_at"`
+}
+
+// Convert a database signing key to a legitima signing key.
+func (kDB *SigningKey) Convert() legitima.SigningKey {
+	key := legitima.SigningKey{
+		ID:         kDB.ID,
+		State:      legitima.KeyState(kDB.State),
+		PrivateKey: kDB.PrivateKey,
+		CreatedAt:  time.Unix(kDB.CreatedAt, 0),
+	}
+	if kDB.ActivatedAt.Valid {
+		key.ActivatedAt = time.Unix(kDB.ActivatedAt.Int64, 0)
+	}
+	if kDB.RetiredAt.Valid {
+		key.RetiredAt = time.Unix(kDB.RetiredAt.Int64, 0)
+	}
+	return key
+}
+
+// SigningKeys returns the signing keys that are not retired.
+func (s *Storage) SigningKeys() ([]legitima.SigningKey, error) {
+	rows, err := s.db.Query(`SELECT id, state, private_key, UNIX_TIMESTAMP(created_at), UNIX_TIMESTAMP(activated_at)
+		FROM signing_keys WHERE state != ? ORDER BY created_at`, legitima.KeyStateRetired)
+	if err != nil {
+		return nil, fmt.Errorf("signing keys: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var keys []legitima.SigningKey
+	for rows.Next() {
+		var key SigningKey
+		err = rows.Scan(&key.ID, &key.State, &key.PrivateKey, &key.CreatedAt, &key.ActivatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("signing keys: scanning: %w", err)
+		}
+		keys = append(keys, key.Convert())
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("signing keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RotateSigningKeys moves every signing key one state forward and saves next as the new next key.
+// Nothing is changed if activeID is no longer the active key, so concurrent rotations happen only once.
+// An empty activeID means there is no active key yet.
+func (s *Storage) RotateSigningKeys(activeID string, next legitima.SigningKey) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("rotate signing keys: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var currentID string
+	err = tx.QueryRow(`SELECT id FROM signing_keys WHERE state = ? FOR UPDATE`, legitima.KeyStateActive).Scan(&currentID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("rotate signing keys: active key: %w", err)
+	}
+	if currentID != activeID {
+		return tx.Rollback()
+	}
+
+	_, err = tx.Exec(`UPDATE signing_keys SET state = ?, retired_at = NOW() WHERE state = ?`,
+		legitima.KeyStateRetired, legitima.KeyStateRetiring)
+	if err != nil {
+		return fmt.Errorf("rotate signing keys: retiring to retired: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE signing_keys SET state = ? WHERE state = ?`,
+		legitima.KeyStateRetiring, legitima.KeyStateActive)
+	if err != nil {
+		return fmt.Errorf("rotate signing keys: active to retiring: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE signing_keys SET state = ?, activated_at = NOW() WHERE state = ?`,
+		legitima.KeyStateActive, legitima.KeyStateNext)
+	if err != nil {
+		return fmt.Errorf("rotate signing keys: next to active: %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO signing_keys (id, state, private_key) VALUES (?, ?, ?)`,
+		next.ID, legitima.KeyStateNext, next.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("rotate signing keys: save next key: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("rotate signing keys: %w", err)
+	}
+	return nil
+}
+
+// DeleteRetiredSigningKeys deletes the keys retired before the given time, so the
+// private keys are not kept around once no token signed by them can be valid.
+// Keys retired before retired_at was recorded are deleted as well.
+// It returns the number of deleted keys.
+func (s *Storage) DeleteRetiredSigningKeys(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM signing_keys WHERE state = ? AND (retired_at IS NULL OR retired_at < FROM_UNIXTIME(?))`,
+		legitima.KeyStateRetired, before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("delete retired signing keys: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete retired signing keys: %w", err)
+	}
+	return n, nil
+}
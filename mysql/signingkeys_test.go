@@ -0,0 +1,154 @@
+//go:build integration
+// +build integration
+
+package mysql_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/birdie-ai/legitima"
+	"github.com/birdie-ai/legitima/mysql"
+)
+
+func states(t *testing.T, storage *mysql.Storage) map[string]legitima.KeyState {
+	t.Helper()
+	keys, err := storage.SigningKeys()
+	if err != nil {
+		t.Fatalf("failed to get signing keys: %v", err)
+	}
+	got := map[string]legitima.KeyState{}
+	for _, k := range keys {
+		got[k.ID] = k.State
+	}
+	return got
+}
+
+func TestRotateSigningKeys(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	rotations := []struct {
+		activeID string
+		next     string
+		want     map[string]legitima.KeyState
+	}{
+		{
+			activeID: "",
+			next:     "k1",
+			want:     map[string]legitima.KeyState{"k1": legitima.KeyStateNext},
+		},
+		{
+			activeID: "",
+			next:     "k2",
+			want:     map[string]legitima.KeyState{"k1": legitima.KeyStateActive, "k2": legitima.KeyStateNext},
+		},
+		{
+			activeID: "k1",
+			next:     "k3",
+			want: map[string]legitima.KeyState{
+				"k1": legitima.KeyStateRetiring,
+				"k2": legitima.KeyStateActive,
+				"k3": legitima.KeyStateNext,
+			},
+		},
+		{
+			activeID: "k2",
+			next:     "k4",
+			want: map[string]legitima.KeyState{
+				"k2": legitima.KeyStateRetiring,
+				"k3": legitima.KeyStateActive,
+				"k4": legitima.KeyStateNext,
+			},
+		},
+	}
+	for _, r := range rotations {
+		err := storage.RotateSigningKeys(r.activeID, legitima.SigningKey{ID: r.next, PrivateKey: "pem"})
+		if err != nil {
+			t.Fatalf("failed to rotate signing keys: %v", err)
+		}
+		got := states(t, storage)
+		if len(got) != len(r.want) {
+			t.Fatalf("expected keys %v, got %v", r.want, got)
+		}
+		for id, state := range r.want {
+			if got[id] != state {
+				t.Fatalf("expected key %s to be %s, got %s", id, state, got[id])
+			}
+		}
+	}
+
+	keys, err := storage.SigningKeys()
+	if err != nil {
+		t.Fatalf("failed to get signing keys: %v", err)
+	}
+	for _, k := range keys {
+		if k.State == legitima.KeyStateActive && k.ActivatedAt.IsZero() {
+			t.Fatalf("expected activated_at for the active key %s", k.ID)
+		}
+	}
+}
+
+func TestRotateSigningKeysStaleActive(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	for _, next := range []string{"k1", "k2"} {
+		err := storage.RotateSigningKeys("", legitima.SigningKey{ID: next, PrivateKey: "pem"})
+		if err != nil {
+			t.Fatalf("failed to rotate signing keys: %v", err)
+		}
+	}
+
+	err := storage.RotateSigningKeys("stale", legitima.SigningKey{ID: "k3", PrivateKey: "pem"})
+	if err != nil {
+		t.Fatalf("failed to rotate signing keys: %v", err)
+	}
+	got := states(t, storage)
+	if len(got) != 2 || got["k1"] != legitima.KeyStateActive {
+		t.Fatalf("expected no rotation with a stale active key, got %v", got)
+	}
+}
+
+func TestDeleteRetiredSigningKeys(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	activeID := ""
+	for _, next := range []string{"k1", "k2", "k3", "k4"} {
+		err := storage.RotateSigningKeys(activeID, legitima.SigningKey{ID: next, PrivateKey: "pem"})
+		if err != nil {
+			t.Fatalf("failed to rotate signing keys: %v", err)
+		}
+		for id, state := range states(t, storage) {
+			if state == legitima.KeyStateActive {
+				activeID = id
+			}
+		}
+	}
+
+	n, err := storage.DeleteRetiredSigningKeys(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to delete retired signing keys: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("expected no key retired an hour ago, deleted %d", n)
+	}
+
+	n, err = storage.DeleteRetiredSigningKeys(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("failed to delete retired signing keys: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected the retired key k1 to be deleted, deleted %d", n)
+	}
+	if got := states(t, storage); len(got) != 3 {
+		t.Fatalf("expected the keys that are not retired to be kept, got %v", got)
+	}
+}
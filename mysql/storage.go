@@ -3,6 +3,7 @@ package mysql
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/birdie-ai/legitima"
@@ -18,19 +19,56 @@ func NewStorage(db *sql.DB) *Storage {
 	return &Storage{db: db}
 }
 
-// SaveUser saves a user to the database.
-func (s *Storage) SaveUser(gUsr legitima.GoogleUser) error {
-	usr := newUser(gUsr)
+// SaveUser saves a user to the database, joining them to the organization of their email domain, if any.
+// The first identity saved with an email is linked to it: saving another identity with the same email,
+// or the same identity with another email, fails with legitima.ErrIdentityConflict.
+func (s *Storage) SaveUser(identity legitima.Identity) (err error) {
+	usr := newUser(identity)
 
-	_, err := s.db.Exec(`INSERT INTO users (id, name, email) 
-		VALUES (?, ?, ?)
-		ON DUPLICATE KEY UPDATE name = IF(name != VALUES(name), VALUES(name), name)
-		`, usr.ID, usr.Name, usr.Email)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("save user: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
 
+	var provider, subject sql.NullString
+	err = tx.QueryRow(`SELECT provider, subject FROM users WHERE email = ? FOR UPDATE`, usr.Email).Scan(&provider, &subject)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = tx.Exec(`INSERT INTO users (id, name, email, email_verified, picture, provider, subject)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			usr.ID, usr.Name, usr.Email, usr.EmailVerified, usr.Picture, usr.Provider, usr.Subject)
+	case err != nil:
+		return fmt.Errorf("save user: %w", err)
+	case provider.Valid && (provider != usr.Provider || subject != usr.Subject):
+		return fmt.Errorf("save user: %w", legitima.ErrIdentityConflict)
+	default:
+		// Users saved before the identities were stored are linked to the first one they log in with.
+		_, err = tx.Exec(`UPDATE users SET name = ?, email_verified = ?, picture = ?, provider = ?, subject = ? WHERE email = ?`,
+			usr.Name, usr.EmailVerified, usr.Picture, usr.Provider, usr.Subject, usr.Email)
+	}
+	if isDuplicateEntry(err) {
+		return fmt.Errorf("save user: %w", legitima.ErrIdentityConflict)
+	}
 	if err != nil {
 		return fmt.Errorf("save user: %w", err)
 	}
 
+	_, err = tx.Exec(`INSERT INTO memberships (org_id, email)
+		SELECT id, ? FROM organizations WHERE domain = ?
+		ON DUPLICATE KEY UPDATE email = email`, usr.Email, legitima.EmailDomain(usr.Email))
+	if err != nil {
+		return fmt.Errorf("save user: joining organization: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("save user: %w", err)
+	}
 	return nil
 }
 
@@ -38,11 +76,19 @@ func (s *Storage) SaveUser(gUsr legitima.GoogleUser) error {
 func (s *Storage) UserByEmail(email string) (*legitima.User, error) {
 	// var usr legitima.User
 	var usr User
-	err := s.db.QueryRow(`SELECT id, name, email FROM users WHERE email = ?`, email).Scan(&usr.ID, &usr.Name, &usr.Email)
+	err := s.db.QueryRow(`SELECT id, name, email, email_verified, picture FROM users WHERE email = ?`, email).
+		Scan(&usr.ID, &usr.Name, &usr.Email, &usr.EmailVerified, &usr.Picture)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user by email: %w", legitima.ErrNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("user by email: %w", err)
 	}
 
 	lUsr := usr.Convert()
+	lUsr.Roles, err = s.UserRoles(email)
+	if err != nil {
+		return nil, fmt.Errorf("user by email: %w", err)
+	}
 	return &lUsr, nil
 }
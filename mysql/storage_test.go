@@ -5,6 +5,7 @@ package mysql_test
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -68,13 +69,13 @@ func TestSaveUser(t *testing.T) {
 
 	storage := mysql.NewStorage(db)
 
-	gUsr := legitima.GoogleUser{
-		Name:  "JojO",
-		ID:    "123",
-		Email: "jojo@example.com",
+	identity := legitima.Identity{
+		Name:    "JojO",
+		Subject: "123",
+		Email:   "jojo@example.com",
 	}
 
-	err := storage.SaveUser(gUsr)
+	err := storage.SaveUser(identity)
 	if err != nil {
 		t.Fatalf("failed to save user: %v", err)
 	}
@@ -95,18 +96,18 @@ func TestSaveUserTwice(t *testing.T) {
 
 	storage := mysql.NewStorage(db)
 
-	gUsr := legitima.GoogleUser{
-		Name:  "JojO",
-		ID:    "123",
-		Email: "jojo@gmail.com",
+	identity := legitima.Identity{
+		Name:    "JojO",
+		Subject: "123",
+		Email:   "jojo@gmail.com",
 	}
 
-	err := storage.SaveUser(gUsr)
+	err := storage.SaveUser(identity)
 	if err != nil {
 		t.Fatalf("failed to save user: %v", err)
 	}
 
-	err = storage.SaveUser(gUsr)
+	err = storage.SaveUser(identity)
 	if err != nil {
 		t.Fatalf("failed to save user: %v", err)
 	}
@@ -127,24 +128,24 @@ func TestSaveUserUpdateName(t *testing.T) {
 
 	storage := mysql.NewStorage(db)
 
-	gUsr := legitima.GoogleUser{
-		Name:  "JojO",
-		ID:    "123",
-		Email: "jojo@gmail.com",
+	identity := legitima.Identity{
+		Name:    "JojO",
+		Subject: "123",
+		Email:   "jojo@gmail.com",
 	}
 
-	gUsr2 := legitima.GoogleUser{
-		Name:  "JojO2",
-		ID:    "123",
-		Email: "jojo@gmail.com",
+	identity2 := legitima.Identity{
+		Name:    "JojO2",
+		Subject: "123",
+		Email:   "jojo@gmail.com",
 	}
 
-	err := storage.SaveUser(gUsr)
+	err := storage.SaveUser(identity)
 	if err != nil {
 		t.Fatalf("failed to save user: %v", err)
 	}
 
-	err = storage.SaveUser(gUsr2)
+	err = storage.SaveUser(identity2)
 	if err != nil {
 		t.Fatalf("failed to save user: %v", err)
 	}
@@ -155,12 +156,61 @@ func TestSaveUserUpdateName(t *testing.T) {
 		t.Fatalf("failed to select from users: %v", err)
 	}
 
-	usr, err := storage.UserByEmail(gUsr.Email)
+	usr, err := storage.UserByEmail(identity.Email)
 	if err != nil {
 		t.Fatalf("failed to get user by email: %v", err)
 	}
-	if usr.Name != gUsr2.Name {
-		t.Fatalf("expected name %s, got %s", gUsr2.Name, usr.Name)
+	if usr.Name != identity2.Name {
+		t.Fatalf("expected name %s, got %s", identity2.Name, usr.Name)
+	}
+}
+
+func TestSaveUserIdentityConflict(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	err := storage.SaveUser(legitima.Identity{Provider: "google", Subject: "123", Email: "jojo@gmail.com"})
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+
+	conflicts := []legitima.Identity{
+		{Provider: "github", Subject: "123", Email: "jojo@gmail.com"},
+		{Provider: "google", Subject: "456", Email: "jojo@gmail.com"},
+		{Provider: "google", Subject: "123", Email: "other@gmail.com"},
+	}
+	for _, identity := range conflicts {
+		err = storage.SaveUser(identity)
+		if !errors.Is(err, legitima.ErrIdentityConflict) {
+			t.Fatalf("expected %v saving %+v, got %v", legitima.ErrIdentityConflict, identity, err)
+		}
+	}
+
+	err = storage.SaveUser(legitima.Identity{Provider: "google", Subject: "123", Email: "jojo@gmail.com", Name: "JojO"})
+	if err != nil {
+		t.Fatalf("failed to save user again: %v", err)
+	}
+}
+
+func TestSaveUserLinksLegacyUser(t *testing.T) {
+	db, dbName := Setup(t)
+	defer Teardown(t, db, dbName)
+
+	storage := mysql.NewStorage(db)
+
+	err := storage.SaveUser(legitima.Identity{Email: "jojo@gmail.com"})
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	err = storage.SaveUser(legitima.Identity{Provider: "google", Subject: "123", Email: "jojo@gmail.com"})
+	if err != nil {
+		t.Fatalf("expected a user without identity to be linked, got %v", err)
+	}
+	err = storage.SaveUser(legitima.Identity{Provider: "github", Subject: "123", Email: "jojo@gmail.com"})
+	if !errors.Is(err, legitima.ErrIdentityConflict) {
+		t.Fatalf("expected %v once linked, got %v", legitima.ErrIdentityConflict, err)
 	}
 }
 
@@ -170,26 +220,31 @@ func TestUserByEmail(t *testing.T) {
 
 	storage := mysql.NewStorage(db)
 
-	gUsr := legitima.GoogleUser{
-		Name:  "JojO",
-		ID:    "123",
-		Email: "jojo@gmail.com",
+	identity := legitima.Identity{
+		Name:          "JojO",
+		Subject:       "123",
+		Email:         "jojo@gmail.com",
+		EmailVerified: true,
+		Picture:       "https://example.com/jojo.png",
 	}
 
-	err := storage.SaveUser(gUsr)
+	err := storage.SaveUser(identity)
 	if err != nil {
 		t.Fatalf("failed to save user: %v", err)
 	}
 
-	usr, err := storage.UserByEmail(gUsr.Email)
+	usr, err := storage.UserByEmail(identity.Email)
 	if err != nil {
 		t.Fatalf("failed to get user by email: %v", err)
 	}
-	if usr.Name != gUsr.Name {
-		t.Fatalf("expected name %s, got %s", gUsr.Name, usr.Name)
+	if usr.Name != identity.Name {
+		t.Fatalf("expected name %s, got %s", identity.Name, usr.Name)
 	}
 
-	if usr.Email != gUsr.Email {
-		t.Fatalf("expected email %s, got %s", gUsr.Email, usr.Email)
+	if usr.Email != identity.Email {
+		t.Fatalf("expected email %s, got %s", identity.Email, usr.Email)
+	}
+	if !usr.EmailVerified || usr.Picture != identity.Picture {
+		t.Fatalf("expected verified email and picture %s, got %+v", identity.Picture, usr)
 	}
 }
@@ -1,6 +1,8 @@
 package mysql
 
 import (
+	"database/sql"
+
 	"github.com/birdie-ai/legitima"
 	"github.com/google/uuid"
 )
@@ -10,21 +12,35 @@ type User struct {
 	ID    string `db:"id"`
 	Name  string `db:"name"`
 	Email string `db:"email"`
+	// EmailVerified tells if the identity provider verified the email.
+	EmailVerified bool           `db:"email_verified"`
+	Picture       sql.NullString `db:"picture"`
+	// Provider and Subject identify the user at the identity provider they logged in with.
+	// They are NULL for the users saved before the identities were stored.
+	Provider sql.NullString `db:"provider"`
+	Subject  sql.NullString `db:"subject"`
 }
 
-func newUser(gUsr legitima.GoogleUser) (u *User) {
+func newUser(identity legitima.Identity) (u *User) {
 	return &User{
-		ID:    uuid.New().String(),
-		Name:  gUsr.Name,
-		Email: gUsr.Email,
+		ID:            uuid.New().String(),
+		Name:          identity.Name,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		// Empty pictures are stored as NULL.
+		Picture:  sql.NullString{String: identity.Picture, Valid: identity.Picture != ""},
+		Provider: sql.NullString{String: identity.Provider, Valid: identity.Provider != ""},
+		Subject:  sql.NullString{String: identity.Subject, Valid: identity.Provider != ""},
 	}
 }
 
 // Convert  a database user to a legitima user.
 func (uDB *User) Convert() legitima.User {
 	return legitima.User{
-		ID:    uDB.ID,
-		Name:  uDB.Name,
-		Email: uDB.Email,
+		ID:            uDB.ID,
+		Name:          uDB.Name,
+		Email:         uDB.Email,
+		EmailVerified: uDB.EmailVerified,
+		Picture:       uDB.Picture.String,
 	}
 }
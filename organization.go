@@ -0,0 +1,28 @@
+package legitima
+
+import (
+	"strings"
+	"time"
+)
+
+// Organization is a customer organization, the tenant its users are grouped in.
+type Organization struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Domain, if set, makes the users with emails of the domain join the organization when they log in.
+	Domain    string    `json:"domain,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership tells that the user with the email belongs to the organization.
+type Membership struct {
+	OrgID     string    `json:"org_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EmailDomain returns the lower cased domain of the email.
+func EmailDomain(email string) string {
+	_, domain, _ := strings.Cut(email, "@")
+	return strings.ToLower(domain)
+}
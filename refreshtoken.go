@@ -0,0 +1,22 @@
+package legitima
+
+import "time"
+
+// RefreshToken represents a stored refresh token.
+// Only the hash of the opaque token is stored.
+type RefreshToken struct {
+	Hash string `json:"-"`
+	// FamilyID is shared by all the tokens rotated from the same login.
+	FamilyID string `json:"family_id"`
+	Email    string `json:"email"`
+	// ClientID is the OAuth client the session was authorized for, empty for the own login of legitima.
+	ClientID string `json:"client_id,omitempty"`
+	// Scope is the space separated list of scopes granted to the access tokens it refreshes.
+	Scope string `json:"scope,omitempty"`
+	// OrgID is the active organization of the access tokens it refreshes.
+	OrgID     string    `json:"org_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// UsedAt is set once the token is rotated.
+	UsedAt  time.Time `json:"used_at"`
+	Revoked bool      `json:"revoked"`
+}
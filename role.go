@@ -0,0 +1,13 @@
+package legitima
+
+import "time"
+
+// RoleAdmin is the role of the users managing legitima.
+const RoleAdmin = "admin"
+
+// Role is a named set of privileges granted to users.
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,30 @@
+package legitima
+
+import "time"
+
+// KeyState is the lifecycle state of a signing key.
+type KeyState string
+
+// Signing key states, in the order a key goes through them.
+const (
+	// KeyStateNext keys are published but not yet used to sign.
+	KeyStateNext KeyState = "next"
+	// KeyStateActive is the key used to sign new tokens.
+	KeyStateActive KeyState = "active"
+	// KeyStateRetiring keys no longer sign but still verify the tokens they signed.
+	KeyStateRetiring KeyState = "retiring"
+	// KeyStateRetired keys are neither used to sign nor to verify.
+	KeyStateRetired KeyState = "retired"
+)
+
+// SigningKey represents a key used to sign the issued tokens.
+type SigningKey struct {
+	ID    string   `json:"id"`
+	State KeyState `json:"state"`
+	// PrivateKey is the PEM encoded private key, stored as is: anyone reading
+	// the storage can sign tokens until the key is retired.
+	PrivateKey  string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	ActivatedAt time.Time `json:"activated_at"`
+	RetiredAt   time.Time `json:"retired_at"`
+}
@@ -6,16 +6,14 @@ type User struct {
 	ID    string `json:"id" db:"id"`
 	Name  string `json:"name" db:"name"`
 	Email string `json:"email" db:"email"`
+	// EmailVerified tells if the identity provider verified the email.
+	EmailVerified bool   `json:"email_verified" db:"email_verified"`
+	Picture       string `json:"picture,omitempty" db:"picture"`
+	// Roles are the names of the roles granted to the user.
+	Roles []string `json:"roles,omitempty" db:"-"`
 }
 
-// GoogleUser represents the user data returned by Google.
-type GoogleUser struct {
-	ID            string `json:"id"`
-	Email         string `json:"email"`
-	Name          string `json:"name"`
-	VerifiedEmail bool   `json:"verified_email"`
-	GivenName     string `json:"given_name"`
-	FamilyName    string `json:"family_name"`
-	Picture       string `json:"picture"`
-	Locale        string `json:"locale"`
+// HasRole tells if the role was granted to the user.
+func (u User) HasRole(role string) bool {
+	return contains(u.Roles, role)
 }